@@ -5,6 +5,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"time"
 
 	sdkPlugin "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin"
 	v1 "github.com/gatewayd-io/gatewayd/api/v1"
@@ -181,10 +182,23 @@ func (a *API) GetProxies(context.Context, *emptypb.Empty) (*structpb.Struct, err
 			busy = append(busy, conn)
 		}
 
+		healthCheck := proxy.HealthCheckResults()
+		lastCheck := ""
+		if !healthCheck.LastCheck.IsZero() {
+			lastCheck = healthCheck.LastCheck.Format(time.RFC3339)
+		}
+
 		proxies[name] = map[string]interface{}{
 			"available": available,
 			"busy":      busy,
 			"total":     len(available) + len(busy),
+			"healthCheck": map[string]interface{}{
+				"type":      string(proxy.HealthCheckType),
+				"healthy":   healthCheck.Healthy,
+				"failed":    healthCheck.Failed,
+				"replaced":  healthCheck.Replaced,
+				"lastCheck": lastCheck,
+			},
 		}
 	}
 	proxiesConfig, err := structpb.NewStruct(proxies)
@@ -201,11 +215,36 @@ func (a *API) GetServers(context.Context, *emptypb.Empty) (*structpb.Struct, err
 
 	servers := make(map[string]interface{}, 0)
 	for name, server := range a.Servers {
+		clientIPs := make(map[string]interface{}, 0)
+		for ip, count := range server.CountClientIPConnections() {
+			clientIPs[ip] = count
+		}
+
+		allowList, denyList := server.AccessLists()
+		allow := make([]interface{}, 0, len(allowList))
+		for _, ipNet := range allowList {
+			allow = append(allow, ipNet.String())
+		}
+		deny := make([]interface{}, 0, len(denyList))
+		for _, ipNet := range denyList {
+			deny = append(deny, ipNet.String())
+		}
+
 		servers[name] = map[string]interface{}{
 			"network":      server.Network,
 			"address":      server.Address,
 			"status":       uint(server.Status),
 			"tickInterval": server.TickInterval.Nanoseconds(),
+			"connections": map[string]interface{}{
+				"total":                     server.CountConnections(),
+				"perClientIP":               clientIPs,
+				"rejected":                  server.CountRejectedConnections(),
+				"maxConnections":            server.Options.MaxConnections,
+				"softLimit":                 server.Options.SoftLimit,
+				"maxConnectionsPerClientIP": server.Options.MaxConnectionsPerClientIP,
+			},
+			"allow": allow,
+			"deny":  deny,
 		}
 	}
 	serversConfig, err := structpb.NewStruct(servers)
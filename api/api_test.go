@@ -2,7 +2,11 @@ package api
 
 import (
 	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"regexp"
+	"strings"
 	"testing"
 
 	sdkPlugin "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin"
@@ -188,9 +192,10 @@ func TestGetProxies(t *testing.T) {
 		context.TODO(),
 		newPool,
 		nil,
-		false,
-		false,
-		config.DefaultHealthCheckPeriod,
+		nil,
+		&config.Proxy{
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+		},
 		&config.Client{
 			Network: config.DefaultNetwork,
 			Address: config.DefaultAddress,
@@ -213,6 +218,15 @@ func TestGetProxies(t *testing.T) {
 		assert.Equal(t, 1.0, defaultProxy["total"])
 		assert.NotEmpty(t, defaultProxy["available"])
 		assert.Empty(t, defaultProxy["busy"])
+		assert.Equal(t,
+			map[string]interface{}{
+				"type":      string(config.DefaultHealthCheckType),
+				"healthy":   0.0,
+				"failed":    0.0,
+				"replaced":  0.0,
+				"lastCheck": "",
+			},
+			defaultProxy["healthCheck"])
 	} else {
 		t.Errorf("proxies.default is not found or not a map")
 	}
@@ -233,9 +247,11 @@ func TestGetServers(t *testing.T) {
 		context.TODO(),
 		newPool,
 		nil,
-		true,
-		false,
-		config.DefaultHealthCheckPeriod,
+		nil,
+		&config.Proxy{
+			Elastic:           true,
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+		},
 		&config.Client{
 			Network: config.DefaultNetwork,
 			Address: config.DefaultAddress,
@@ -260,9 +276,12 @@ func TestGetServers(t *testing.T) {
 		config.DefaultAddress,
 		config.DefaultTickInterval,
 		network.Option{
-			EnableTicker: false,
+			EnableTicker:   false,
+			MaxConnections: 10,
+			Deny:           []*net.IPNet{{IP: net.IP{10, 1, 0, 0}, Mask: net.CIDRMask(16, 32)}},
 		},
 		proxy,
+		nil,
 		zerolog.Logger{},
 		pluginRegistry,
 		config.DefaultPluginTimeout,
@@ -297,7 +316,69 @@ func TestGetServers(t *testing.T) {
 		tickInterval, ok := defaultServer["tickInterval"].(float64)
 		assert.True(t, ok)
 		assert.Equal(t, config.DefaultTickInterval.Nanoseconds(), int64(tickInterval))
+		assert.Equal(t,
+			map[string]interface{}{
+				"total":                     float64(0),
+				"perClientIP":               map[string]interface{}{},
+				"rejected":                  float64(0),
+				"maxConnections":            float64(10),
+				"softLimit":                 float64(0),
+				"maxConnectionsPerClientIP": float64(0),
+			},
+			defaultServer["connections"])
+		assert.Equal(t, []interface{}{}, defaultServer["allow"])
+		assert.Equal(t, []interface{}{"10.1.0.0/16"}, defaultServer["deny"])
 	} else {
 		t.Errorf("servers.default is not found or not a map")
 	}
 }
+
+// TestAccessListsHandler tests that the access lists of the servers are returned and replaced,
+// and that invalid lists are rejected without replacing any of them.
+func TestAccessListsHandler(t *testing.T) {
+	server := network.NewServer(
+		context.TODO(),
+		config.DefaultNetwork,
+		config.DefaultAddress,
+		config.DefaultTickInterval,
+		network.Option{},
+		nil,
+		nil,
+		zerolog.Logger{},
+		nil,
+		config.DefaultPluginTimeout,
+		false,
+		"",
+		"",
+		config.DefaultHandshakeTimeout,
+	)
+	handler := accessListsHandler(&Options{
+		Logger:  zerolog.Nop(),
+		Servers: map[string]*network.Server{config.Default: server},
+	})
+	request := func(method, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(method, "/access-lists", strings.NewReader(body)))
+		return recorder
+	}
+
+	response := request(http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"default": {"allow": [], "deny": []}}`, response.Body.String())
+
+	response = request(http.MethodPut, `{"default": {"allow": ["10.0.0.0/8"], "deny": ["10.1.2.3"]}}`)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t,
+		`{"default": {"allow": ["10.0.0.0/8"], "deny": ["10.1.2.3/32"]}}`, response.Body.String())
+	allow, deny := server.AccessLists()
+	assert.Len(t, allow, 1)
+	assert.Len(t, deny, 1)
+
+	assert.Equal(t, http.StatusBadRequest,
+		request(http.MethodPut, `{"default": {"deny": ["10.0.0.0/33"]}}`).Code)
+	assert.Equal(t, http.StatusNotFound,
+		request(http.MethodPut, `{"unknown": {"deny": ["10.0.0.0/8"]}}`).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, request(http.MethodDelete, "").Code)
+	allow, _ = server.AccessLists()
+	assert.Len(t, allow, 1)
+}
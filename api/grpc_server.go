@@ -1,9 +1,8 @@
 package api
 
 import (
-	"net"
-
 	v1 "github.com/gatewayd-io/gatewayd/api/v1"
+	"github.com/gatewayd-io/gatewayd/network"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
@@ -11,9 +10,11 @@ import (
 
 // StartGRPCAPI starts the gRPC API.
 func StartGRPCAPI(api *API, healthchecker *HealthChecker) {
-	listener, err := net.Listen(api.Options.GRPCNetwork, api.Options.GRPCAddress)
+	// The listener is inherited from the previous process after a restart.
+	listener, err := network.Listen(api.Options.GRPCNetwork, api.Options.GRPCAddress)
 	if err != nil {
 		api.Options.Logger.Err(err).Msg("failed to start gRPC API")
+		return
 	}
 
 	grpcServer := grpc.NewServer()
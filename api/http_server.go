@@ -3,11 +3,15 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/fs"
+	"net"
 	"net/http"
 
 	v1 "github.com/gatewayd-io/gatewayd/api/v1"
 	"github.com/gatewayd-io/gatewayd/config"
+	"github.com/gatewayd-io/gatewayd/network"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
@@ -17,6 +21,12 @@ type Healthz struct {
 	Status string `json:"status"`
 }
 
+// AccessLists are the allow and deny lists of a server, as IP addresses or CIDRs.
+type AccessLists struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
 // StartHTTPAPI starts the HTTP API.
 func StartHTTPAPI(options *Options) {
 	ctx := context.Background()
@@ -60,6 +70,8 @@ func StartHTTPAPI(options *Options) {
 		}
 	})
 
+	mux.HandleFunc("/access-lists", accessListsHandler(options))
+
 	if IsSwaggerEmbedded() {
 		mux.HandleFunc("/swagger.json", func(writer http.ResponseWriter, r *http.Request) {
 			writer.WriteHeader(http.StatusOK)
@@ -78,8 +90,88 @@ func StartHTTPAPI(options *Options) {
 		mux.Handle("/swagger-ui/", http.StripPrefix("/swagger-ui/", http.FileServer(http.FS(fsys))))
 	}
 
-	// Start HTTP server (and proxy calls to gRPC server endpoint)
-	if err := http.ListenAndServe(options.HTTPAddress, mux); err != nil { //nolint:gosec
+	// Start HTTP server (and proxy calls to gRPC server endpoint).
+	// The listener is inherited from the previous process after a restart.
+	listener, err := network.Listen("tcp", options.HTTPAddress)
+	if err != nil {
 		options.Logger.Err(err).Msg("failed to start HTTP API")
+		return
+	}
+	if err := http.Serve(listener, mux); err != nil { //nolint:gosec
+		options.Logger.Err(err).Msg("failed to start HTTP API")
+	}
+}
+
+// getAccessLists returns the current allow and deny lists of the servers.
+func getAccessLists(servers map[string]*network.Server) map[string]AccessLists {
+	accessLists := make(map[string]AccessLists, len(servers))
+	for name, server := range servers {
+		allow, deny := server.AccessLists()
+		lists := AccessLists{Allow: make([]string, 0, len(allow)), Deny: make([]string, 0, len(deny))}
+		for _, ipNet := range allow {
+			lists.Allow = append(lists.Allow, ipNet.String())
+		}
+		for _, ipNet := range deny {
+			lists.Deny = append(lists.Deny, ipNet.String())
+		}
+		accessLists[name] = lists
+	}
+	return accessLists
+}
+
+// accessListsHandler returns the allow and deny lists of the servers on GET, and replaces
+// them on PUT with the lists of the servers in the body, like {"default": {"deny": [...]}}.
+// The lists are all validated before any of them is replaced, and the servers that are not
+// in the body keep their lists.
+func accessListsHandler(options *Options) http.HandlerFunc {
+	return func(writer http.ResponseWriter, r *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var accessLists map[string]AccessLists
+			if err := json.NewDecoder(r.Body).Decode(&accessLists); err != nil {
+				http.Error(writer, fmt.Sprintf("invalid access lists: %v", err), http.StatusBadRequest)
+				return
+			}
+
+			type networks struct{ allow, deny []*net.IPNet }
+			parsed := make(map[string]networks, len(accessLists))
+			for name, lists := range accessLists {
+				if _, ok := options.Servers[name]; !ok {
+					http.Error(writer, fmt.Sprintf("server %q not found", name), http.StatusNotFound)
+					return
+				}
+				allow, allowErr := config.ParseCIDRs(lists.Allow)
+				deny, denyErr := config.ParseCIDRs(lists.Deny)
+				if err := errors.Join(allowErr, denyErr); err != nil {
+					http.Error(writer, fmt.Sprintf("invalid access lists of server %q: %v", name, err),
+						http.StatusBadRequest)
+					return
+				}
+				parsed[name] = networks{allow: allow, deny: deny}
+			}
+
+			for name, lists := range parsed {
+				options.Servers[name].SetAccessLists(lists.allow, lists.deny)
+				options.Logger.Info().Fields(
+					map[string]interface{}{
+						"server": name,
+						"allow":  accessLists[name].Allow,
+						"deny":   accessLists[name].Deny,
+					},
+				).Msg("Replaced the access lists of the server")
+			}
+		default:
+			writer.Header().Set("Allow", "GET, PUT")
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		writer.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(writer).Encode(getAccessLists(options.Servers)); err != nil {
+			options.Logger.Err(err).Msg("failed to serve access lists")
+		}
 	}
 }
@@ -11,7 +11,9 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"slices"
 	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
@@ -126,11 +128,18 @@ func StopGracefully(
 			span.AddEvent("Stopped metrics server")
 		}
 	}
+	// The servers are stopped concurrently, since they might drain their connections.
+	var wg sync.WaitGroup
 	for name, server := range servers {
 		logger.Info().Str("name", name).Msg("Stopping server")
-		server.Shutdown()
-		span.AddEvent("Stopped server")
+		wg.Add(1)
+		go func(server *network.Server) {
+			defer wg.Done()
+			server.Shutdown()
+		}(server)
 	}
+	wg.Wait()
+	span.AddEvent("Stopped all servers")
 	logger.Info().Msg("Stopped all servers")
 	if pluginRegistry != nil {
 		pluginRegistry.Shutdown()
@@ -450,7 +459,13 @@ var runCmd = &cobra.Command{
 			)
 
 			// Check if the metrics server is already running before registering the handler.
-			if _, err = http.Get(address); err != nil { //nolint:gosec
+			// The listener inherited from the previous process is not checked, since nothing
+			// would accept the request once the previous process stops.
+			inherited := network.IsInheritedListener("tcp", metricsConfig.Address)
+			if !inherited {
+				_, err = http.Get(address) //nolint:gosec
+			}
+			if inherited || err != nil {
 				// The timeout handler limits the nested handlers from running for too long.
 				mux.Handle(
 					metricsConfig.Path,
@@ -486,6 +501,14 @@ var runCmd = &cobra.Command{
 				"readHeaderTimeout": readHeaderTimeout.String(),
 			}).Msg("Metrics are exposed")
 
+			// The listener is inherited from the previous process after a restart.
+			listener, err := network.Listen("tcp", metricsConfig.Address)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to start metrics server")
+				span.RecordError(err)
+				return
+			}
+
 			if metricsConfig.CertFile != "" && metricsConfig.KeyFile != "" {
 				// Set up TLS.
 				metricsServer.TLSConfig = &tls.Config{
@@ -507,14 +530,14 @@ var runCmd = &cobra.Command{
 				logger.Debug().Msg("Metrics server is running with TLS")
 
 				// Start the metrics server with TLS.
-				if err = metricsServer.ListenAndServeTLS(
-					metricsConfig.CertFile, metricsConfig.KeyFile); !errors.Is(err, http.ErrServerClosed) {
+				if err = metricsServer.ServeTLS(
+					listener, metricsConfig.CertFile, metricsConfig.KeyFile); !errors.Is(err, http.ErrServerClosed) {
 					logger.Error().Err(err).Msg("Failed to start metrics server")
 					span.RecordError(err)
 				}
 			} else {
 				// Start the metrics server without TLS.
-				if err = metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				if err = metricsServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
 					logger.Error().Err(err).Msg("Failed to start metrics server")
 					span.RecordError(err)
 				}
@@ -636,6 +659,9 @@ var runCmd = &cobra.Command{
 						attribute.String("backoff", client.Retry().Backoff.String()),
 						attribute.Float64("backoffMultiplier", clientConfig.BackoffMultiplier),
 						attribute.Bool("disableBackoffCaps", clientConfig.DisableBackoffCaps),
+						attribute.String("sslMode", string(client.SSLMode)),
+						attribute.StringSlice("addresses", clientConfig.GetAddresses()),
+						attribute.String("addressPolicy", string(clientConfig.GetAddressPolicy())),
 					)
 					if client.ID != "" {
 						eventOptions = trace.WithAttributes(
@@ -666,6 +692,8 @@ var runCmd = &cobra.Command{
 						"backoff":            client.Retry().Backoff.String(),
 						"backoffMultiplier":  clientConfig.BackoffMultiplier,
 						"disableBackoffCaps": clientConfig.DisableBackoffCaps,
+						"sslMode":            string(client.SSLMode),
+						"addressPolicy":      string(clientConfig.GetAddressPolicy()),
 					}
 					_, err := pluginRegistry.Run(
 						pluginTimeoutCtx, clientCfg, v1.HookName_HOOK_NAME_ON_NEW_CLIENT)
@@ -731,20 +759,40 @@ var runCmd = &cobra.Command{
 			proxies[name] = network.NewProxy(
 				runCtx,
 				pools[name],
+				conf.Global.Pools[name],
 				pluginRegistry,
-				cfg.Elastic,
-				cfg.ReuseElasticClients,
-				cfg.HealthCheckPeriod,
+				cfg,
 				clientConfig,
 				logger,
 				conf.Plugin.Timeout,
 			)
+			proxies[name].Name = name
+
+			for _, address := range cfg.Replicas {
+				if err := proxies[name].AddReplica(address); err != nil {
+					logger.Error().Err(err).Str("replica", address).Msg(
+						"Failed to initialize the pool of the replica")
+					span.RecordError(err)
+					pluginRegistry.Shutdown()
+					os.Exit(gerr.FailedToInitializePool)
+				}
+			}
 
 			span.AddEvent("Create proxy", trace.WithAttributes(
 				attribute.String("name", name),
 				attribute.Bool("elastic", cfg.Elastic),
 				attribute.Bool("reuseElasticClients", cfg.ReuseElasticClients),
 				attribute.String("healthCheckPeriod", cfg.HealthCheckPeriod.String()),
+				attribute.String("healthCheckType", string(cfg.GetHealthCheckType())),
+				attribute.String("poolMode", string(cfg.GetPoolMode())),
+				attribute.String("authType", string(cfg.GetAuthType())),
+				attribute.String("maxWaitTime", cfg.MaxWaitTime.String()),
+				attribute.Int("maxQueueLength", cfg.MaxQueueLength),
+				attribute.StringSlice("replicas", cfg.Replicas),
+				attribute.String("maxReplicaLag", cfg.MaxReplicaLag.String()),
+				attribute.String("maxLifetime", proxies[name].MaxLifetime.String()),
+				attribute.String("maxIdleTime", proxies[name].MaxIdleTime.String()),
+				attribute.Int("minIdle", proxies[name].MinIdle),
 			))
 
 			pluginTimeoutCtx, cancel = context.WithTimeout(
@@ -769,6 +817,23 @@ var runCmd = &cobra.Command{
 		// Create and initialize servers.
 		for name, cfg := range conf.Global.Servers {
 			logger := loggers[name]
+
+			// The server uses the proxy with the same name, unless the StartupMessage
+			// of the connection matches one of its routes.
+			var proxy network.IProxy
+			if namedProxy, ok := proxies[name]; ok {
+				proxy = namedProxy
+			}
+			routes := make([]network.Route, 0, len(cfg.Routes))
+			for _, route := range cfg.Routes {
+				routes = append(routes, network.Route{
+					Database:        route.Database,
+					User:            route.User,
+					ApplicationName: route.ApplicationName,
+					Proxy:           proxies[route.Proxy],
+				})
+			}
+
 			servers[name] = network.NewServer(
 				runCtx,
 				cfg.Network,
@@ -781,8 +846,31 @@ var runCmd = &cobra.Command{
 				network.Option{
 					// Can be used to send keepalive messages to the client.
 					EnableTicker: cfg.EnableTicker,
+					// Serves the idle client connections with epoll.
+					EventLoop: cfg.EventLoop,
+					// Reads the address of the client from the PROXY header of the load balancer.
+					AcceptProxyProtocol: cfg.AcceptProxyProtocol,
+					TrustedProxies:      cfg.GetTrustedProxies(),
+					// Lets the clients finish their current transaction on shutdown.
+					ShutdownTimeout: cfg.ShutdownTimeout,
+					// Limits the connections, so one client can't starve the others.
+					MaxConnections:            cfg.MaxConnections,
+					SoftLimit:                 cfg.SoftLimit,
+					MaxConnectionsPerClientIP: cfg.MaxConnectionsPerClientIP,
+					// Rejects the clients by their address, like a firewall.
+					Allow: cfg.GetAllow(),
+					Deny:  cfg.GetDeny(),
+					// Releases the server connections of the idle and dead clients.
+					ClientIdleTimeout: cfg.ClientIdleTimeout,
+					TCPKeepAlive:      cfg.TCPKeepAlive,
+					TCPKeepAlivePeriod: config.If[time.Duration](
+						cfg.TCPKeepAlivePeriod > 0,
+						cfg.TCPKeepAlivePeriod,
+						config.DefaultTCPKeepAlivePeriod,
+					),
 				},
-				proxies[name],
+				proxy,
+				routes,
 				logger,
 				pluginRegistry,
 				conf.Plugin.Timeout,
@@ -802,6 +890,16 @@ var runCmd = &cobra.Command{
 				attribute.String("certFile", cfg.CertFile),
 				attribute.String("keyFile", cfg.KeyFile),
 				attribute.String("handshakeTimeout", cfg.HandshakeTimeout.String()),
+				attribute.String("shutdownTimeout", cfg.ShutdownTimeout.String()),
+				attribute.Int("maxConnections", cfg.MaxConnections),
+				attribute.Int("softLimit", cfg.SoftLimit),
+				attribute.Int("maxConnectionsPerClientIP", cfg.MaxConnectionsPerClientIP),
+				attribute.String("clientIdleTimeout", cfg.ClientIdleTimeout.String()),
+				attribute.Bool("tcpKeepAlive", cfg.TCPKeepAlive),
+				attribute.String("tcpKeepAlivePeriod", cfg.TCPKeepAlivePeriod.String()),
+				attribute.StringSlice("allow", cfg.Allow),
+				attribute.StringSlice("deny", cfg.Deny),
+				attribute.Int("routes", len(cfg.Routes)),
 			))
 
 			pluginTimeoutCtx, cancel = context.WithTimeout(
@@ -909,7 +1007,7 @@ var runCmd = &cobra.Command{
 			syscall.SIGINT,
 		)
 		signalsCh := make(chan os.Signal, 1)
-		signal.Notify(signalsCh, signals...)
+		signal.Notify(signalsCh, append(signals, restartSignals...)...)
 		go func(pluginRegistry *plugin.Registry,
 			logger zerolog.Logger,
 			servers map[string]*network.Server,
@@ -918,6 +1016,19 @@ var runCmd = &cobra.Command{
 			stopChan chan struct{},
 		) {
 			for sig := range signalsCh {
+				if slices.Contains(restartSignals, sig) {
+					// The new process accepts the connections while this one drains its own.
+					process, err := network.StartProcess()
+					if err != nil {
+						logger.Error().Err(err).Msg("Failed to restart GatewayD")
+						continue
+					}
+					logger.Info().Str("pid", strconv.Itoa(process.Pid)).Msg(
+						"Started a new GatewayD process with the listeners")
+					if err := process.Release(); err != nil {
+						logger.Error().Err(err).Msg("Failed to release the new GatewayD process")
+					}
+				}
 				for _, s := range signals {
 					if sig != s {
 						StopGracefully(
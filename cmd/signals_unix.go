@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// restartSignals start a new GatewayD process that inherits the listeners,
+// before the current process drains its connections and exits.
+var restartSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package cmd
+
+import "os"
+
+// restartSignals are not supported on Windows, since the
+// listeners can't be inherited by another process.
+var restartSignals = []os.Signal{}
@@ -110,6 +110,8 @@ func (c *Config) LoadDefaults(ctx context.Context) {
 	defaultClient := Client{
 		Network:            DefaultNetwork,
 		Address:            DefaultAddress,
+		AddressPolicy:      string(DefaultAddressPolicy),
+		AddressCooldown:    DefaultAddressCooldown,
 		TCPKeepAlive:       DefaultTCPKeepAlive,
 		TCPKeepAlivePeriod: DefaultTCPKeepAlivePeriod,
 		ReceiveChunkSize:   DefaultChunkSize,
@@ -121,27 +123,53 @@ func (c *Config) LoadDefaults(ctx context.Context) {
 		Backoff:            DefaultBackoff,
 		BackoffMultiplier:  DefaultBackoffMultiplier,
 		DisableBackoffCaps: DefaultDisableBackoffCaps,
+		SSLMode:            string(DefaultSSLMode),
+		ProxyProtocol:      string(DefaultProxyProtocol),
 	}
 
 	defaultPool := Pool{
-		Size: DefaultPoolSize,
+		Size:        DefaultPoolSize,
+		MaxLifetime: DefaultMaxLifetime,
+		MaxIdleTime: DefaultMaxIdleTime,
+		MinIdle:     DefaultMinIdle,
 	}
 
 	defaultProxy := Proxy{
-		Elastic:             false,
-		ReuseElasticClients: false,
-		HealthCheckPeriod:   DefaultHealthCheckPeriod,
+		Elastic:               false,
+		ReuseElasticClients:   false,
+		HealthCheckPeriod:     DefaultHealthCheckPeriod,
+		HealthCheckType:       string(DefaultHealthCheckType),
+		HealthCheckQuery:      DefaultHealthCheckQuery,
+		HealthCheckTimeout:    DefaultHealthCheckTimeout,
+		PoolMode:              string(DefaultPoolMode),
+		ResetQuery:            DefaultResetQuery,
+		DisableSplice:         false,
+		AuthType:              string(DefaultAuthType),
+		MaxPreparedStatements: DefaultMaxPreparedStatements,
+		MaxWaitTime:           DefaultMaxWaitTime,
+		MaxQueueLength:        DefaultMaxQueueLength,
+		MaxReplicaLag:         DefaultMaxReplicaLag,
+		ReplicaLagCheck:       DefaultReplicaLagCheck,
 	}
 
 	defaultServer := Server{
-		Network:          DefaultListenNetwork,
-		Address:          DefaultListenAddress,
-		EnableTicker:     false,
-		TickInterval:     DefaultTickInterval,
-		EnableTLS:        false,
-		CertFile:         "",
-		KeyFile:          "",
-		HandshakeTimeout: DefaultHandshakeTimeout,
+		Network:                   DefaultListenNetwork,
+		Address:                   DefaultListenAddress,
+		EnableTicker:              false,
+		EventLoop:                 false,
+		TickInterval:              DefaultTickInterval,
+		EnableTLS:                 false,
+		CertFile:                  "",
+		KeyFile:                   "",
+		HandshakeTimeout:          DefaultHandshakeTimeout,
+		ShutdownTimeout:           DefaultShutdownTimeout,
+		MaxConnections:            DefaultMaxConnections,
+		SoftLimit:                 DefaultSoftLimit,
+		MaxConnectionsPerClientIP: DefaultMaxConnectionsPerClientIP,
+		ClientIdleTimeout:         DefaultClientIdleTimeout,
+		TCPKeepAlive:              DefaultTCPKeepAlive,
+		TCPKeepAlivePeriod:        DefaultTCPKeepAlivePeriod,
+		AcceptProxyProtocol:       false,
 	}
 
 	c.globalDefaults = GlobalConfig{
@@ -415,6 +443,15 @@ func (c *Config) ValidateGlobalConfig(ctx context.Context) {
 		seenConfigObjects = append(seenConfigObjects, "pools")
 	}
 
+	for configGroup, pool := range globalConfig.Pools {
+		if pool != nil && pool.Size > 0 && pool.MinIdle > pool.Size {
+			err := fmt.Errorf(
+				"\"pools.%s.minIdle\" must not be greater than the size of the pool", configGroup)
+			span.RecordError(err)
+			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+		}
+	}
+
 	for configGroup := range globalConfig.Proxies {
 		if globalConfig.Proxies[configGroup] == nil {
 			err := fmt.Errorf("\"proxies.%s\" is nil or empty", configGroup)
@@ -427,6 +464,46 @@ func (c *Config) ValidateGlobalConfig(ctx context.Context) {
 		seenConfigObjects = append(seenConfigObjects, "proxies")
 	}
 
+	for configGroup, proxy := range globalConfig.Proxies {
+		if proxy != nil && len(proxy.Replicas) > 0 && proxy.GetPoolMode() != Transaction {
+			err := fmt.Errorf(
+				"\"proxies.%s.replicas\" requires the transaction pool mode", configGroup)
+			span.RecordError(err)
+			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+		}
+	}
+
+	for configGroup, proxy := range globalConfig.Proxies {
+		if proxy == nil || proxy.GetAuthType() == PassthroughAuth {
+			continue
+		}
+		if proxy.AuthFile == "" && proxy.AuthQuery == "" {
+			err := fmt.Errorf(
+				"\"proxies.%s.authType\" requires an authFile or an authQuery", configGroup)
+			span.RecordError(err)
+			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+		}
+		if client := globalConfig.Clients[configGroup]; client == nil || client.User == "" {
+			err := fmt.Errorf(
+				"\"proxies.%s.authType\" requires the user of \"clients.%s\"", configGroup, configGroup)
+			span.RecordError(err)
+			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+		}
+	}
+
+	for configGroup, client := range globalConfig.Clients {
+		if client == nil || client.User == "" {
+			continue
+		}
+		if proxy := globalConfig.Proxies[configGroup]; proxy == nil || proxy.GetAuthType() == PassthroughAuth {
+			err := fmt.Errorf(
+				"\"clients.%s.user\" requires the authentication of the clients by \"proxies.%s\"",
+				configGroup, configGroup)
+			span.RecordError(err)
+			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+		}
+	}
+
 	for configGroup := range globalConfig.Servers {
 		if globalConfig.Servers[configGroup] == nil {
 			err := fmt.Errorf("\"servers.%s\" is nil or empty", configGroup)
@@ -439,6 +516,50 @@ func (c *Config) ValidateGlobalConfig(ctx context.Context) {
 		seenConfigObjects = append(seenConfigObjects, "servers")
 	}
 
+	for configGroup, server := range globalConfig.Servers {
+		if server == nil {
+			continue
+		}
+		if _, err := ParseCIDRs(server.TrustedProxies); err != nil {
+			err := fmt.Errorf("\"servers.%s.trustedProxies\" is invalid: %w", configGroup, err)
+			span.RecordError(err)
+			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+		}
+		if server.AcceptProxyProtocol && len(server.TrustedProxies) == 0 {
+			err := fmt.Errorf(
+				"\"servers.%s.acceptProxyProtocol\" requires \"servers.%s.trustedProxies\"",
+				configGroup, configGroup)
+			span.RecordError(err)
+			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+		}
+		if _, err := ParseCIDRs(server.Allow); err != nil {
+			err := fmt.Errorf("\"servers.%s.allow\" is invalid: %w", configGroup, err)
+			span.RecordError(err)
+			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+		}
+		if _, err := ParseCIDRs(server.Deny); err != nil {
+			err := fmt.Errorf("\"servers.%s.deny\" is invalid: %w", configGroup, err)
+			span.RecordError(err)
+			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+		}
+		if server.MaxConnections > 0 && server.SoftLimit > server.MaxConnections {
+			err := fmt.Errorf(
+				"\"servers.%s.softLimit\" (%d) is greater than \"servers.%s.maxConnections\" (%d)",
+				configGroup, server.SoftLimit, configGroup, server.MaxConnections)
+			span.RecordError(err)
+			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+		}
+		for index, route := range server.Routes {
+			if _, ok := globalConfig.Proxies[route.Proxy]; !ok {
+				err := fmt.Errorf(
+					"\"servers.%s.routes[%d]\" refers to the missing proxy \"%s\"",
+					configGroup, index, route.Proxy)
+				span.RecordError(err)
+				errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+			}
+		}
+	}
+
 	sort.Strings(seenConfigObjects)
 
 	if len(seenConfigObjects) > 0 && !reflect.DeepEqual(configObjects, seenConfigObjects) {
@@ -11,6 +11,13 @@ type (
 	AcceptancePolicy    string
 	TerminationPolicy   string
 	LogOutput           uint
+	PoolMode            string
+	SSLMode             string
+	AddressPolicy       string
+	HealthCheckType     string
+	AuthType            string
+	ResponseMode        string
+	ProxyProtocol       string
 )
 
 // Status is the status of the server.
@@ -48,6 +55,58 @@ const (
 	Stop     TerminationPolicy = "stop"     // Stop the execution of the functions
 )
 
+// ResponseMode is how the hooks of the responses of the server receive the responses.
+const (
+	StreamResponse  ResponseMode = "stream"  // Receive every batch of messages as it arrives
+	SummaryResponse ResponseMode = "summary" // Receive the responses without the rows, like RowDescription and CommandComplete
+)
+
+// PoolMode is the pooling mode of the proxy.
+const (
+	Session     PoolMode = "session"     // Assign a server connection for the whole session
+	Transaction PoolMode = "transaction" // Assign a server connection for each transaction
+)
+
+// SSLMode is the TLS mode of the connections to the database server.
+// See https://www.postgresql.org/docs/current/libpq-ssl.html#LIBPQ-SSL-SSLMODE-STATEMENTS
+const (
+	SSLModeDisable    SSLMode = "disable"     // Only try a plaintext connection
+	SSLModePrefer     SSLMode = "prefer"      // Try TLS first and fall back to plaintext
+	SSLModeRequire    SSLMode = "require"     // Require TLS, but don't verify the certificate
+	SSLModeVerifyCA   SSLMode = "verify-ca"   // Require TLS and verify the certificate chain
+	SSLModeVerifyFull SSLMode = "verify-full" // Require TLS and verify the certificate chain and host name
+)
+
+// ProxyProtocol is the version of the PROXY protocol header that is sent to the database
+// server at the start of the connections.
+// See https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+const (
+	ProxyProtocolNone ProxyProtocol = "none" // Don't send a PROXY header
+	ProxyProtocolV1   ProxyProtocol = "v1"   // Send a human-readable PROXY header
+	ProxyProtocolV2   ProxyProtocol = "v2"   // Send a binary PROXY header
+)
+
+// HealthCheckType is the type of the health check of the idle server connections.
+const (
+	ReconnectHealthCheck HealthCheckType = "reconnect" // Replace all the connections, without checking them
+	TCPHealthCheck       HealthCheckType = "tcp"       // Check that the connections are not closed by the server
+	QueryHealthCheck     HealthCheckType = "query"     // Run the health check query on the authenticated connections
+)
+
+// AuthType is the authentication method of the clients.
+const (
+	PassthroughAuth AuthType = "passthrough"   // Forward the authentication to the database server
+	MD5Auth         AuthType = "md5"           // Authenticate the clients with md5, or SCRAM-SHA-256 for SCRAM secrets
+	SCRAMSHA256Auth AuthType = "scram-sha-256" // Authenticate the clients with SCRAM-SHA-256
+)
+
+// AddressPolicy is the order in which a client tries the addresses of the database servers.
+const (
+	Failover   AddressPolicy = "failover"    // Try the addresses in the configured order
+	RoundRobin AddressPolicy = "round-robin" // Rotate the first address tried by each connection
+	Random     AddressPolicy = "random"      // Try the addresses in a random order
+)
+
 // LogOutput is the output type for the logger.
 const (
 	Console LogOutput = iota
@@ -108,12 +167,33 @@ const (
 	DefaultBackoff            = 1 * time.Second
 	DefaultBackoffMultiplier  = 2.0
 	DefaultDisableBackoffCaps = false
+	DefaultSSLMode            = SSLModeDisable
+	DefaultProxyProtocol      = ProxyProtocolNone
+	DefaultAddressPolicy      = Failover
+	DefaultAddressCooldown    = 30 * time.Second
 
 	// Pool constants.
-	EmptyPoolCapacity        = 0
-	DefaultPoolSize          = 10
-	MinimumPoolSize          = 2
-	DefaultHealthCheckPeriod = 60 * time.Second // This must match PostgreSQL authentication timeout.
+	EmptyPoolCapacity            = 0
+	DefaultPoolSize              = 10
+	MinimumPoolSize              = 2
+	DefaultMaxLifetime           = 0 // 0 means the connections are never retired because of their age
+	DefaultMaxIdleTime           = 0 // 0 means the idle connections are never retired
+	DefaultMinIdle               = 0
+	DefaultRetireCheckPeriod     = 1 * time.Second
+	DefaultHealthCheckPeriod     = 60 * time.Second // This must match PostgreSQL authentication timeout.
+	DefaultHealthCheckType       = QueryHealthCheck
+	DefaultHealthCheckQuery      = "" // An empty query, which is answered without running anything
+	DefaultHealthCheckTimeout    = 5 * time.Second
+	DefaultPoolMode              = Session
+	DefaultResetQuery            = "DISCARD ALL"
+	DefaultResetTimeout          = 5 * time.Second
+	DefaultAuthType              = PassthroughAuth
+	DefaultAuthTimeout           = 60 * time.Second
+	DefaultMaxPreparedStatements = 200
+	DefaultMaxWaitTime           = 30 * time.Second
+	DefaultMaxQueueLength        = 100
+	DefaultMaxReplicaLag         = 0 // 0 means the replicas are used regardless of their lag
+	DefaultReplicaLagCheck       = 5 * time.Second
 
 	// Server constants.
 	DefaultListenNetwork        = "tcp"
@@ -124,6 +204,12 @@ const (
 	DefaultLoadBalancer         = "roundrobin"
 	DefaultTCPNoDelay           = true
 	DefaultHandshakeTimeout     = 5 * time.Second
+	DefaultShutdownTimeout      = 0 // 0 means the connections are closed without draining them
+	// 0 means the number of connections is not limited.
+	DefaultMaxConnections            = 0
+	DefaultSoftLimit                 = 0
+	DefaultMaxConnectionsPerClientIP = 0
+	DefaultClientIdleTimeout         = 0 // 0 means the idle connections are never closed
 
 	// Utility constants.
 	DefaultSeed        = 1000
@@ -150,4 +236,5 @@ const (
 	DefaultVerificationPolicy  = PassDown
 	DefaultAcceptancePolicy    = Accept
 	DefaultTerminationPolicy   = Stop
+	DefaultResponseMode        = StreamResponse
 )
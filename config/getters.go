@@ -1,6 +1,8 @@
 package config
 
 import (
+	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"time"
@@ -27,6 +29,37 @@ var (
 		"continue": Continue,
 		"stop":     Stop,
 	}
+	PoolModes = map[string]PoolMode{
+		"session":     Session,
+		"transaction": Transaction,
+	}
+	SSLModes = map[string]SSLMode{
+		"disable":     SSLModeDisable,
+		"prefer":      SSLModePrefer,
+		"require":     SSLModeRequire,
+		"verify-ca":   SSLModeVerifyCA,
+		"verify-full": SSLModeVerifyFull,
+	}
+	ProxyProtocols = map[string]ProxyProtocol{
+		"none": ProxyProtocolNone,
+		"v1":   ProxyProtocolV1,
+		"v2":   ProxyProtocolV2,
+	}
+	HealthCheckTypes = map[string]HealthCheckType{
+		"reconnect": ReconnectHealthCheck,
+		"tcp":       TCPHealthCheck,
+		"query":     QueryHealthCheck,
+	}
+	AuthTypes = map[string]AuthType{
+		"passthrough":   PassthroughAuth,
+		"md5":           MD5Auth,
+		"scram-sha-256": SCRAMSHA256Auth,
+	}
+	AddressPolicies = map[string]AddressPolicy{
+		"failover":    Failover,
+		"round-robin": RoundRobin,
+		"random":      Random,
+	}
 	logOutputs = map[string]LogOutput{
 		"console": Console,
 		"stdout":  Stdout,
@@ -90,6 +123,81 @@ func (l Logger) GetOutput() []LogOutput {
 	return outputs
 }
 
+// GetPoolMode returns the pool mode of the proxy from config file.
+func (p Proxy) GetPoolMode() PoolMode {
+	if poolMode, ok := PoolModes[p.PoolMode]; ok {
+		return poolMode
+	}
+	return DefaultPoolMode
+}
+
+// GetHealthCheckType returns the health check type of the proxy from config file.
+func (p Proxy) GetHealthCheckType() HealthCheckType {
+	if healthCheckType, ok := HealthCheckTypes[p.HealthCheckType]; ok {
+		return healthCheckType
+	}
+	return DefaultHealthCheckType
+}
+
+// GetAuthType returns the authentication method of the clients of the proxy from config file.
+func (p Proxy) GetAuthType() AuthType {
+	if authType, ok := AuthTypes[p.AuthType]; ok {
+		return authType
+	}
+	return DefaultAuthType
+}
+
+// GetSSLMode returns the TLS mode of the client from config file.
+func (c Client) GetSSLMode() SSLMode {
+	if sslMode, ok := SSLModes[c.SSLMode]; ok {
+		return sslMode
+	}
+	return DefaultSSLMode
+}
+
+// GetProxyProtocol returns the version of the PROXY header that the client sends from config file.
+func (c Client) GetProxyProtocol() ProxyProtocol {
+	if proxyProtocol, ok := ProxyProtocols[c.ProxyProtocol]; ok {
+		return proxyProtocol
+	}
+	return DefaultProxyProtocol
+}
+
+// GetTrustedProxies returns the trusted proxies of the server from config file.
+// The invalid CIDRs are skipped, since they are reported by the validation.
+func (s Server) GetTrustedProxies() []*net.IPNet {
+	return parseValidCIDRs(s.TrustedProxies)
+}
+
+// GetAllow returns the networks that the clients of the server are allowed from, from config
+// file. The invalid CIDRs are skipped, since they are reported by the validation.
+func (s Server) GetAllow() []*net.IPNet {
+	return parseValidCIDRs(s.Allow)
+}
+
+// GetDeny returns the networks that the clients of the server are denied from, from config
+// file. The invalid CIDRs are skipped, since they are reported by the validation.
+func (s Server) GetDeny() []*net.IPNet {
+	return parseValidCIDRs(s.Deny)
+}
+
+// GetAddressPolicy returns the address policy of the client from config file.
+func (c Client) GetAddressPolicy() AddressPolicy {
+	if addressPolicy, ok := AddressPolicies[c.AddressPolicy]; ok {
+		return addressPolicy
+	}
+	return DefaultAddressPolicy
+}
+
+// GetAddresses returns the addresses of the database servers of the client. The address
+// is only used if there are no addresses.
+func (c Client) GetAddresses() []string {
+	if len(c.Addresses) > 0 {
+		return c.Addresses
+	}
+	return []string{c.Address}
+}
+
 // GetPlugins returns the plugins from config file.
 func (p PluginConfig) GetPlugins(name ...string) []Plugin {
 	var plugins []Plugin
@@ -136,3 +244,37 @@ func (gc GlobalConfig) Filter(groupName string) *GlobalConfig {
 		API:     gc.API,
 	}
 }
+
+// parseValidCIDRs parses a list of CIDRs and skips the invalid ones.
+func parseValidCIDRs(cidrs []string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		if network, err := ParseCIDRs([]string{cidr}); err == nil {
+			networks = append(networks, network...)
+		}
+	}
+	return networks
+}
+
+// ParseCIDRs parses a list of CIDRs, like 10.0.0.0/8. An IP address
+// is parsed as a CIDR that only contains the address.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
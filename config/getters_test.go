@@ -42,3 +42,68 @@ func TestFilter(t *testing.T) {
 	assert.Contains(t, defaultGroup.Metrics, Default)
 	assert.Contains(t, defaultGroup.Loggers, Default)
 }
+
+// TestGetPoolMode tests the GetPoolMode function.
+func TestGetPoolMode(t *testing.T) {
+	assert.Equal(t, Transaction, Proxy{PoolMode: "transaction"}.GetPoolMode())
+	assert.Equal(t, DefaultPoolMode, Proxy{PoolMode: "unknown"}.GetPoolMode())
+}
+
+// TestGetSSLMode tests the GetSSLMode function.
+func TestGetSSLMode(t *testing.T) {
+	assert.Equal(t, SSLModeVerifyFull, Client{SSLMode: "verify-full"}.GetSSLMode())
+	assert.Equal(t, DefaultSSLMode, Client{SSLMode: "unknown"}.GetSSLMode())
+}
+
+// TestGetHealthCheckType tests the GetHealthCheckType function.
+func TestGetHealthCheckType(t *testing.T) {
+	assert.Equal(t, TCPHealthCheck, Proxy{HealthCheckType: "tcp"}.GetHealthCheckType())
+	assert.Equal(t, DefaultHealthCheckType, Proxy{HealthCheckType: "unknown"}.GetHealthCheckType())
+}
+
+// TestGetAuthType tests the GetAuthType function.
+func TestGetAuthType(t *testing.T) {
+	assert.Equal(t, SCRAMSHA256Auth, Proxy{AuthType: "scram-sha-256"}.GetAuthType())
+	assert.Equal(t, DefaultAuthType, Proxy{AuthType: "unknown"}.GetAuthType())
+}
+
+// TestGetAddressPolicy tests the GetAddressPolicy function.
+func TestGetAddressPolicy(t *testing.T) {
+	assert.Equal(t, RoundRobin, Client{AddressPolicy: "round-robin"}.GetAddressPolicy())
+	assert.Equal(t, DefaultAddressPolicy, Client{AddressPolicy: "unknown"}.GetAddressPolicy())
+}
+
+// TestGetAddresses tests the GetAddresses function.
+func TestGetAddresses(t *testing.T) {
+	assert.Equal(t, []string{"localhost:5432"}, Client{Address: "localhost:5432"}.GetAddresses())
+	assert.Equal(t,
+		[]string{"db1:5432", "db2:5432"},
+		Client{Address: "localhost:5432", Addresses: []string{"db1:5432", "db2:5432"}}.GetAddresses())
+}
+
+// TestGetProxyProtocol tests the GetProxyProtocol function.
+func TestGetProxyProtocol(t *testing.T) {
+	assert.Equal(t, ProxyProtocolV2, Client{ProxyProtocol: "v2"}.GetProxyProtocol())
+	assert.Equal(t, DefaultProxyProtocol, Client{ProxyProtocol: "unknown"}.GetProxyProtocol())
+}
+
+// TestParseCIDRs tests the ParseCIDRs function.
+func TestParseCIDRs(t *testing.T) {
+	networks, err := ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.10", "::1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.0/8", networks[0].String())
+	assert.Equal(t, "192.168.1.10/32", networks[1].String())
+	assert.Equal(t, "::1/128", networks[2].String())
+
+	_, err = ParseCIDRs([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	assert.Len(t, Server{TrustedProxies: []string{"invalid", "10.0.0.0/8"}}.GetTrustedProxies(), 1)
+}
+
+// TestGetAllowDeny tests that the allow and deny lists of the server skip the invalid CIDRs.
+func TestGetAllowDeny(t *testing.T) {
+	server := Server{Allow: []string{"10.0.0.0/8", "invalid"}, Deny: []string{"10.1.2.3"}}
+	assert.Len(t, server.GetAllow(), 1)
+	assert.Equal(t, "10.1.2.3/32", server.GetDeny()[0].String())
+	assert.Empty(t, Server{}.GetAllow())
+}
@@ -31,6 +31,9 @@ type PluginConfig struct {
 type Client struct {
 	Network            string        `json:"network" jsonschema:"enum=tcp,enum=udp,enum=unix"`
 	Address            string        `json:"address"`
+	Addresses          []string      `json:"addresses,omitempty"`
+	AddressPolicy      string        `json:"addressPolicy" jsonschema:"enum=failover,enum=round-robin,enum=random"`
+	AddressCooldown    time.Duration `json:"addressCooldown" jsonschema:"oneof_type=string;integer"`
 	TCPKeepAlive       bool          `json:"tcpKeepAlive"`
 	TCPKeepAlivePeriod time.Duration `json:"tcpKeepAlivePeriod" jsonschema:"oneof_type=string;integer"`
 	ReceiveChunkSize   int           `json:"receiveChunkSize"`
@@ -42,6 +45,15 @@ type Client struct {
 	Backoff            time.Duration `json:"backoff" jsonschema:"oneof_type=string;integer"`
 	BackoffMultiplier  float64       `json:"backoffMultiplier"`
 	DisableBackoffCaps bool          `json:"disableBackoffCaps"`
+	SSLMode            string        `json:"sslMode" jsonschema:"enum=disable,enum=prefer,enum=require,enum=verify-ca,enum=verify-full"`
+	SSLRootCertFile    string        `json:"sslRootCertFile"`
+	SSLCertFile        string        `json:"sslCertFile"`
+	SSLKeyFile         string        `json:"sslKeyFile"`
+	SSLServerName      string        `json:"sslServerName"`
+	ProxyProtocol      string        `json:"proxyProtocol" jsonschema:"enum=none,enum=v1,enum=v2"`
+	User               string        `json:"user"`
+	Password           string        `json:"password"`
+	Database           string        `json:"database"`
 }
 
 type Logger struct {
@@ -74,24 +86,65 @@ type Metrics struct {
 }
 
 type Pool struct {
-	Size int `json:"size"`
+	Size        int           `json:"size"`
+	MaxLifetime time.Duration `json:"maxLifetime" jsonschema:"oneof_type=string;integer"`
+	MaxIdleTime time.Duration `json:"maxIdleTime" jsonschema:"oneof_type=string;integer"`
+	MinIdle     int           `json:"minIdle"`
 }
 
 type Proxy struct {
-	Elastic             bool          `json:"elastic"`
-	ReuseElasticClients bool          `json:"reuseElasticClients"`
-	HealthCheckPeriod   time.Duration `json:"healthCheckPeriod" jsonschema:"oneof_type=string;integer"`
+	Elastic               bool          `json:"elastic"`
+	ReuseElasticClients   bool          `json:"reuseElasticClients"`
+	HealthCheckPeriod     time.Duration `json:"healthCheckPeriod" jsonschema:"oneof_type=string;integer"`
+	HealthCheckType       string        `json:"healthCheckType" jsonschema:"enum=reconnect,enum=tcp,enum=query"`
+	HealthCheckQuery      string        `json:"healthCheckQuery"`
+	HealthCheckTimeout    time.Duration `json:"healthCheckTimeout" jsonschema:"oneof_type=string;integer"`
+	PoolMode              string        `json:"poolMode" jsonschema:"enum=session,enum=transaction"`
+	ResetQuery            string        `json:"resetQuery"`
+	DisableSplice         bool          `json:"disableSplice"`
+	AuthType              string        `json:"authType" jsonschema:"enum=passthrough,enum=md5,enum=scram-sha-256"`
+	AuthFile              string        `json:"authFile"`
+	AuthQuery             string        `json:"authQuery"`
+	MaxPreparedStatements int           `json:"maxPreparedStatements" jsonschema:"minimum=0"`
+	CopyHookSampling      int           `json:"copyHookSampling" jsonschema:"minimum=0"`
+	MaxWaitTime           time.Duration `json:"maxWaitTime" jsonschema:"oneof_type=string;integer"`
+	MaxQueueLength        int           `json:"maxQueueLength"`
+	Replicas              []string      `json:"replicas,omitempty"`
+	MaxReplicaLag         time.Duration `json:"maxReplicaLag" jsonschema:"oneof_type=string;integer"`
+	ReplicaLagCheck       time.Duration `json:"replicaLagCheck" jsonschema:"oneof_type=string;integer"`
 }
 
 type Server struct {
-	EnableTicker     bool          `json:"enableTicker"`
-	TickInterval     time.Duration `json:"tickInterval" jsonschema:"oneof_type=string;integer"`
-	Network          string        `json:"network" jsonschema:"enum=tcp,enum=udp,enum=unix"`
-	Address          string        `json:"address"`
-	EnableTLS        bool          `json:"enableTLS"` //nolint:tagliatelle
-	CertFile         string        `json:"certFile"`
-	KeyFile          string        `json:"keyFile"`
-	HandshakeTimeout time.Duration `json:"handshakeTimeout" jsonschema:"oneof_type=string;integer"`
+	EnableTicker              bool          `json:"enableTicker"`
+	EventLoop                 bool          `json:"eventLoop"`
+	TickInterval              time.Duration `json:"tickInterval" jsonschema:"oneof_type=string;integer"`
+	Network                   string        `json:"network" jsonschema:"enum=tcp,enum=udp,enum=unix"`
+	Address                   string        `json:"address"`
+	EnableTLS                 bool          `json:"enableTLS"` //nolint:tagliatelle
+	CertFile                  string        `json:"certFile"`
+	KeyFile                   string        `json:"keyFile"`
+	HandshakeTimeout          time.Duration `json:"handshakeTimeout" jsonschema:"oneof_type=string;integer"`
+	ShutdownTimeout           time.Duration `json:"shutdownTimeout" jsonschema:"oneof_type=string;integer"`
+	MaxConnections            int           `json:"maxConnections" jsonschema:"minimum=0"`
+	SoftLimit                 int           `json:"softLimit" jsonschema:"minimum=0"`
+	MaxConnectionsPerClientIP int           `json:"maxConnectionsPerClientIP" jsonschema:"minimum=0"` //nolint:tagliatelle
+	ClientIdleTimeout         time.Duration `json:"clientIdleTimeout" jsonschema:"oneof_type=string;integer"`
+	TCPKeepAlive              bool          `json:"tcpKeepAlive"`
+	TCPKeepAlivePeriod        time.Duration `json:"tcpKeepAlivePeriod" jsonschema:"oneof_type=string;integer"`
+	AcceptProxyProtocol       bool          `json:"acceptProxyProtocol"`
+	TrustedProxies            []string      `json:"trustedProxies,omitempty"`
+	Allow                     []string      `json:"allow,omitempty"`
+	Deny                      []string      `json:"deny,omitempty"`
+	Routes                    []Route       `json:"routes,omitempty"`
+}
+
+// Route assigns the incoming connections to a proxy based on the parameters of
+// their StartupMessage. An empty parameter matches any value.
+type Route struct {
+	Database        string `json:"database,omitempty"`
+	User            string `json:"user,omitempty"`
+	ApplicationName string `json:"applicationName,omitempty"`
+	Proxy           string `json:"proxy"`
 }
 
 type API struct {
@@ -42,6 +42,26 @@ const (
 	ErrCodeLintingFailed
 	ErrCodeExtractFailed
 	ErrCodeDownloadFailed
+	ErrCodeMalformedMessage
+	ErrCodePoolWaitTimeout
+	ErrCodeServerTLSNotSupported
+	ErrCodeNoRouteFound
+	ErrCodeReplicaAuthFailed
+	ErrCodeHealthCheckFailed
+	ErrCodeCancelRequestFailed
+	ErrCodeResetFailed
+	ErrCodeAuthFailed
+	ErrCodeServerAuthFailed
+	ErrCodeSpliceFailed
+	ErrCodeEventLoopFailed
+	ErrCodeProxyProtocolFailed
+	ErrCodeServerShuttingDown
+	ErrCodeRestartFailed
+	ErrCodeTooManyConnections
+	ErrCodeTooManyClientConnections
+	ErrCodeClientAddressDenied
+	ErrCodeClientIdleTimeout
+	ErrCodeDatabaseMismatch
 )
 
 var (
@@ -136,6 +156,48 @@ var (
 		ErrCodeExtractFailed, "failed to extract the archive", nil)
 	ErrDownloadFailed = NewGatewayDError(
 		ErrCodeDownloadFailed, "failed to download the file", nil)
+
+	ErrMalformedMessage = NewGatewayDError(
+		ErrCodeMalformedMessage, "malformed PostgreSQL message", nil)
+	ErrPoolWaitTimeout = NewGatewayDError(
+		ErrCodePoolWaitTimeout, "timed out waiting for a server connection", nil)
+	ErrServerTLSNotSupported = NewGatewayDError(
+		ErrCodeServerTLSNotSupported, "the database server does not support TLS", nil)
+	ErrNoRouteFound = NewGatewayDError(
+		ErrCodeNoRouteFound, "no route matches the connection", nil)
+	ErrReplicaAuthFailed = NewGatewayDError(
+		ErrCodeReplicaAuthFailed, "failed to authenticate with the replica", nil)
+	ErrHealthCheckFailed = NewGatewayDError(
+		ErrCodeHealthCheckFailed, "the server connection failed the health check", nil)
+	ErrCancelRequestFailed = NewGatewayDError(
+		ErrCodeCancelRequestFailed, "failed to forward the cancel request to the server", nil)
+	ErrResetFailed = NewGatewayDError(
+		ErrCodeResetFailed, "failed to reset the server connection", nil)
+	ErrAuthFailed = NewGatewayDError(
+		ErrCodeAuthFailed, "failed to authenticate the client", nil)
+	ErrServerAuthFailed = NewGatewayDError(
+		ErrCodeServerAuthFailed, "failed to authenticate with the database server", nil)
+	ErrSpliceFailed = NewGatewayDError(
+		ErrCodeSpliceFailed, "failed to copy the traffic between the client and the server", nil)
+	ErrEventLoopFailed = NewGatewayDError(
+		ErrCodeEventLoopFailed, "failed to start the event loop", nil)
+	ErrProxyProtocolFailed = NewGatewayDError(
+		ErrCodeProxyProtocolFailed, "failed to read the PROXY protocol header", nil)
+	ErrServerShuttingDown = NewGatewayDError(
+		ErrCodeServerShuttingDown, "the server is shutting down", nil)
+	ErrRestartFailed = NewGatewayDError(
+		ErrCodeRestartFailed, "failed to start the new process with the listeners", nil)
+	ErrTooManyConnections = NewGatewayDError(
+		ErrCodeTooManyConnections, "the server has reached its maximum number of connections", nil)
+	ErrTooManyClientConnections = NewGatewayDError(
+		ErrCodeTooManyClientConnections,
+		"the client IP has reached its maximum number of connections", nil)
+	ErrClientAddressDenied = NewGatewayDError(
+		ErrCodeClientAddressDenied, "the client address is not allowed to connect", nil)
+	ErrClientIdleTimeout = NewGatewayDError(
+		ErrCodeClientIdleTimeout, "the client connection has been idle for too long", nil)
+	ErrDatabaseMismatch = NewGatewayDError(
+		ErrCodeDatabaseMismatch, "the database is not the one of the server connections", nil)
 )
 
 const (
@@ -27,10 +27,21 @@ func (e *GatewayDError) Error() string {
 	return fmt.Sprintf("%s, OriginalError: %s", e.Message, e.OriginalError)
 }
 
-// Wrap wraps the original error.
+// Wrap returns a copy of the error that wraps the original error. The errors are shared,
+// so they are never changed.
 func (e *GatewayDError) Wrap(err error) *GatewayDError {
-	e.OriginalError = err
-	return e
+	return &GatewayDError{
+		Code:          e.Code,
+		Message:       e.Message,
+		OriginalError: err,
+	}
+}
+
+// Is returns true if the target is a GatewayDError with the same code,
+// so that the copies returned by Wrap match the error they are made from.
+func (e *GatewayDError) Is(target error) bool {
+	err, ok := target.(*GatewayDError)
+	return ok && err != nil && err.Code == e.Code
 }
 
 // Unwrap returns the original error.
@@ -17,8 +17,13 @@ func TestNewGatewayDError(t *testing.T) {
 	assert.Equal(t, "test", err.Message)
 	require.NoError(t, err.OriginalError)
 
-	assert.NotNil(t, err.Wrap(io.EOF))
-	assert.Equal(t, io.EOF, err.OriginalError)
-	assert.Equal(t, io.EOF, err.Unwrap())
-	assert.Equal(t, "test, OriginalError: EOF", err.Error())
+	wrapped := err.Wrap(io.EOF)
+	assert.NotNil(t, wrapped)
+	assert.Equal(t, io.EOF, wrapped.OriginalError)
+	assert.Equal(t, io.EOF, wrapped.Unwrap())
+	assert.Equal(t, "test, OriginalError: EOF", wrapped.Error())
+	// The error is not changed by Wrap, but its copies still match it.
+	require.NoError(t, err.OriginalError)
+	assert.ErrorIs(t, wrapped, err)
+	assert.NotErrorIs(t, wrapped, NewGatewayDError(ErrCodeNilContext, "test", nil))
 }
@@ -0,0 +1,89 @@
+package errors
+
+import "errors"
+
+// PostgresError is the PostgreSQL error that is sent to the client
+// when a GatewayDError closes the connection.
+type PostgresError struct {
+	Severity string
+	SQLState string
+	Message  string
+}
+
+// PostgresErrors maps the codes of the errors that are caused by GatewayD, rather than
+// by the client closing the connection, to the PostgreSQL errors sent to the client.
+// See https://www.postgresql.org/docs/current/errcodes-appendix.html
+var PostgresErrors = map[ErrCode]PostgresError{
+	ErrCodeClientNotFound: {
+		"FATAL", "08006", "gatewayd: no server connection is assigned to the client",
+	},
+	ErrCodeClientConnectionFailed: {
+		"FATAL", "08006", "gatewayd: failed to connect to the database server",
+	},
+	ErrCodePoolExhausted: {
+		"FATAL", "53300", "gatewayd: connection pool exhausted",
+	},
+	ErrCodeTooManyConnections: {
+		"FATAL", "53300", "gatewayd: too many connections",
+	},
+	ErrCodeTooManyClientConnections: {
+		"FATAL", "53300", "gatewayd: too many connections from the client address",
+	},
+	ErrCodeClientAddressDenied: {
+		"FATAL", "28000", "gatewayd: the client address is not allowed to connect",
+	},
+	ErrCodeClientIdleTimeout: {
+		"FATAL", "57P05", "gatewayd: terminating connection due to idle timeout",
+	},
+	ErrCodePoolWaitTimeout: {
+		"FATAL", "53300", "gatewayd: timed out waiting for a server connection",
+	},
+	ErrCodeClientReceiveFailed: {
+		"FATAL", "08006", "gatewayd: failed to receive data from the database server",
+	},
+	ErrCodeClientSendFailed: {
+		"FATAL", "08006", "gatewayd: failed to send data to the database server",
+	},
+	ErrCodeHookTerminatedConnection: {
+		"FATAL", "57P01", "gatewayd: connection terminated by a plugin",
+	},
+	ErrCodeServerShuttingDown: {
+		"FATAL", "57P01", "gatewayd: terminating connection due to administrator command",
+	},
+	ErrCodeNoRouteFound: {
+		"FATAL", "08004", "gatewayd: no route matches the database, user and application name",
+	},
+	ErrCodeAuthFailed: {
+		"FATAL", "28P01", "gatewayd: password authentication failed",
+	},
+	ErrCodeDatabaseMismatch: {
+		"FATAL", "3D000", "gatewayd: the database does not match the database of the server connections",
+	},
+	ErrCodeMalformedMessage: {
+		"FATAL", "08P01", "gatewayd: malformed PostgreSQL message",
+	},
+	ErrCodePutFailed: {
+		"FATAL", "XX000", "gatewayd: internal error",
+	},
+	ErrCodeCastFailed: {
+		"FATAL", "XX000", "gatewayd: internal error",
+	},
+	ErrCodeNilPointer: {
+		"FATAL", "XX000", "gatewayd: internal error",
+	},
+}
+
+// GetPostgresError returns the PostgreSQL error of the first GatewayDError in the
+// error chain that has one. It returns false if the error is not caused by GatewayD,
+// for example if the client has closed the connection.
+func GetPostgresError(err error) (PostgresError, bool) {
+	var gatewaydErr *GatewayDError
+	for errors.As(err, &gatewaydErr) && gatewaydErr != nil {
+		if pgErr, ok := PostgresErrors[gatewaydErr.Code]; ok {
+			return pgErr, true
+		}
+		err = gatewaydErr.Unwrap()
+	}
+
+	return PostgresError{}, false
+}
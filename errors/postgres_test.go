@@ -0,0 +1,40 @@
+package errors
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGetPostgresError tests the PostgreSQL errors of the GatewayDErrors.
+func TestGetPostgresError(t *testing.T) {
+	pgErr, ok := GetPostgresError(NewGatewayDError(ErrCodePoolExhausted, "pool is exhausted", nil))
+	assert.True(t, ok)
+	assert.Equal(t, "53300", pgErr.SQLState)
+	assert.Equal(t, "gatewayd: connection pool exhausted", pgErr.Message)
+
+	// The first error in the chain that has a PostgreSQL error is used.
+	malformed := NewGatewayDError(ErrCodeMalformedMessage, "malformed", nil)
+	pgErr, ok = GetPostgresError(NewGatewayDError(ErrCodeReadFailed, "read failed", malformed))
+	assert.True(t, ok)
+	assert.Equal(t, "08P01", pgErr.SQLState)
+
+	// The sessions wrapping the same error concurrently get their own copies.
+	first := ErrReadFailed.Wrap(ErrPoolExhausted)
+	second := ErrReadFailed.Wrap(ErrMalformedMessage)
+	pgErr, ok = GetPostgresError(first)
+	assert.True(t, ok)
+	assert.Equal(t, "53300", pgErr.SQLState)
+	pgErr, ok = GetPostgresError(second)
+	assert.True(t, ok)
+	assert.Equal(t, "08P01", pgErr.SQLState)
+
+	// The client closing the connection is not reported.
+	_, ok = GetPostgresError(NewGatewayDError(ErrCodeReadFailed, "read failed", io.EOF))
+	assert.False(t, ok)
+	_, ok = GetPostgresError(io.EOF)
+	assert.False(t, ok)
+	_, ok = GetPostgresError(nil)
+	assert.False(t, ok)
+}
@@ -25,6 +25,26 @@ var (
 		Name:      "tls_connections",
 		Help:      "Number of TLS connections",
 	})
+	ServerSoftLimitExceeded = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "server_soft_limit_exceeded_total",
+		Help:      "Number of client connections opened over the soft limit of the server",
+	})
+	ServerRejectedConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "server_rejected_connections_total",
+		Help:      "Number of client connections rejected by the connection limits of the server",
+	}, []string{"limit"})
+	ServerDeniedConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "server_denied_connections_total",
+		Help:      "Number of client connections rejected by the allow and deny lists of the server",
+	}, []string{"list"})
+	ServerIdleConnectionsClosed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "server_idle_connections_closed_total",
+		Help:      "Number of client connections closed by the idle timeout of the server",
+	})
 	ServerTicksFired = promauto.NewCounter(prometheus.CounterOpts{
 		Namespace: Namespace,
 		Name:      "server_ticks_fired_total",
@@ -80,6 +100,48 @@ var (
 		Name:      "proxied_connections",
 		Help:      "Number of proxy connects",
 	})
+	ProxyWaitQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "proxy_wait_queue_length",
+		Help:      "Number of clients waiting for a server connection",
+	})
+	ProxyWaitDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "proxy_wait_duration_seconds",
+		Help:      "Time spent by clients waiting for a server connection",
+		Buckets:   prometheus.DefBuckets,
+	})
+	ProxyResetDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "proxy_reset_duration_seconds",
+		Help:      "Time spent running the reset query on the server connections of the clients that left",
+		Buckets:   prometheus.DefBuckets,
+	})
+	ProxyWaitTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "proxy_wait_timeouts_total",
+		Help:      "Number of clients that timed out waiting for a server connection",
+	})
+	ProxyHealthCheckResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "proxy_health_check_results_total",
+		Help:      "Number of idle server connections per pool that passed, failed or were replaced by the health check",
+	}, []string{"pool", "result"})
+	ProxyRetiredConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "proxy_retired_connections_total",
+		Help:      "Number of idle server connections per pool that were retired because of their lifetime or idle time",
+	}, []string{"pool", "reason"})
+	ProxyReplicaRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "proxy_replica_requests_total",
+		Help:      "Number of read-only requests sent to the replicas",
+	})
+	ProxyCopies = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "proxy_copies_total",
+		Help:      "Number of COPY operations per direction",
+	}, []string{"direction"})
 	ProxyPassThroughsToClient = promauto.NewCounter(prometheus.CounterOpts{
 		Namespace: Namespace,
 		Name:      "proxy_passthroughs_to_client_total",
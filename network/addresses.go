@@ -0,0 +1,132 @@
+package network
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+)
+
+// addressSets are the address sets of the client configs, so that all the clients
+// of a config share the health state of the addresses.
+var addressSets sync.Map
+
+// AddressSet is the list of addresses of the database servers of a client config, along
+// with their health state. An address that refuses a connection is marked as dead, and it
+// is skipped by the clients until its cooldown is over, for example while a primary fails
+// over to a standby.
+type AddressSet struct {
+	mu        sync.Mutex
+	addresses []string
+	deadUntil map[string]time.Time
+	next      int
+
+	Policy   config.AddressPolicy
+	Cooldown time.Duration
+}
+
+// NewAddressSet creates a new address set.
+func NewAddressSet(
+	addresses []string, policy config.AddressPolicy, cooldown time.Duration,
+) *AddressSet {
+	return &AddressSet{
+		addresses: addresses,
+		deadUntil: map[string]time.Time{},
+		Policy:    policy,
+		Cooldown: config.If[time.Duration](
+			cooldown > 0,
+			cooldown,
+			config.DefaultAddressCooldown,
+		),
+	}
+}
+
+// GetAddressSet returns the address set that is shared by the clients of the client config,
+// or nil if the client config has a single address.
+func GetAddressSet(clientConfig *config.Client) *AddressSet {
+	if len(clientConfig.Addresses) == 0 {
+		return nil
+	}
+
+	addresses, _ := addressSets.LoadOrStore(clientConfig, NewAddressSet(
+		clientConfig.GetAddresses(),
+		clientConfig.GetAddressPolicy(),
+		clientConfig.AddressCooldown,
+	))
+	if addressSet, ok := addresses.(*AddressSet); ok {
+		return addressSet
+	}
+	return nil
+}
+
+// Addresses returns the addresses in the order in which they should be tried. The healthy
+// addresses come first, in the order of the policy, followed by the dead addresses, from
+// the one whose cooldown ends first. So if all the addresses are dead, they are still tried.
+func (a *AddressSet) Addresses() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	healthy := make([]string, 0, len(a.addresses))
+	dead := make([]string, 0)
+	for _, address := range a.addresses {
+		if now.Before(a.deadUntil[address]) {
+			dead = append(dead, address)
+		} else {
+			healthy = append(healthy, address)
+		}
+	}
+
+	switch a.Policy {
+	case config.RoundRobin:
+		if len(healthy) > 0 {
+			start := a.next % len(healthy)
+			healthy = append(append([]string{}, healthy[start:]...), healthy[:start]...)
+			a.next++
+		}
+	case config.Random:
+		//nolint:gosec
+		rand.Shuffle(len(healthy), func(i, j int) {
+			healthy[i], healthy[j] = healthy[j], healthy[i]
+		})
+	case config.Failover:
+	}
+
+	sort.SliceStable(dead, func(i, j int) bool {
+		return a.deadUntil[dead[i]].Before(a.deadUntil[dead[j]])
+	})
+
+	return append(healthy, dead...)
+}
+
+// IsHealthy returns true if the address is not in its cooldown.
+func (a *AddressSet) IsHealthy(address string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return !time.Now().Before(a.deadUntil[address])
+}
+
+// MarkDead starts the cooldown of the address, and returns true if
+// the address was healthy before.
+func (a *AddressSet) MarkDead(address string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	healthy := !now.Before(a.deadUntil[address])
+	a.deadUntil[address] = now.Add(a.Cooldown)
+	return healthy
+}
+
+// MarkAlive ends the cooldown of the address, and returns true if the address
+// was marked as dead since it last accepted a connection.
+func (a *AddressSet) MarkAlive(address string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, dead := a.deadUntil[address]
+	delete(a.deadUntil, address)
+	return dead
+}
@@ -0,0 +1,103 @@
+package network
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	"github.com/gatewayd-io/gatewayd/logging"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAddressSet tests the order of the addresses of the address policies.
+func TestAddressSet(t *testing.T) {
+	addresses := []string{"db1:5432", "db2:5432", "db3:5432"}
+
+	failover := NewAddressSet(addresses, config.Failover, time.Minute)
+	assert.Equal(t, addresses, failover.Addresses())
+	assert.Equal(t, addresses, failover.Addresses())
+
+	roundRobin := NewAddressSet(addresses, config.RoundRobin, time.Minute)
+	assert.Equal(t, addresses, roundRobin.Addresses())
+	assert.Equal(t, []string{"db2:5432", "db3:5432", "db1:5432"}, roundRobin.Addresses())
+	assert.Equal(t, []string{"db3:5432", "db1:5432", "db2:5432"}, roundRobin.Addresses())
+
+	random := NewAddressSet(addresses, config.Random, time.Minute)
+	assert.ElementsMatch(t, addresses, random.Addresses())
+}
+
+// TestAddressSetCooldown tests that the dead addresses are tried last until their cooldown is over.
+func TestAddressSetCooldown(t *testing.T) {
+	addresses := NewAddressSet(
+		[]string{"db1:5432", "db2:5432", "db3:5432"}, config.Failover, 100*time.Millisecond)
+
+	assert.True(t, addresses.MarkDead("db1:5432"))
+	assert.False(t, addresses.MarkDead("db1:5432"))
+	assert.False(t, addresses.IsHealthy("db1:5432"))
+	assert.Equal(t, []string{"db2:5432", "db3:5432", "db1:5432"}, addresses.Addresses())
+
+	// All the addresses are still tried if all of them are dead.
+	addresses.MarkDead("db3:5432")
+	addresses.MarkDead("db2:5432")
+	assert.Equal(t, []string{"db1:5432", "db3:5432", "db2:5432"}, addresses.Addresses())
+
+	assert.True(t, addresses.MarkAlive("db2:5432"))
+	assert.False(t, addresses.MarkAlive("db2:5432"))
+	assert.Equal(t, []string{"db2:5432", "db1:5432", "db3:5432"}, addresses.Addresses())
+
+	time.Sleep(150 * time.Millisecond)
+	assert.True(t, addresses.IsHealthy("db1:5432"))
+	assert.Equal(t, []string{"db1:5432", "db2:5432", "db3:5432"}, addresses.Addresses())
+}
+
+// TestNewClientWithFailover tests that the client connects to the next address
+// if the first one is unreachable, and that the other clients skip it.
+func TestNewClientWithFailover(t *testing.T) {
+	logger := logging.NewLogger(context.Background(), logging.LoggerConfig{
+		Output:            []config.LogOutput{config.Console},
+		TimeFormat:        zerolog.TimeFormatUnix,
+		ConsoleTimeFormat: time.RFC3339,
+		Level:             zerolog.DebugLevel,
+		NoColor:           true,
+	})
+
+	// Reserve an address that refuses the connections.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	deadAddress := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	clientConfig := &config.Client{
+		Network:          "tcp",
+		Addresses:        []string{deadAddress, "localhost:5432"},
+		AddressPolicy:    string(config.Failover),
+		AddressCooldown:  time.Minute,
+		ReceiveChunkSize: config.DefaultChunkSize,
+		DialTimeout:      time.Second,
+	}
+
+	client := NewClient(context.Background(), clientConfig, logger, nil)
+	require.NotNil(t, client)
+	defer client.Close()
+	assert.Equal(t, "localhost:5432", client.Address)
+	assert.True(t, client.IsAddressHealthy())
+
+	addresses := GetAddressSet(clientConfig)
+	assert.Same(t, client.addresses, addresses)
+	assert.False(t, addresses.IsHealthy(deadAddress))
+	assert.Equal(t, []string{"localhost:5432", deadAddress}, addresses.Addresses())
+
+	require.NoError(t, client.Reconnect())
+	assert.Equal(t, "localhost:5432", client.Address)
+	assert.True(t, client.IsConnected())
+
+	// The health check replaces the connections to a dead address.
+	addresses.MarkDead("localhost:5432")
+	addresses.MarkAlive(deadAddress)
+	assert.False(t, client.IsAddressHealthy())
+	assert.Nil(t, GetAddressSet(&config.Client{Address: "localhost:5432"}))
+}
@@ -0,0 +1,422 @@
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"go.opentelemetry.io/otel"
+)
+
+// md5PasswordLength is the length of an md5 hash of a password, like the ones of pg_authid.
+const md5PasswordLength = 35
+
+// Authenticate authenticates the incoming connection with the password of the user of its
+// StartupMessage, if the proxy authenticates the clients itself, and then starts the session
+// as if the server had answered the StartupMessage. The ParameterStatus messages are the ones
+// of a server connection, since they authenticate with the credentials of the client config,
+// and are shared by all the users, so the clients can only connect to the database of the
+// client config. It does nothing if the authentication is passed through.
+func (pr *Proxy) Authenticate(conn *ConnWrapper) *gerr.GatewayDError {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "Authenticate")
+	defer span.End()
+
+	if pr.AuthType == config.PassthroughAuth {
+		return nil
+	}
+
+	session, ok := pr.sessions.Get(conn).(*Session)
+	if !ok {
+		span.RecordError(gerr.ErrClientNotFound)
+		return gerr.ErrClientNotFound
+	}
+
+	if err := conn.Conn().SetDeadline(time.Now().Add(config.DefaultAuthTimeout)); err != nil {
+		span.RecordError(err)
+		return gerr.ErrAuthFailed.Wrap(err)
+	}
+	defer func() {
+		if err := conn.Conn().SetDeadline(time.Time{}); err != nil {
+			pr.logger.Error().Err(err).Msg("Failed to reset the deadline")
+		}
+	}()
+
+	// The server put back the StartupMessage after routing the connection.
+	startupMessage, origErr := conn.Framer().ReadMessage()
+	if origErr != nil {
+		span.RecordError(origErr)
+		return gerr.ErrReadFailed.Wrap(origErr)
+	}
+	parameters := ParseStartupMessage(startupMessage)
+	if parameters["user"] == "" {
+		err := gerr.ErrMalformedMessage.Wrap(errors.New("the StartupMessage has no user"))
+		span.RecordError(err)
+		return err
+	}
+	user := parameters["user"]
+
+	// The users share the server connections, which are all connected to the database of
+	// the client config, so the clients can't connect to another database.
+	database := config.If[string](parameters["database"] != "", parameters["database"], user)
+	if database != config.If[string](
+		pr.ClientConfig.Database != "", pr.ClientConfig.Database, pr.ClientConfig.User) {
+		err := gerr.ErrDatabaseMismatch.Wrap(fmt.Errorf("database %q", database))
+		span.RecordError(err)
+		return err
+	}
+
+	client, err := pr.getClient(conn)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	secret, err := pr.lookupSecret(client, user)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if origErr := pr.exchangePassword(conn, user, secret); origErr != nil {
+		err := gerr.ErrAuthFailed.Wrap(fmt.Errorf("user %q: %w", user, origErr))
+		span.RecordError(err)
+		return err
+	}
+
+	// The server connections authenticate with the credentials of the client config,
+	// so all the sessions share them.
+	key := "user=" + pr.ClientConfig.User + " database=" + pr.ClientConfig.Database
+	cancelKey := session.start(parameters, key)
+	if pr.PoolMode == config.Transaction {
+		pr.mu.Lock()
+		pr.keySessions[key]++
+		pr.mu.Unlock()
+	}
+
+	response := Authentication(PostgresAuthOk, nil)
+	response = append(response, client.parameterStatus...)
+	response = append(response, BackendKeyData(cancelKey.ProcessID, cancelKey.SecretKey)...)
+	response = append(response, ReadyForQuery(PostgresTxIdle)...)
+	if _, err := conn.Write(response); err != nil {
+		span.RecordError(err)
+		return gerr.ErrServerSendFailed.Wrap(err)
+	}
+
+	if pr.PoolMode == config.Transaction {
+		// The client is only attached to the session until its first transaction.
+		session.mu.Lock()
+		session.client = nil
+		pr.busyConnections.Remove(conn)
+		session.mu.Unlock()
+		pr.releaseClient(client, key)
+	}
+
+	pr.logger.Debug().Fields(
+		map[string]interface{}{
+			"user":   user,
+			"remote": RemoteAddr(conn.Conn()),
+		},
+	).Msg("Authenticated the client")
+	span.AddEvent("Authenticated the client")
+
+	return nil
+}
+
+// lookupSecret returns the password of the user from the auth file, or else from the auth
+// query, which runs on the server connection of the incoming connection. The password is
+// empty if the user is unknown.
+func (pr *Proxy) lookupSecret(client *Client, user string) (string, *gerr.GatewayDError) {
+	if pr.AuthFile != "" {
+		users, err := LoadUserList(pr.AuthFile)
+		if err != nil {
+			return "", gerr.ErrAuthFailed.Wrap(err)
+		}
+		if secret, ok := users[user]; ok {
+			return secret, nil
+		}
+	}
+
+	if pr.AuthQuery == "" {
+		return "", nil
+	}
+	row, err := client.QueryRow(config.DefaultAuthTimeout, pr.AuthQuery, user)
+	if err != nil {
+		return "", gerr.ErrAuthFailed.Wrap(err)
+	}
+	if len(row) == 0 {
+		return "", nil
+	}
+	return string(row[len(row)-1]), nil
+}
+
+// exchangePassword runs the password exchange of the authentication method with the client.
+// A user whose password is a SCRAM-SHA-256 secret is authenticated with SCRAM-SHA-256 even
+// with the md5 method, since the md5 hash of the password is unknown, like PostgreSQL does.
+// The exchange runs with a random password for an unknown user, so that it fails without
+// telling the client whether the user exists.
+func (pr *Proxy) exchangePassword(conn *ConnWrapper, user, secret string) error {
+	if secret == "" {
+		secret = scramNonce()
+	}
+
+	scramSecret, isSCRAM := ParseSCRAMSecret(secret)
+	isMD5 := len(secret) == md5PasswordLength && strings.HasPrefix(secret, "md5")
+	switch {
+	case isSCRAM:
+	case pr.AuthType == config.MD5Auth && isMD5:
+		return exchangeMD5(conn, secret)
+	case pr.AuthType == config.MD5Auth:
+		return exchangeMD5(conn, MD5Password(user, secret))
+	case isMD5:
+		return errors.New("the md5 hash of the password can't be used with SCRAM-SHA-256")
+	default:
+		scramSecret = NewSCRAMSecret(secret)
+	}
+	return exchangeSCRAM(conn, scramSecret)
+}
+
+// exchangeMD5 runs the md5 password exchange with the client.
+func exchangeMD5(conn *ConnWrapper, md5Password string) error {
+	salt := make([]byte, PostgresLengthSize)
+	_, _ = rand.Read(salt)
+	if _, err := conn.Write(Authentication(PostgresAuthMD5Password, salt)); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	response, err := readPasswordMessage(conn)
+	if err != nil {
+		return err
+	}
+	expected := MD5Response(md5Password, salt)
+	if subtle.ConstantTimeCompare(bytes.TrimSuffix(response, []byte{0}), []byte(expected)) != 1 {
+		return errors.New("the password doesn't match")
+	}
+	return nil
+}
+
+// exchangeSCRAM runs the SCRAM-SHA-256 exchange with the client, without channel binding.
+func exchangeSCRAM(conn *ConnWrapper, secret *SCRAMSecret) error {
+	if _, err := conn.Write(Authentication(PostgresAuthSASL, []byte(SCRAMSHA256+"\x00\x00"))); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	// The SASLInitialResponse has the mechanism and the length of the client-first-message.
+	response, err := readPasswordMessage(conn)
+	if err != nil {
+		return err
+	}
+	mechanism, rest, _ := bytes.Cut(response, []byte{0})
+	if string(mechanism) != SCRAMSHA256 || len(rest) < PostgresLengthSize {
+		return fmt.Errorf("the client chose the unsupported SASL mechanism %q", mechanism)
+	}
+	clientFirst := string(rest[PostgresLengthSize:])
+	if !strings.HasPrefix(clientFirst, "n,,") && !strings.HasPrefix(clientFirst, "y,,") {
+		return errors.New("the client requested an unsupported SCRAM channel binding")
+	}
+	gs2Header, clientFirstBare := clientFirst[:3], clientFirst[3:]
+	clientNonce := parseSCRAMAttributes(clientFirstBare)['r']
+	if clientNonce == "" {
+		return errors.New("the client sent no SCRAM nonce")
+	}
+
+	nonce := clientNonce + scramNonce()
+	serverFirst := "r=" + nonce +
+		",s=" + base64.StdEncoding.EncodeToString(secret.Salt) +
+		",i=" + strconv.Itoa(secret.Iterations)
+	if _, err := conn.Write(Authentication(PostgresAuthSASLContinue, []byte(serverFirst))); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	response, err = readPasswordMessage(conn)
+	if err != nil {
+		return err
+	}
+	clientFinal := string(response)
+	proofIndex := strings.LastIndex(clientFinal, ",p=")
+	attributes := parseSCRAMAttributes(clientFinal)
+	proof, proofErr := base64.StdEncoding.DecodeString(attributes['p'])
+	if proofIndex < 0 || proofErr != nil ||
+		attributes['c'] != base64.StdEncoding.EncodeToString([]byte(gs2Header)) ||
+		attributes['r'] != nonce {
+		return errors.New("the client sent an invalid SCRAM client-final-message")
+	}
+
+	authMessage := clientFirstBare + "," + serverFirst + "," + clientFinal[:proofIndex]
+	if !secret.VerifyProof(authMessage, proof) {
+		return errors.New("the password doesn't match")
+	}
+
+	serverFinal := "v=" + base64.StdEncoding.EncodeToString(secret.ServerSignature(authMessage))
+	if _, err := conn.Write(Authentication(PostgresAuthSASLFinal, []byte(serverFinal))); err != nil {
+		return err //nolint:wrapcheck
+	}
+	return nil
+}
+
+// readPasswordMessage reads a message of the password exchange from the client and returns its body.
+func readPasswordMessage(conn *ConnWrapper) ([]byte, error) {
+	msg, err := conn.Framer().ReadMessage()
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	if msg[0] != PostgresPassword {
+		return nil, fmt.Errorf("the client sent the message type %q instead of a password", msg[0])
+	}
+	return msg[PostgresMessageHeaderSize:], nil
+}
+
+// LoadUserList loads the users and their passwords from a file with lines like "user" "password",
+// like the auth_file of PgBouncer. A double quote in a user or password is doubled. The password
+// is in plain text, an md5 hash or a SCRAM-SHA-256 secret. The other lines, like comments, are skipped.
+func LoadUserList(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	users := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		user, rest, ok := readQuoted(strings.TrimSpace(line))
+		if !ok {
+			continue
+		}
+		if password, _, ok := readQuoted(strings.TrimSpace(rest)); ok {
+			users[user] = password
+		}
+	}
+	return users, nil
+}
+
+// readQuoted reads the double-quoted string at the start of the text, and returns it along
+// with the rest of the text. It returns false if the text doesn't start with a quoted string.
+func readQuoted(text string) (string, string, bool) {
+	if !strings.HasPrefix(text, `"`) {
+		return "", "", false
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(text); i++ {
+		switch {
+		case text[i] != '"':
+			value.WriteByte(text[i])
+		case i+1 < len(text) && text[i+1] == '"':
+			value.WriteByte('"')
+			i++
+		default:
+			return value.String(), text[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// authenticate starts a session on the server with the credentials of the client config, and
+// keeps the ParameterStatus and BackendKeyData messages of the server. It does nothing if the
+// client config has no user, in which case the session is started by the first client.
+func (c *Client) authenticate() *gerr.GatewayDError {
+	if c.User == "" {
+		return nil
+	}
+
+	if err := c.conn.SetDeadline(time.Now().Add(config.DefaultAuthTimeout)); err != nil {
+		return gerr.ErrServerAuthFailed.Wrap(err)
+	}
+	defer func() {
+		if err := c.conn.SetDeadline(time.Time{}); err != nil {
+			c.logger.Error().Err(err).Msg("Failed to reset the deadline")
+		}
+	}()
+
+	parameters := map[string]string{"user": c.User}
+	if c.Database != "" {
+		parameters["database"] = c.Database
+	}
+	if _, err := c.conn.Write(StartupMessage(parameters)); err != nil {
+		return gerr.ErrServerAuthFailed.Wrap(err)
+	}
+
+	scram := NewSCRAMClient(c.password)
+	c.parameterStatus = nil
+	for {
+		msg, err := c.framer.ReadMessage()
+		if err != nil {
+			return gerr.ErrServerAuthFailed.Wrap(err)
+		}
+
+		body := msg[PostgresMessageHeaderSize:]
+		switch msg[0] {
+		case PostgresAuthentication:
+			if err := c.answerAuthentication(body, c.User, scram); err != nil {
+				return gerr.ErrServerAuthFailed.Wrap(err)
+			}
+		case PostgresParameterStatus:
+			c.parameterStatus = append(c.parameterStatus, msg...)
+		case PostgresBackendKeyData:
+			if len(body) == 2*PostgresLengthSize {
+				c.backendKey = &CancelKey{
+					ProcessID: binary.BigEndian.Uint32(body[:PostgresLengthSize]),
+					SecretKey: binary.BigEndian.Uint32(body[PostgresLengthSize:]),
+				}
+			}
+		case PostgresErrorResponse:
+			return gerr.ErrServerAuthFailed.Wrap(errors.New(ErrorResponseMessage(body)))
+		case PostgresReadyForQuery:
+			return nil
+		}
+	}
+}
+
+// answerAuthentication answers the Authentication message of the server with the password
+// of the client config for the user. The password is sent in plain text only if the server
+// requests it.
+func (c *Client) answerAuthentication(body []byte, user string, scram *SCRAMClient) error {
+	if len(body) < PostgresLengthSize {
+		return errors.New("the server sent a malformed Authentication message")
+	}
+	code, data := binary.BigEndian.Uint32(body), body[PostgresLengthSize:]
+
+	var response []byte
+	switch code {
+	case PostgresAuthOk:
+		return nil
+	case PostgresAuthCleartextPassword:
+		response = append([]byte(c.password), 0)
+	case PostgresAuthMD5Password:
+		if len(data) != PostgresLengthSize {
+			return errors.New("the server sent a malformed md5 salt")
+		}
+		response = append([]byte(MD5Response(MD5Password(user, c.password), data)), 0)
+	case PostgresAuthSASL:
+		if !slices.Contains(strings.Split(string(data), "\x00"), SCRAMSHA256) {
+			return fmt.Errorf("the server offered the unsupported SASL mechanisms %q", data)
+		}
+		clientFirst := scram.ClientFirstMessage()
+		response = append([]byte(SCRAMSHA256), 0)
+		response = binary.BigEndian.AppendUint32(response, uint32(len(clientFirst)))
+		response = append(response, clientFirst...)
+	case PostgresAuthSASLContinue:
+		clientFinal, err := scram.ClientFinalMessage(string(data))
+		if err != nil {
+			return err
+		}
+		response = []byte(clientFinal)
+	case PostgresAuthSASLFinal:
+		return scram.VerifyServerFinal(string(data))
+	default:
+		return fmt.Errorf("the server requested the unsupported authentication method %d", code)
+	}
+
+	if _, err := c.conn.Write(PasswordMessage(response)); err != nil {
+		return err //nolint:wrapcheck
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package network
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/gatewayd-io/gatewayd/pool"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startFakeAuthServer starts a server that authenticates the clients like the proxy does,
+// with the given method and password, and then starts their sessions.
+func startFakeAuthServer(t *testing.T, authType config.AuthType, secret string) string {
+	t.Helper()
+
+	proxy := &Proxy{AuthType: authType}
+	return startFakeServer(t, func(netConn net.Conn) {
+		conn := NewConnWrapper(netConn, nil, 0)
+		startupMessage, err := conn.Framer().ReadMessage()
+		if err != nil {
+			return
+		}
+		user := ParseStartupMessage(startupMessage)["user"]
+
+		if err := proxy.exchangePassword(conn, user, secret); err != nil {
+			_, _ = conn.Write(ErrorResponse(PostgresSeverityFatal, "28P01", err.Error()))
+			return
+		}
+
+		response := Authentication(PostgresAuthOk, nil)
+		response = append(response, CreatePostgreSQLPacket(
+			PostgresParameterStatus, []byte("server_version\x0016.0\x00"))...)
+		response = append(response, BackendKeyData(42, 7)...)
+		response = append(response, ReadyForQuery(PostgresTxIdle)...)
+		_, _ = conn.Write(response)
+	})
+}
+
+// newAuthClient creates a client that authenticates with its own credentials.
+func newAuthClient(address, password string) *Client {
+	return NewClient(
+		context.Background(),
+		&config.Client{
+			Network:          "tcp",
+			Address:          address,
+			ReceiveChunkSize: config.DefaultChunkSize,
+			DialTimeout:      time.Second,
+			User:             "gatewayd",
+			Password:         password,
+			Database:         "postgres",
+		},
+		zerolog.Nop(),
+		nil)
+}
+
+// TestClientAuthenticate tests that the clients authenticate with their own credentials
+// against the password exchanges of the proxy.
+func TestClientAuthenticate(t *testing.T) {
+	tests := []struct {
+		name     string
+		authType config.AuthType
+		secret   string
+		password string
+		ok       bool
+	}{
+		{"scram", config.SCRAMSHA256Auth, NewSCRAMSecret("secret").String(), "secret", true},
+		{"scram with a plain password", config.SCRAMSHA256Auth, "secret", "secret", true},
+		{"md5", config.MD5Auth, MD5Password("gatewayd", "secret"), "secret", true},
+		{"md5 with a scram secret", config.MD5Auth, NewSCRAMSecret("secret").String(), "secret", true},
+		{"scram with an md5 hash", config.SCRAMSHA256Auth, MD5Password("gatewayd", "secret"), "secret", false},
+		{"wrong password", config.SCRAMSHA256Auth, NewSCRAMSecret("secret").String(), "wrong", false},
+		{"unknown user", config.MD5Auth, "", "secret", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newAuthClient(startFakeAuthServer(t, test.authType, test.secret), test.password)
+			if !test.ok {
+				assert.Nil(t, client)
+				return
+			}
+
+			require.NotNil(t, client)
+			defer client.Close()
+			assert.True(t, client.authenticatesItself())
+			assert.Equal(t, &CancelKey{ProcessID: 42, SecretKey: 7}, client.backendKey)
+			assert.Equal(t,
+				CreatePostgreSQLPacket(PostgresParameterStatus, []byte("server_version\x0016.0\x00")),
+				client.parameterStatus)
+		})
+	}
+}
+
+// TestProxyAuthenticateDatabase tests that the clients are rejected if they connect to
+// another database than the one of the server connections, which defaults to the user.
+func TestProxyAuthenticateDatabase(t *testing.T) {
+	proxy := NewProxy(
+		context.Background(),
+		pool.NewPool(context.Background(), 1),
+		nil,
+		nil,
+		&config.Proxy{
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+			AuthType:          string(config.MD5Auth),
+		},
+		&config.Client{
+			Network: "tcp",
+			User:    "postgres",
+		},
+		zerolog.Nop(),
+		config.DefaultPluginTimeout,
+	)
+	defer proxy.Shutdown()
+
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		err        *gerr.GatewayDError
+	}{
+		{"same database", map[string]string{"user": "alice", "database": "postgres"}, gerr.ErrClientNotFound},
+		{"default database", map[string]string{"user": "postgres"}, gerr.ErrClientNotFound},
+		{"other database", map[string]string{"user": "alice", "database": "alice"}, gerr.ErrDatabaseMismatch},
+		{"default other database", map[string]string{"user": "alice"}, gerr.ErrDatabaseMismatch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			netConn, client := net.Pipe()
+			defer netConn.Close()
+			defer client.Close()
+			conn := NewConnWrapper(netConn, nil, config.DefaultHandshakeTimeout)
+			require.Nil(t, proxy.sessions.Put(conn, NewSession()))
+			defer proxy.sessions.Remove(conn)
+
+			go func() {
+				_, _ = client.Write(StartupMessage(test.parameters))
+			}()
+			// The database is checked before the server connection of the session is needed.
+			err := proxy.Authenticate(conn)
+			require.NotNil(t, err)
+			assert.Equal(t, test.err.Code, err.Code)
+		})
+	}
+	assert.Contains(t, string(GatewayDErrorResponse(gerr.ErrDatabaseMismatch)), "C3D000")
+}
+
+// TestParseSCRAMSecret tests that the SCRAM secrets are parsed from the format of pg_authid.
+func TestParseSCRAMSecret(t *testing.T) {
+	secret := NewSCRAMSecret("secret")
+	parsed, ok := ParseSCRAMSecret(secret.String())
+	require.True(t, ok)
+	assert.Equal(t, secret, parsed)
+
+	for _, invalid := range []string{
+		"",
+		"secret",
+		MD5Password("gatewayd", "secret"),
+		"SCRAM-SHA-256$0:c2FsdA==$a2V5:a2V5",
+		"SCRAM-SHA-256$4096:c2FsdA==$a2V5:a2V5",
+	} {
+		_, ok := ParseSCRAMSecret(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
+
+// TestLoadUserList tests that the users are loaded from a file in the format of PgBouncer.
+func TestLoadUserList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "userlist.txt")
+	require.NoError(t, os.WriteFile(path, []byte(
+		"; comment\n"+
+			"\"alice\" \"secret\"\n"+
+			"  \"bob\"   \"md5a3556571e93b0d20722ba62be61e8c2d\"  \n"+
+			"\"quoted \"\"user\"\"\" \"pass\"\"word\"\n"+
+			"\"incomplete\n"),
+		0o600))
+
+	users, err := LoadUserList(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"alice":         "secret",
+		"bob":           "md5a3556571e93b0d20722ba62be61e8c2d",
+		`quoted "user"`: `pass"word`,
+	}, users)
+
+	_, err = LoadUserList(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,72 @@
+package network
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// Size classes of the buffer pool, in powers of two from 512 bytes to 1 MiB. The larger
+// buffers are not pooled, so that a large result set is not kept in memory.
+const (
+	minBufferClass = 9
+	maxBufferClass = 20
+)
+
+// bufferPool keeps the buffers of the requests and the responses, so that they are reused
+// by the next reads instead of being allocated for every message. The buffers are grouped
+// in size classes, and a buffer is always taken from the class that is large enough for it.
+type bufferPool struct {
+	classes [maxBufferClass - minBufferClass + 1]sync.Pool
+	// headers keeps the pointers that the buffers are stored with in the classes,
+	// which would otherwise be allocated every time a buffer is given back.
+	headers sync.Pool
+}
+
+// buffers is the buffer pool of the traffic of all the proxies.
+var buffers bufferPool
+
+// get returns an empty buffer with a capacity of at least size bytes.
+func (p *bufferPool) get(size int) []byte {
+	class := bufferClass(size)
+	if class > maxBufferClass {
+		return make([]byte, 0, size)
+	}
+
+	if header, ok := p.classes[class-minBufferClass].Get().(*[]byte); ok {
+		buffer := *header
+		*header = nil
+		p.headers.Put(header)
+		return buffer[:0]
+	}
+	return make([]byte, 0, 1<<class)
+}
+
+// put gives the buffer back to the pool. The buffer is kept in the largest class that it
+// is large enough for, unless it is smaller or larger than all the classes.
+func (p *bufferPool) put(buffer []byte) {
+	capacity := cap(buffer)
+	if capacity < 1<<minBufferClass || capacity > 1<<maxBufferClass {
+		return
+	}
+
+	header, ok := p.headers.Get().(*[]byte)
+	if !ok {
+		header = new([]byte)
+	}
+	*header = buffer[:0]
+	p.classes[bits.Len(uint(capacity))-1-minBufferClass].Put(header)
+}
+
+// bufferClass returns the smallest size class that is large enough for size bytes.
+func bufferClass(size int) int {
+	if size <= 1<<minBufferClass {
+		return minBufferClass
+	}
+	return bits.Len(uint(size - 1))
+}
+
+// releaseBuffer gives a buffer that is returned by Framer.ReadMessages back to the pool.
+// The buffer and the slices of it must not be used afterwards.
+func releaseBuffer(buffer []byte) {
+	buffers.put(buffer)
+}
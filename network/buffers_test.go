@@ -0,0 +1,39 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBufferPool tests that the buffers are taken from the size class that is large
+// enough for them, and that the buffers out of the size classes are not pooled.
+func TestBufferPool(t *testing.T) {
+	assert.Equal(t, minBufferClass, bufferClass(0))
+	assert.Equal(t, minBufferClass, bufferClass(512))
+	assert.Equal(t, 10, bufferClass(513))
+	assert.Equal(t, 13, bufferClass(8192))
+	assert.Equal(t, maxBufferClass+1, bufferClass(1<<maxBufferClass+1))
+
+	var pool bufferPool
+	buffer := pool.get(100)
+	assert.Empty(t, buffer)
+	assert.Equal(t, 512, cap(buffer))
+	assert.Equal(t, 1024, cap(pool.get(513)))
+	assert.Equal(t, 2<<maxBufferClass, cap(pool.get(2<<maxBufferClass)))
+
+	// A buffer that is given back is empty when it is taken again.
+	buffer = append(buffer, "data"...)
+	pool.put(buffer)
+	buffer = pool.get(512)
+	assert.Empty(t, buffer)
+	assert.Equal(t, 512, cap(buffer))
+
+	// A buffer that has grown past its class serves the class below its capacity.
+	pool.put(make([]byte, 0, 1500))
+	assert.GreaterOrEqual(t, cap(pool.get(1024)), 1024)
+
+	// The buffers out of the size classes are dropped.
+	pool.put(make([]byte, 0, 10))
+	pool.put(make([]byte, 0, 2<<maxBufferClass))
+}
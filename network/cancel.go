@@ -0,0 +1,148 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"go.opentelemetry.io/otel"
+)
+
+// cancelKeys maps the keys that are sent to the clients in the BackendKeyData message to
+// their sessions. The clients can't be given the keys of the server connections, since the
+// server connection of a session changes in transaction pooling mode. The keys are shared
+// by all the proxies, since a CancelRequest has no parameters to route it to a proxy.
+var cancelKeys sync.Map
+
+// CancelKey is the process ID and secret key of a BackendKeyData message,
+// which are sent back by the client in a CancelRequest.
+type CancelKey struct {
+	ProcessID uint32
+	SecretKey uint32
+}
+
+// newCancelKey creates a random key that is not used by another session and maps it to the session.
+func newCancelKey(session *Session) CancelKey {
+	for {
+		var random [2 * PostgresLengthSize]byte
+		_, _ = rand.Read(random[:])
+		key := CancelKey{
+			// The process ID is a positive int32 for the clients.
+			ProcessID: binary.BigEndian.Uint32(random[:PostgresLengthSize]) & 0x7fffffff,
+			SecretKey: binary.BigEndian.Uint32(random[PostgresLengthSize:]),
+		}
+		if key.ProcessID == 0 {
+			continue
+		}
+		if _, loaded := cancelKeys.LoadOrStore(key, session); !loaded {
+			return key
+		}
+	}
+}
+
+// mapBackendKeyData replaces the key of the server in the BackendKeyData message of the
+// response with the key of the session, and keeps the key of the server on the client,
+// so that the CancelRequests of the session are forwarded to its server connection.
+// The server only sends the BackendKeyData message before the session is started.
+func (s *Session) mapBackendKeyData(client *Client, response []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return
+	}
+
+	ForEachMessage(response, func(msgType byte, body []byte) bool {
+		if msgType != PostgresBackendKeyData || len(body) != 2*PostgresLengthSize {
+			return true
+		}
+
+		client.backendKey = &CancelKey{
+			ProcessID: binary.BigEndian.Uint32(body[:PostgresLengthSize]),
+			SecretKey: binary.BigEndian.Uint32(body[PostgresLengthSize:]),
+		}
+		if s.cancelKey == nil {
+			key := newCancelKey(s)
+			s.cancelKey = &key
+		}
+		binary.BigEndian.PutUint32(body[:PostgresLengthSize], s.cancelKey.ProcessID)
+		binary.BigEndian.PutUint32(body[PostgresLengthSize:], s.cancelKey.SecretKey)
+		return false
+	})
+}
+
+// cancelQuery forwards the CancelRequest of a client to the server connection that is
+// attached to its session, with the key of the server connection. The CancelRequest is
+// sent over a new connection, which the server closes right away, so the pool is not
+// used. There is nothing to cancel if no server connection is attached to the session.
+func (s *Server) cancelQuery(request []byte) *gerr.GatewayDError {
+	_, span := otel.Tracer(config.TracerName).Start(s.ctx, "cancelQuery")
+	defer span.End()
+
+	processID, secretKey, ok := ParseCancelRequest(request)
+	if !ok {
+		span.RecordError(gerr.ErrMalformedMessage)
+		return gerr.ErrMalformedMessage
+	}
+
+	value, ok := cancelKeys.Load(CancelKey{ProcessID: processID, SecretKey: secretKey})
+	if !ok {
+		err := gerr.ErrCancelRequestFailed.Wrap(errors.New("the key of the session is unknown"))
+		span.RecordError(err)
+		return err
+	}
+	session, ok := value.(*Session)
+	if !ok {
+		span.RecordError(gerr.ErrCastFailed)
+		return gerr.ErrCastFailed
+	}
+
+	session.mu.Lock()
+	client := session.client
+	var backendKey *CancelKey
+	if client != nil {
+		backendKey = client.backendKey
+	}
+	session.mu.Unlock()
+	if client == nil || backendKey == nil {
+		s.logger.Debug().Msg("No query to cancel, since the session has no server connection")
+		return nil
+	}
+
+	dialTimeout := config.If[time.Duration](
+		client.DialTimeout > 0, client.DialTimeout, config.DefaultDialTimeout)
+	conn, err := net.DialTimeout(client.Network, client.Address, dialTimeout)
+	if err != nil {
+		span.RecordError(err)
+		return gerr.ErrCancelRequestFailed.Wrap(err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(dialTimeout)); err != nil {
+		span.RecordError(err)
+		return gerr.ErrCancelRequestFailed.Wrap(err)
+	}
+	// The connection only carries the CancelRequest, so its PROXY header has no address.
+	cancelRequest := append(
+		ProxyHeader(client.ProxyProtocol, nil, nil),
+		CancelRequest(backendKey.ProcessID, backendKey.SecretKey)...)
+	if _, err := conn.Write(cancelRequest); err != nil {
+		span.RecordError(err)
+		return gerr.ErrCancelRequestFailed.Wrap(err)
+	}
+
+	s.logger.Debug().Fields(
+		map[string]interface{}{
+			"client":  client.ID[:7],
+			"address": client.Address,
+		},
+	).Msg("Forwarded the cancel request to the server")
+	span.AddEvent("Forwarded the cancel request to the server")
+
+	return nil
+}
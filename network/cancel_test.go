@@ -0,0 +1,55 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestServerCancelQuery tests that the client receives the key of its session instead of the
+// key of the server, and that its CancelRequest is forwarded to the server with the key of the server.
+func TestServerCancelQuery(t *testing.T) {
+	cancelRequests := make(chan []byte, 1)
+	address := startFakeServer(t, func(conn net.Conn) {
+		request := make([]byte, 16)
+		if _, err := io.ReadFull(conn, request); err == nil {
+			cancelRequests <- request
+		}
+	})
+	client := newFakeServerClient(t, address)
+
+	session := NewSession()
+	session.attach(client)
+	response := bytes.Join([][]byte{BackendKeyData(1234, 5678), ReadyForQuery(PostgresTxIdle)}, nil)
+	session.mapBackendKeyData(client, response)
+	require.NotNil(t, session.cancelKey)
+	assert.Equal(t, &CancelKey{ProcessID: 1234, SecretKey: 5678}, client.backendKey)
+	assert.Equal(t,
+		BackendKeyData(session.cancelKey.ProcessID, session.cancelKey.SecretKey),
+		response[:len(BackendKeyData(0, 0))])
+
+	server := &Server{ctx: context.Background(), logger: zerolog.Nop()}
+	require.Nil(t, server.cancelQuery(
+		CancelRequest(session.cancelKey.ProcessID, session.cancelKey.SecretKey)))
+	select {
+	case request := <-cancelRequests:
+		assert.Equal(t, CancelRequest(1234, 5678), request)
+	case <-time.After(time.Second):
+		t.Fatal("the cancel request was not forwarded to the server")
+	}
+
+	// The key of the session is forgotten once the session is closed.
+	key := *session.cancelKey
+	session.Close()
+	err := server.cancelQuery(CancelRequest(key.ProcessID, key.SecretKey))
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodeCancelRequestFailed, err.Code)
+}
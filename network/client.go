@@ -1,9 +1,12 @@
 package network
 
 import (
-	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -16,6 +19,10 @@ import (
 	"go.opentelemetry.io/otel"
 )
 
+// TCPProbeTimeout is how long Probe waits for the server to send data or close the
+// connection. The data or the end of the connection is usually already received.
+const TCPProbeTimeout = time.Millisecond
+
 type IClient interface {
 	Send(data []byte) (int, *gerr.GatewayDError)
 	Receive() (int, []byte, *gerr.GatewayDError)
@@ -29,11 +36,46 @@ type IClient interface {
 
 type Client struct {
 	conn      net.Conn
+	framer    *Framer
 	logger    zerolog.Logger
 	ctx       context.Context //nolint:containedctx
 	connected atomic.Bool
 	mu        sync.Mutex
 	retry     IRetry
+	// sessionKey is set in transaction pooling mode, once the connection
+	// is authenticated for the user and database of a session.
+	sessionKey string
+	// tlsConfig is used to upgrade the connection to TLS, unless SSLMode is disable.
+	tlsConfig     *tls.Config
+	sslServerName string
+	// addresses is the address set of a client with multiple addresses, which
+	// is used to choose the address of each connection.
+	addresses *AddressSet
+	// connectedAt is the time at which the connection was opened, which is used
+	// to retire the connections that are older than the maximum lifetime.
+	connectedAt time.Time
+	// backendKey is the key of the BackendKeyData message of the server,
+	// which is used to forward the CancelRequests of the sessions.
+	backendKey *CancelKey
+	// receiving is held while Receive waits for the server, so that Reset can interrupt
+	// the Receive of the session that is leaving, and wait until it returns.
+	receiving   sync.Mutex
+	interrupted atomic.Bool
+	// expected is the number of ReadyForQuery messages that the server owes for the requests
+	// sent with Send. The receive timeout only applies while it isn't zero, so that the idle
+	// connections are not closed. It is guarded by deadlineMu.
+	deadlineMu sync.Mutex
+	expected   int
+	// password is used to authenticate with the credentials of the client config, in which
+	// case parameterStatus holds the ParameterStatus messages that the server sent.
+	password        string
+	parameterStatus []byte
+	// statements are the statements of the sessions that are prepared on the server
+	// connection in transaction pooling mode.
+	statements *statementCache
+	// proxySource is the incoming connection that the connection is opened for, whose
+	// addresses are sent in the PROXY header. The next connections are not opened for it.
+	proxySource net.Conn
 
 	TCPKeepAlive       bool
 	TCPKeepAlivePeriod time.Duration
@@ -45,10 +87,18 @@ type Client struct {
 	ID                 string
 	Network            string // tcp/udp/unix
 	Address            string
+	SSLMode            config.SSLMode
+	ProxyProtocol      config.ProxyProtocol
+	User               string
+	Database           string
 }
 
 var _ IClient = (*Client)(nil)
 
+// proxySourceKey is the key of the context value that holds the incoming connection that a
+// new client is opened for, so that its addresses are sent in the PROXY header.
+type proxySourceKey struct{}
+
 // NewClient creates a new client.
 func NewClient(
 	ctx context.Context, clientConfig *config.Client, logger zerolog.Logger, retry *Retry,
@@ -65,40 +115,64 @@ func NewClient(
 	client.connected.Store(false)
 	client.logger = logger
 
-	// Try to resolve the address and log an error if it can't be resolved.
-	addr, err := Resolve(clientConfig.Network, clientConfig.Address, logger)
-	if err != nil {
-		logger.Error().Err(err).Msg("Failed to resolve address")
-		span.RecordError(err)
+	// Try to resolve the address and log an error if it can't be resolved. The addresses of a
+	// client with multiple addresses are resolved on each connection, to follow DNS changes.
+	addresses := GetAddressSet(clientConfig)
+	var addr string
+	if addresses == nil {
+		var err *gerr.GatewayDError
+		if addr, err = Resolve(clientConfig.Network, clientConfig.Address, logger); err != nil {
+			logger.Error().Err(err).Msg("Failed to resolve address")
+			span.RecordError(err)
+		}
 	}
 
 	// Create a resolved client.
 	client = Client{
 		ctx:         clientCtx,
 		mu:          sync.Mutex{},
+		logger:      logger,
 		retry:       retry,
+		addresses:   addresses,
 		Network:     clientConfig.Network,
 		Address:     addr,
 		DialTimeout: clientConfig.DialTimeout,
 	}
 
 	// Fall back to the original network and address if the address can't be resolved.
-	if client.Address == "" || client.Network == "" {
+	if addresses == nil && (client.Address == "" || client.Network == "") {
 		client = Client{
 			Network: clientConfig.Network,
 			Address: clientConfig.Address,
 		}
 	}
+	client.statements = newStatementCache()
+
+	// Create the TLS config before dialing, so that the connection
+	// isn't opened if the certificates can't be loaded.
+	client.SSLMode = clientConfig.GetSSLMode()
+	tlsConfig, tlsErr := CreateClientTLSConfig(clientConfig)
+	if tlsErr != nil {
+		err := gerr.ErrGetTLSConfigFailed.Wrap(tlsErr)
+		logger.Error().Err(err).Msg("Failed to create the TLS config of the client")
+		span.RecordError(err)
+		return nil
+	}
+	client.tlsConfig = tlsConfig
+	client.sslServerName = clientConfig.SSLServerName
+	client.User = clientConfig.User
+	client.Database = clientConfig.Database
+	client.password = clientConfig.Password
+	client.ProxyProtocol = clientConfig.GetProxyProtocol()
+	if source, ok := ctx.Value(proxySourceKey{}).(net.Conn); ok {
+		client.proxySource = source
+	}
 
 	var origErr error
 	// Create a new connection and retry a few times if needed.
 	//nolint:wrapcheck
 	if conn, err := client.retry.Retry(func() (any, error) {
-		if client.DialTimeout > 0 {
-			return net.DialTimeout(client.Network, client.Address, client.DialTimeout)
-		} else {
-			return net.Dial(client.Network, client.Address)
-		}
+		return client.dial()
 	}); err != nil {
 		origErr = err
 	} else {
@@ -116,6 +190,8 @@ func NewClient(
 	}
 
 	client.connected.Store(true)
+	client.connectedAt = time.Now()
+	client.proxySource = nil
 
 	// Set the TCP keep alive.
 	client.TCPKeepAlive = clientConfig.TCPKeepAlive
@@ -133,6 +209,32 @@ func NewClient(
 		}
 	}
 
+	// Upgrade the connection to TLS before any data is sent to the server.
+	if err := client.upgradeToTLS(); err != nil {
+		logger.Error().Err(err).Msg("Failed to upgrade the connection to TLS")
+		span.RecordError(err)
+		if err := client.conn.Close(); err != nil {
+			logger.Error().Err(err).Msg("Failed to close connection")
+		}
+		return nil
+	}
+
+	// Set the receive chunk size. This is the size of the buffer that is read from the connection
+	// in chunks.
+	client.ReceiveChunkSize = clientConfig.ReceiveChunkSize
+	// The server only sends typed messages.
+	client.framer = NewFramer(client.conn, client.ReceiveChunkSize, false)
+
+	// Authenticate with the credentials of the client config, if any.
+	if err := client.authenticate(); err != nil {
+		logger.Error().Err(err).Msg("Failed to authenticate with the server")
+		span.RecordError(err)
+		if err := client.conn.Close(); err != nil {
+			logger.Error().Err(err).Msg("Failed to close connection")
+		}
+		return nil
+	}
+
 	// Set the receive deadline (timeout).
 	client.ReceiveDeadline = clientConfig.ReceiveDeadline
 	if client.ReceiveDeadline > 0 {
@@ -157,10 +259,6 @@ func NewClient(
 		}
 	}
 
-	// Set the receive chunk size. This is the size of the buffer that is read from the connection
-	// in chunks.
-	client.ReceiveChunkSize = clientConfig.ReceiveChunkSize
-
 	logger.Trace().Str("address", client.Address).Msg("New client created")
 	client.ID = GetID(
 		client.conn.LocalAddr().Network(),
@@ -174,8 +272,18 @@ func NewClient(
 	return &client
 }
 
-// Send sends data to the server.
+// Send sends data to the server. The receive timeout applies from then on, until the server
+// has answered the requests.
 func (c *Client) Send(data []byte) (int, *gerr.GatewayDError) {
+	sent, err := c.send(data)
+	if err == nil {
+		c.expectResponse(data)
+	}
+	return sent, err
+}
+
+// send sends data to the server, without expecting a response.
+func (c *Client) send(data []byte) (int, *gerr.GatewayDError) {
 	_, span := otel.Tracer(config.TracerName).Start(c.ctx, "Send")
 	defer span.End()
 
@@ -213,47 +321,84 @@ func (c *Client) Send(data []byte) (int, *gerr.GatewayDError) {
 	return sent, nil
 }
 
-// Receive receives data from the server.
+// Receive receives data from the server. It returns at least one whole message,
+// followed by the whole messages that were already received from the server.
+// While the server hasn't answered the requests that were sent, the receive fails
+// if nothing is received within the receive timeout, if any.
 func (c *Client) Receive() (int, []byte, *gerr.GatewayDError) {
+	return c.receive(c.ReceiveTimeout)
+}
+
+// expectResponse counts the requests that the server answers with a ReadyForQuery message,
+// and gives the server the receive timeout to send the next data while there are any.
+func (c *Client) expectResponse(request []byte) {
+	if c.ReceiveTimeout <= 0 {
+		return
+	}
+
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+
+	if IsPostgresStartupMessage(request) {
+		c.expected++
+	} else {
+		c.expected += CountReadyForQueryRequests(request)
+	}
+	if c.expected > 0 {
+		c.setReceiveDeadline(time.Now().Add(c.ReceiveTimeout))
+	}
+}
+
+// setReceiveDeadline sets the read deadline of the connection, unless Reset interrupts the
+// receive with a past deadline, which must not be replaced. It must be called with deadlineMu held.
+func (c *Client) setReceiveDeadline(deadline time.Time) {
+	if err := c.conn.SetReadDeadline(deadline); err != nil {
+		c.logger.Error().Err(err).Msg("Failed to set the read deadline")
+	}
+	if c.interrupted.Load() {
+		if err := c.conn.SetReadDeadline(time.Now()); err != nil {
+			c.logger.Error().Err(err).Msg("Failed to set the read deadline")
+		}
+	}
+}
+
+// receive receives data from the server within the timeout, while a response is expected.
+// Without a timeout, the deadline of the connection is left as it is.
+func (c *Client) receive(timeout time.Duration) (int, []byte, *gerr.GatewayDError) {
 	_, span := otel.Tracer(config.TracerName).Start(c.ctx, "Receive")
 	defer span.End()
 
-	if !c.connected.Load() {
+	if !c.connected.Load() || c.framer == nil {
 		span.RecordError(gerr.ErrClientNotConnected)
 		return 0, nil, gerr.ErrClientNotConnected
 	}
 
-	var ctx context.Context
-	var cancel context.CancelFunc
-	if c.ReceiveTimeout > 0 {
-		ctx, cancel = context.WithTimeout(c.ctx, c.ReceiveTimeout)
-		defer cancel()
-	} else {
-		ctx = context.Background()
+	c.receiving.Lock()
+	defer c.receiving.Unlock()
+
+	received, err := c.framer.ReadMessages()
+	if timeout > 0 {
+		// The next data is due within the timeout, until the requests are answered.
+		count, _ := ReadyForQueryStatus(received)
+		c.deadlineMu.Lock()
+		c.expected = max(c.expected-count, 0)
+		c.setReceiveDeadline(config.If[time.Time](
+			c.expected > 0, time.Now().Add(timeout), time.Time{}))
+		c.deadlineMu.Unlock()
 	}
-
-	var received int
-	buffer := bytes.NewBuffer(nil)
-	// Read the data in chunks.
-	for ctx.Err() == nil {
-		chunk := make([]byte, c.ReceiveChunkSize)
-		read, err := c.conn.Read(chunk)
-		if err != nil {
+	if err != nil {
+		if c.interrupted.Load() {
+			c.logger.Debug().Err(err).Msg("Interrupted receiving data from the server")
+		} else {
 			c.logger.Error().Err(err).Msg("Couldn't receive data from the server")
-			span.RecordError(err)
-			return received, buffer.Bytes(), gerr.ErrClientReceiveFailed.Wrap(err)
-		}
-		received += read
-		buffer.Write(chunk[:read])
-
-		if read == 0 || read < c.ReceiveChunkSize {
-			break
 		}
+		span.RecordError(err)
+		return len(received), received, gerr.ErrClientReceiveFailed.Wrap(err)
 	}
 
 	span.AddEvent("Received data from server")
 
-	return received, buffer.Bytes(), nil
+	return len(received), received, nil
 }
 
 // Reconnect reconnects to the server.
@@ -280,11 +425,7 @@ func (c *Client) Reconnect() error {
 	// Create a new connection and retry a few times if needed.
 	//nolint:wrapcheck
 	if conn, err := c.retry.Retry(func() (any, error) {
-		if c.DialTimeout > 0 {
-			return net.DialTimeout(c.Network, c.Address, c.DialTimeout)
-		} else {
-			return net.Dial(c.Network, c.Address)
-		}
+		return c.dial()
 	}); err != nil {
 		origErr = err
 	} else {
@@ -300,6 +441,33 @@ func (c *Client) Reconnect() error {
 		return gerr.ErrClientConnectionFailed.Wrap(origErr)
 	}
 
+	// Upgrade the connection to TLS before any data is sent to the server.
+	if err := c.upgradeToTLS(); err != nil {
+		c.logger.Error().Err(err).Msg("Failed to upgrade the connection to TLS")
+		span.RecordError(err)
+		if err := c.conn.Close(); err != nil {
+			c.logger.Error().Err(err).Msg("Failed to close connection")
+		}
+		c.conn = nil
+		return gerr.ErrClientConnectionFailed.Wrap(err)
+	}
+	c.framer = NewFramer(c.conn, c.ReceiveChunkSize, false)
+
+	c.backendKey = nil
+	c.statements.reset()
+	c.deadlineMu.Lock()
+	c.expected = 0
+	c.deadlineMu.Unlock()
+	if err := c.authenticate(); err != nil {
+		c.logger.Error().Err(err).Msg("Failed to authenticate with the server")
+		span.RecordError(err)
+		if err := c.conn.Close(); err != nil {
+			c.logger.Error().Err(err).Msg("Failed to close connection")
+		}
+		c.conn = nil
+		return err
+	}
+
 	c.ID = GetID(
 		c.conn.LocalAddr().Network(),
 		c.conn.LocalAddr().String(),
@@ -307,6 +475,7 @@ func (c *Client) Reconnect() error {
 		c.logger,
 	)
 	c.connected.Store(true)
+	c.connectedAt = time.Now()
 	c.logger.Debug().Str("address", c.Address).Msg("Reconnected to server")
 	metrics.ServerConnections.Inc()
 	span.AddEvent("Reconnected to server")
@@ -314,6 +483,335 @@ func (c *Client) Reconnect() error {
 	return nil
 }
 
+// Probe checks that the server has not closed the idle connection, without sending anything.
+// The server doesn't send anything on an idle connection, unless it is closing it, for example
+// after the authentication timeout of a connection that is not authenticated.
+func (c *Client) Probe() *gerr.GatewayDError {
+	_, span := otel.Tracer(config.TracerName).Start(c.ctx, "Probe")
+	defer span.End()
+
+	if !c.IsConnected() || c.framer == nil {
+		span.RecordError(gerr.ErrClientNotConnected)
+		return gerr.ErrClientNotConnected
+	}
+
+	if c.framer.Buffered() > 0 {
+		return gerr.ErrHealthCheckFailed.Wrap(errors.New("the server sent data on the idle connection"))
+	}
+
+	if err := c.conn.SetReadDeadline(time.Now().Add(TCPProbeTimeout)); err != nil {
+		return gerr.ErrHealthCheckFailed.Wrap(err)
+	}
+	defer func() {
+		if err := c.conn.SetReadDeadline(time.Time{}); err != nil {
+			c.logger.Error().Err(err).Msg("Failed to reset the read deadline")
+		}
+	}()
+
+	// The read only succeeds if the server sent data or closed the connection.
+	var netErr net.Error
+	if _, err := c.conn.Read(make([]byte, 1)); err == nil {
+		return gerr.ErrHealthCheckFailed.Wrap(errors.New("the server sent data on the idle connection"))
+	} else if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return gerr.ErrHealthCheckFailed.Wrap(err)
+	}
+
+	return nil
+}
+
+// Ping runs the query on the authenticated connection and waits for the ReadyForQuery
+// message of the server, until the timeout. An empty query is answered by the server
+// without running anything.
+func (c *Client) Ping(query string, timeout time.Duration) *gerr.GatewayDError {
+	_, span := otel.Tracer(config.TracerName).Start(c.ctx, "Ping")
+	defer span.End()
+
+	if !c.IsConnected() || c.framer == nil {
+		span.RecordError(gerr.ErrClientNotConnected)
+		return gerr.ErrClientNotConnected
+	}
+
+	if err := c.runQueries(timeout, query); err != nil {
+		span.RecordError(err)
+		return gerr.ErrHealthCheckFailed.Wrap(err)
+	}
+	return nil
+}
+
+// Reset runs the queries on the authenticated connection of a session that is leaving, so
+// that the connection can be used by another session, and waits for their ReadyForQuery
+// messages, until the timeout. The Receive of the session, which waits for the server
+// on the idle connection, is interrupted first.
+func (c *Client) Reset(timeout time.Duration, queries ...string) *gerr.GatewayDError {
+	_, span := otel.Tracer(config.TracerName).Start(c.ctx, "Reset")
+	defer span.End()
+
+	if !c.IsConnected() || c.framer == nil {
+		span.RecordError(gerr.ErrClientNotConnected)
+		return gerr.ErrClientNotConnected
+	}
+
+	c.interrupted.Store(true)
+	if err := c.conn.SetReadDeadline(time.Now()); err != nil {
+		c.interrupted.Store(false)
+		span.RecordError(err)
+		return gerr.ErrResetFailed.Wrap(err)
+	}
+	c.receiving.Lock()
+	c.interrupted.Store(false)
+	c.receiving.Unlock()
+
+	// The queries might deallocate the statements, like DISCARD ALL does.
+	c.statements.reset()
+	c.deadlineMu.Lock()
+	c.expected = 0
+	c.deadlineMu.Unlock()
+
+	if c.framer.Buffered() > 0 {
+		err := gerr.ErrResetFailed.Wrap(errors.New("the server sent data on the idle connection"))
+		span.RecordError(err)
+		return err
+	}
+
+	if err := c.runQueries(timeout, queries...); err != nil {
+		span.RecordError(err)
+		return gerr.ErrResetFailed.Wrap(err)
+	}
+	return nil
+}
+
+// authenticatesItself returns true if the client authenticates with the credentials of the
+// client config, instead of the StartupMessage of the first session, so any session can use it.
+func (c *Client) authenticatesItself() bool {
+	return c.User != ""
+}
+
+// QueryRow runs the query with the text parameters on the authenticated connection, until
+// the timeout, and returns the values of its first row, or nil if it returned no rows.
+func (c *Client) QueryRow(
+	timeout time.Duration, query string, params ...string,
+) ([][]byte, *gerr.GatewayDError) {
+	_, span := otel.Tracer(config.TracerName).Start(c.ctx, "QueryRow")
+	defer span.End()
+
+	if !c.IsConnected() || c.framer == nil {
+		span.RecordError(gerr.ErrClientNotConnected)
+		return nil, gerr.ErrClientNotConnected
+	}
+
+	var row [][]byte
+	err := c.runRequest(timeout, ParameterizedQuery(query, params...), 1, func(msgType byte, body []byte) {
+		if msgType == PostgresDataRow && row == nil {
+			row = DataRowValues(body)
+		}
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, gerr.ErrClientReceiveFailed.Wrap(err)
+	}
+	return row, nil
+}
+
+// runQueries sends the queries to the server at once, and waits for the ReadyForQuery
+// message of each of them, until the timeout. It returns the first error of the server.
+func (c *Client) runQueries(timeout time.Duration, queries ...string) error {
+	request := make([]byte, 0)
+	for _, query := range queries {
+		request = append(request, QueryMessage(query)...)
+	}
+	return c.runRequest(timeout, request, len(queries), nil)
+}
+
+// runRequest sends the request to the server, and waits for the given number of ReadyForQuery
+// messages, until the timeout. The messages of the response are passed to the callback, if any.
+// It returns the first error of the server.
+func (c *Client) runRequest(
+	timeout time.Duration, request []byte, count int, callback func(msgType byte, body []byte),
+) error {
+	deadline := time.Time{}
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return err //nolint:wrapcheck
+	}
+	if timeout > 0 {
+		defer func() {
+			if err := c.conn.SetDeadline(time.Time{}); err != nil {
+				c.logger.Error().Err(err).Msg("Failed to reset the deadline")
+			}
+		}()
+	}
+
+	if _, err := c.send(request); err != nil {
+		return err
+	}
+
+	var queryErr error
+	for pending := count; pending > 0; {
+		// The deadline of the request applies instead of the receive timeout.
+		_, response, err := c.receive(0)
+		if err != nil {
+			return err
+		}
+
+		ForEachMessage(response, func(msgType byte, body []byte) bool {
+			if msgType == PostgresErrorResponse && queryErr == nil {
+				queryErr = errors.New(ErrorResponseMessage(body))
+			}
+			if callback != nil {
+				callback(msgType, body)
+			}
+			return true
+		})
+
+		count, _ := ReadyForQueryStatus(response)
+		pending -= count
+	}
+
+	return queryErr
+}
+
+// Age returns how long ago the connection to the server was opened.
+func (c *Client) Age() time.Duration {
+	return time.Since(c.connectedAt)
+}
+
+// IsAddressHealthy returns false if the client has multiple addresses and the
+// address that it is connected to is in its cooldown.
+func (c *Client) IsAddressHealthy() bool {
+	return c.addresses == nil || c.addresses.IsHealthy(c.Address)
+}
+
+// dial opens a connection to the server. A client with multiple addresses tries them in
+// the order of the address set, and connects to the first one that accepts the connection.
+// The addresses that refuse the connection are marked as dead, so that they are skipped
+// by the other clients until their cooldown is over.
+func (c *Client) dial() (net.Conn, error) {
+	if c.addresses == nil {
+		return c.dialAddress(c.Address)
+	}
+
+	var errs []error
+	for _, address := range c.addresses.Addresses() {
+		conn, err := c.dialAddress(address)
+		if err != nil {
+			if c.addresses.MarkDead(address) {
+				c.logger.Warn().Err(err).Fields(
+					map[string]interface{}{
+						"address":  address,
+						"cooldown": c.addresses.Cooldown.String(),
+					},
+				).Msg("Database server is unreachable, skipping it until the cooldown is over")
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		if c.addresses.MarkAlive(address) {
+			c.logger.Info().Str("address", address).Msg("Database server is reachable again")
+		}
+		c.Address = address
+		return conn, nil
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// dialAddress opens a connection to the given address, and sends the PROXY header first
+// if the database server expects one.
+func (c *Client) dialAddress(address string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if c.DialTimeout > 0 {
+		conn, err = net.DialTimeout(c.Network, address, c.DialTimeout)
+	} else {
+		conn, err = net.Dial(c.Network, address)
+	}
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	var source, destination net.Addr
+	if c.proxySource != nil {
+		source, destination = c.proxySource.RemoteAddr(), c.proxySource.LocalAddr()
+	}
+	if header := ProxyHeader(c.ProxyProtocol, source, destination); header != nil {
+		if _, err := conn.Write(header); err != nil {
+			conn.Close()
+			return nil, err //nolint:wrapcheck
+		}
+	}
+	return conn, nil
+}
+
+// upgradeToTLS sends a SSLRequest to the server and upgrades the connection to TLS
+// if the server accepts it. If the server declines it, the connection stays plaintext
+// only if SSLMode is prefer.
+// See https://www.postgresql.org/docs/current/protocol-flow.html#PROTOCOL-FLOW-SSL
+func (c *Client) upgradeToTLS() *gerr.GatewayDError {
+	if c.SSLMode == config.SSLModeDisable || c.tlsConfig == nil {
+		return nil
+	}
+
+	request := make([]byte, 2*PostgresLengthSize)
+	binary.BigEndian.PutUint32(request[0:PostgresLengthSize], uint32(len(request)))
+	binary.BigEndian.PutUint32(request[PostgresLengthSize:], PostgresSSLRequest)
+	if _, err := c.conn.Write(request); err != nil {
+		return gerr.ErrUpgradeToTLSFailed.Wrap(err)
+	}
+
+	// The server answers with a single byte, before any TLS handshake.
+	response := make([]byte, 1)
+	if _, err := io.ReadFull(c.conn, response); err != nil {
+		return gerr.ErrUpgradeToTLSFailed.Wrap(err)
+	}
+
+	switch response[0] {
+	case 'S':
+	case 'N':
+		if c.SSLMode == config.SSLModePrefer {
+			c.logger.Warn().Str("address", c.Address).Msg(
+				"Server does not support SSL, falling back to a plaintext connection")
+			return nil
+		}
+		return gerr.ErrServerTLSNotSupported
+	default:
+		return gerr.ErrUpgradeToTLSFailed.Wrap(
+			fmt.Errorf("unexpected response to the SSL request: %q", response[0]))
+	}
+
+	tlsConfig := c.tlsConfig
+	if c.addresses != nil && c.sslServerName == "" {
+		// Verify the host name of the address that the client is connected to.
+		if host, _, err := net.SplitHostPort(c.Address); err == nil {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = host
+		}
+	}
+	tlsConn := tls.Client(c.conn, tlsConfig)
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		config.If(c.DialTimeout > 0, c.DialTimeout, config.DefaultHandshakeTimeout),
+	)
+	defer cancel()
+
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return gerr.ErrUpgradeToTLSFailed.Wrap(err)
+	}
+	c.conn = tlsConn
+
+	c.logger.Debug().Fields(
+		map[string]interface{}{
+			"address": c.Address,
+			"sslMode": c.SSLMode,
+		},
+	).Msg("Upgraded the connection to TLS")
+
+	return nil
+}
+
 // Close closes the connection to the server.
 func (c *Client) Close() {
 	_, span := otel.Tracer(config.TracerName).Start(c.ctx, "Close")
@@ -2,10 +2,15 @@ package network
 
 import (
 	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
 	"github.com/gatewayd-io/gatewayd/logging"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
@@ -129,6 +134,111 @@ func TestReconnect(t *testing.T) {
 	assert.NotEqual(t, localAddr, client.LocalAddr()) // This is a new connection.
 }
 
+// startSSLServer starts a server that answers the SSLRequest of the client with the
+// given reply and performs the TLS handshake if the reply is 'S'.
+func startSSLServer(t *testing.T, reply byte) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		request := make([]byte, 8)
+		if _, err := io.ReadFull(conn, request); err != nil || !IsPostgresSSLRequest(request) {
+			return
+		}
+		if _, err := conn.Write([]byte{reply}); err != nil || reply != 'S' {
+			return
+		}
+
+		tlsConfig, err := CreateTLSConfig(
+			"../cmd/testdata/localhost.crt", "../cmd/testdata/localhost.key")
+		if err != nil {
+			return
+		}
+		tlsConn := tls.Server(conn, tlsConfig)
+		t.Cleanup(func() { tlsConn.Close() })
+		_ = tlsConn.Handshake()
+	}()
+
+	return listener.Addr().String()
+}
+
+// TestNewClientWithTLS tests that the client upgrades the connection to TLS
+// if the server accepts the SSLRequest.
+func TestNewClientWithTLS(t *testing.T) {
+	client := NewClient(
+		context.Background(),
+		&config.Client{
+			Network:          "tcp",
+			Address:          startSSLServer(t, 'S'),
+			ReceiveChunkSize: config.DefaultChunkSize,
+			DialTimeout:      config.DefaultDialTimeout,
+			SSLMode:          string(config.SSLModeRequire),
+		},
+		zerolog.Nop(),
+		nil)
+	require.NotNil(t, client)
+	defer client.Close()
+
+	assert.Equal(t, config.SSLModeRequire, client.SSLMode)
+	assert.IsType(t, &tls.Conn{}, client.conn)
+}
+
+// TestNewClientWithTLSDeclined tests that the client falls back to a plaintext
+// connection only if the SSL mode is prefer, when the server declines the SSLRequest.
+func TestNewClientWithTLSDeclined(t *testing.T) {
+	clientConfig := &config.Client{
+		Network:          "tcp",
+		Address:          startSSLServer(t, 'N'),
+		ReceiveChunkSize: config.DefaultChunkSize,
+		DialTimeout:      config.DefaultDialTimeout,
+		SSLMode:          string(config.SSLModeRequire),
+	}
+	assert.Nil(t, NewClient(context.Background(), clientConfig, zerolog.Nop(), nil))
+
+	clientConfig.Address = startSSLServer(t, 'N')
+	clientConfig.SSLMode = string(config.SSLModePrefer)
+	client := NewClient(context.Background(), clientConfig, zerolog.Nop(), nil)
+	require.NotNil(t, client)
+	defer client.Close()
+
+	assert.IsType(t, &net.TCPConn{}, client.conn)
+}
+
+// TestCreateClientTLSConfig tests the CreateClientTLSConfig function.
+func TestCreateClientTLSConfig(t *testing.T) {
+	tlsConfig, err := CreateClientTLSConfig(&config.Client{Address: "localhost:5432"})
+	assert.NoError(t, err)
+	assert.Nil(t, tlsConfig)
+
+	tlsConfig, err = CreateClientTLSConfig(&config.Client{
+		Address:         "localhost:5432",
+		SSLMode:         string(config.SSLModeVerifyFull),
+		SSLRootCertFile: "../cmd/testdata/localhost.crt",
+		SSLCertFile:     "../cmd/testdata/localhost.crt",
+		SSLKeyFile:      "../cmd/testdata/localhost.key",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "localhost", tlsConfig.ServerName)
+	assert.NotNil(t, tlsConfig.RootCAs)
+	assert.Len(t, tlsConfig.Certificates, 1)
+	assert.False(t, tlsConfig.InsecureSkipVerify)
+
+	_, err = CreateClientTLSConfig(&config.Client{
+		SSLMode:         string(config.SSLModeVerifyCA),
+		SSLRootCertFile: "../cmd/testdata/missing.crt",
+	})
+	assert.Error(t, err)
+}
+
 func BenchmarkNewClient(b *testing.B) {
 	cfg := logging.LoggerConfig{
 		Output:            []config.LogOutput{config.Console},
@@ -244,3 +354,199 @@ func BenchmarkIsConnected(b *testing.B) {
 		client.IsConnected()
 	}
 }
+
+// startFakeServer starts a server that handles each connection with the handler.
+func startFakeServer(t testing.TB, handler func(conn net.Conn)) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			go handler(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// newFakeServerClient creates a client that is connected to the fake server.
+func newFakeServerClient(t testing.TB, address string) *Client {
+	t.Helper()
+
+	client := NewClient(
+		context.Background(),
+		&config.Client{
+			Network:          "tcp",
+			Address:          address,
+			ReceiveChunkSize: config.DefaultChunkSize,
+			DialTimeout:      time.Second,
+		},
+		zerolog.Nop(),
+		nil)
+	require.NotNil(t, client)
+	t.Cleanup(client.Close)
+	return client
+}
+
+// TestClientProbe tests that the probe detects the connections that are closed by the server.
+func TestClientProbe(t *testing.T) {
+	idle := newFakeServerClient(t, startFakeServer(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	}))
+	assert.Nil(t, idle.Probe())
+	assert.Nil(t, idle.Probe())
+
+	closed := newFakeServerClient(t, startFakeServer(t, func(conn net.Conn) {
+		conn.Close()
+	}))
+	time.Sleep(100 * time.Millisecond)
+	err := closed.Probe()
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodeHealthCheckFailed, err.Code)
+}
+
+// TestClientPing tests the health check query of the authenticated connections.
+func TestClientPing(t *testing.T) {
+	reply := func(response []byte) func(conn net.Conn) {
+		return func(conn net.Conn) {
+			framer := NewFramer(conn, config.DefaultChunkSize, false)
+			for {
+				if _, err := framer.ReadMessage(); err != nil {
+					return
+				}
+				if _, err := conn.Write(response); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	healthy := newFakeServerClient(t, startFakeServer(t, reply(append(
+		CreatePostgreSQLPacket('I', nil), ReadyForQuery(PostgresTxIdle)...))))
+	assert.Nil(t, healthy.Ping("", time.Second))
+
+	failing := newFakeServerClient(t, startFakeServer(t, reply(append(
+		ErrorResponse(PostgresSeverityError, "57P03", "the database system is starting up"),
+		ReadyForQuery(PostgresTxIdle)...))))
+	err := failing.Ping("SELECT 1", time.Second)
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodeHealthCheckFailed, err.Code)
+	assert.Contains(t, err.Error(), "the database system is starting up")
+
+	silent := newFakeServerClient(t, startFakeServer(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	}))
+	err = silent.Ping("", 100*time.Millisecond)
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodeHealthCheckFailed, err.Code)
+}
+
+// TestClientReceiveTimeout tests that the receive fails if the server doesn't answer within
+// the receive timeout, that the timeout applies to each receive, and that it doesn't apply
+// while the connection is idle.
+func TestClientReceiveTimeout(t *testing.T) {
+	client := newFakeServerClient(t, startFakeServer(t, func(conn net.Conn) {
+		framer := NewFramer(conn, config.DefaultChunkSize, false)
+		for {
+			message, err := framer.ReadMessage()
+			if err != nil {
+				return
+			}
+			// The server doesn't answer the SLEEP query in time.
+			if string(message[5:len(message)-1]) == "SLEEP" {
+				time.Sleep(300 * time.Millisecond)
+			}
+			if _, err := conn.Write(ReadyForQuery(PostgresTxIdle)); err != nil {
+				return
+			}
+		}
+	}))
+	client.ReceiveTimeout = 200 * time.Millisecond
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Send(QueryMessage(""))
+		require.Nil(t, err)
+		time.Sleep(100 * time.Millisecond)
+		_, response, err := client.Receive()
+		require.Nil(t, err)
+		assert.Equal(t, ReadyForQuery(PostgresTxIdle), response)
+	}
+
+	// The receive waits on the idle connection until the next request is answered.
+	received := make(chan []byte)
+	go func() {
+		_, response, err := client.Receive()
+		assert.Nil(t, err)
+		received <- response
+	}()
+	select {
+	case <-received:
+		t.Fatal("the receive returned on the idle connection")
+	case <-time.After(300 * time.Millisecond):
+	}
+	_, err := client.Send(QueryMessage(""))
+	require.Nil(t, err)
+	assert.Equal(t, ReadyForQuery(PostgresTxIdle), <-received)
+
+	_, err = client.Send(QueryMessage("SLEEP"))
+	require.Nil(t, err)
+	_, _, err = client.Receive()
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodeClientReceiveFailed, err.Code)
+}
+
+// TestClientReset tests that the reset interrupts the Receive of the session that left,
+// and runs the queries on the same connection.
+func TestClientReset(t *testing.T) {
+	var queries []string
+	var mu sync.Mutex
+	address := startFakeServer(t, func(conn net.Conn) {
+		framer := NewFramer(conn, config.DefaultChunkSize, false)
+		for {
+			message, err := framer.ReadMessage()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			queries = append(queries, string(message[5:len(message)-1]))
+			mu.Unlock()
+
+			response := CreatePostgreSQLPacket('C', []byte("DISCARD ALL\x00"))
+			if string(message[5:len(message)-1]) == "FAIL" {
+				response = ErrorResponse(PostgresSeverityError, "42601", "syntax error")
+			}
+			if _, err := conn.Write(append(response, ReadyForQuery(PostgresTxIdle)...)); err != nil {
+				return
+			}
+		}
+	})
+	client := newFakeServerClient(t, address)
+
+	// The session that left is still waiting for the server.
+	received := make(chan *gerr.GatewayDError)
+	go func() {
+		_, _, err := client.Receive()
+		received <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	require.Nil(t, client.Reset(time.Second, "ROLLBACK", "DISCARD ALL"))
+	assert.NotNil(t, <-received)
+	mu.Lock()
+	assert.Equal(t, []string{"ROLLBACK", "DISCARD ALL"}, queries)
+	mu.Unlock()
+	assert.True(t, client.IsConnected())
+
+	err := client.Reset(time.Second, "FAIL")
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodeResetFailed, err.Code)
+	assert.Contains(t, err.Error(), "syntax error")
+}
@@ -4,10 +4,18 @@ package network
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"net"
+	"os"
 	"time"
 
+	"github.com/gatewayd-io/gatewayd/config"
 	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/gatewayd-io/gatewayd/metrics"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
 )
 
 // UpgraderFunc is a function that upgrades a connection to TLS.
@@ -22,11 +30,13 @@ type IConnWrapper interface {
 	Conn() net.Conn
 	UpgradeToTLS(upgrader UpgraderFunc) *gerr.GatewayDError
 	Close() error
+	CloseRead() error
 	Write(data []byte) (int, error)
 	Read(data []byte) (int, error)
 	RemoteAddr() net.Addr
 	LocalAddr() net.Addr
 	IsTLSEnabled() bool
+	Framer() *Framer
 }
 
 type ConnWrapper struct {
@@ -35,6 +45,7 @@ type ConnWrapper struct {
 	tlsConfig        *tls.Config
 	isTLSEnabled     bool
 	handshakeTimeout time.Duration
+	framer           *Framer
 }
 
 var _ IConnWrapper = (*ConnWrapper)(nil)
@@ -82,6 +93,15 @@ func (cw *ConnWrapper) Close() error {
 	return cw.netConn.Close()
 }
 
+// CloseRead shuts down the reading side of the connection, so that the reads return io.EOF
+// while the writes still succeed. If it can't be shut down, the reads time out instead.
+func (cw *ConnWrapper) CloseRead() error {
+	if closer, ok := cw.netConn.(interface{ CloseRead() error }); ok {
+		return closer.CloseRead() //nolint:wrapcheck
+	}
+	return cw.netConn.SetReadDeadline(time.Now()) //nolint:wrapcheck
+}
+
 // Write writes data to the connection.
 func (cw *ConnWrapper) Write(data []byte) (int, error) {
 	if cw.tlsConn != nil {
@@ -119,17 +139,119 @@ func (cw *ConnWrapper) IsTLSEnabled() bool {
 	return cw.tlsConn != nil || cw.isTLSEnabled
 }
 
+// Framer returns the framer that reads whole messages from the connection.
+// The framer reads through the wrapper, so it keeps working after the
+// connection is upgraded to TLS.
+func (cw *ConnWrapper) Framer() *Framer {
+	return cw.framer
+}
+
 // NewConnWrapper creates a new connection wrapper. The connection
 // wrapper is used to upgrade the connection to TLS if need be.
 func NewConnWrapper(
 	conn net.Conn, tlsConfig *tls.Config, handshakeTimeout time.Duration,
 ) *ConnWrapper {
-	return &ConnWrapper{
+	connWrapper := &ConnWrapper{
 		netConn:          conn,
 		tlsConfig:        tlsConfig,
 		isTLSEnabled:     tlsConfig != nil && tlsConfig.Certificates != nil,
 		handshakeTimeout: handshakeTimeout,
 	}
+	// The client starts the session with untyped messages.
+	connWrapper.framer = NewFramer(connWrapper, config.DefaultChunkSize, true)
+	return connWrapper
+}
+
+// negotiateEncryption answers the SSLRequest and GSSENCRequest of the client. The connection
+// is upgraded to TLS if TLS is enabled, otherwise the request is declined. It returns false if
+// the request is neither, so that it is passed through to the server.
+func negotiateEncryption(
+	ctx context.Context, conn *ConnWrapper, request []byte, logger zerolog.Logger,
+) bool {
+	_, span := otel.Tracer(config.TracerName).Start(ctx, "negotiateEncryption")
+	defer span.End()
+
+	// Check if the client sent a SSL request and the server supports SSL.
+	//nolint:nestif
+	if conn.IsTLSEnabled() && IsPostgresSSLRequest(request) {
+		// Perform TLS handshake.
+		if err := conn.UpgradeToTLS(func(c net.Conn) {
+			// Acknowledge the SSL request:
+			// https://www.postgresql.org/docs/current/protocol-flow.html#PROTOCOL-FLOW-SSL
+			if sent, err := conn.Write([]byte{'S'}); err != nil {
+				logger.Error().Err(err).Msg("Failed to acknowledge the SSL request")
+				span.RecordError(err)
+			} else {
+				logger.Debug().Fields(
+					map[string]interface{}{
+						"function": "upgradeToTLS",
+						"local":    LocalAddr(conn.Conn()),
+						"remote":   RemoteAddr(conn.Conn()),
+						"length":   sent,
+					},
+				).Msg("Sent data to database")
+			}
+		}); err != nil {
+			logger.Error().Err(err).Msg("Failed to perform the TLS handshake")
+			span.RecordError(err)
+		}
+
+		// Check if the TLS handshake was successful.
+		if conn.IsTLSEnabled() {
+			logger.Debug().Fields(
+				map[string]interface{}{
+					"local":  LocalAddr(conn.Conn()),
+					"remote": RemoteAddr(conn.Conn()),
+				},
+			).Msg("Performed the TLS handshake")
+			span.AddEvent("Performed the TLS handshake")
+			metrics.TLSConnections.Inc()
+		} else {
+			logger.Error().Fields(
+				map[string]interface{}{
+					"local":  LocalAddr(conn.Conn()),
+					"remote": RemoteAddr(conn.Conn()),
+				},
+			).Msg("Failed to perform the TLS handshake")
+			span.AddEvent("Failed to perform the TLS handshake")
+		}
+
+		return true
+	} else if !conn.IsTLSEnabled() && IsPostgresSSLRequest(request) {
+		// Client sent a SSL request, but the server does not support SSL.
+
+		logger.Warn().Fields(
+			map[string]interface{}{
+				"local":  LocalAddr(conn.Conn()),
+				"remote": RemoteAddr(conn.Conn()),
+			},
+		).Msg("Server does not support SSL, but SSL was requested by the client")
+		span.AddEvent("Server does not support SSL, but SSL was requested by the client")
+
+		// Server does not support SSL, and SSL was preferred by the client,
+		// so we need to switch to a plaintext connection:
+		// https://www.postgresql.org/docs/current/protocol-flow.html#PROTOCOL-FLOW-SSL
+		if _, err := conn.Write([]byte{'N'}); err != nil {
+			logger.Warn().Err(err).Msg("Server does not support SSL, but SSL was required by the client")
+			span.RecordError(err)
+		}
+
+		return true
+	} else if IsPostgresGSSEncRequest(request) {
+		// GSSAPI encryption is not supported, so the request is declined instead of
+		// being forwarded to the database, whose single-byte reply can't be framed:
+		// https://www.postgresql.org/docs/current/protocol-flow.html#PROTOCOL-FLOW-GSSAPI
+		if _, err := conn.Write([]byte{'N'}); err != nil {
+			logger.Warn().Err(err).Msg("Failed to decline the GSSENC request")
+			span.RecordError(err)
+		}
+
+		// The client continues with a SSLRequest or a
+		// StartupMessage over the plaintext connection.
+		return true
+	}
+
+	return false
 }
 
 // CreateTLSConfig returns a TLS config from the given cert and key.
@@ -147,3 +269,68 @@ func CreateTLSConfig(certFile, keyFile string) (*tls.Config, error) {
 		PreferServerCipherSuites: true,
 	}, nil
 }
+
+// CreateClientTLSConfig returns the TLS config of the connections to the database
+// server from the client config. It returns nil if the SSL mode is disable.
+func CreateClientTLSConfig(clientConfig *config.Client) (*tls.Config, error) {
+	sslMode := clientConfig.GetSSLMode()
+	if sslMode == config.SSLModeDisable {
+		return nil, nil //nolint:nilnil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: clientConfig.SSLServerName,
+	}
+	if tlsConfig.ServerName == "" {
+		if host, _, err := net.SplitHostPort(clientConfig.Address); err == nil {
+			tlsConfig.ServerName = host
+		}
+	}
+
+	// Use the system CAs if no CA certificate is given.
+	if clientConfig.SSLRootCertFile != "" {
+		pem, err := os.ReadFile(clientConfig.SSLRootCertFile)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", clientConfig.SSLRootCertFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if clientConfig.SSLCertFile != "" || clientConfig.SSLKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(clientConfig.SSLCertFile, clientConfig.SSLKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	switch sslMode {
+	case config.SSLModePrefer, config.SSLModeRequire:
+		tlsConfig.InsecureSkipVerify = true //nolint:gosec
+	case config.SSLModeVerifyCA:
+		// Verify the certificate chain, but not the host name.
+		tlsConfig.InsecureSkipVerify = true //nolint:gosec
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("the server did not send a certificate")
+			}
+			options := x509.VerifyOptions{
+				Roots:         tlsConfig.RootCAs,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range state.PeerCertificates[1:] {
+				options.Intermediates.AddCert(cert)
+			}
+			_, err := state.PeerCertificates[0].Verify(options)
+			return err
+		}
+	case config.SSLModeDisable, config.SSLModeVerifyFull:
+	}
+
+	return tlsConfig, nil
+}
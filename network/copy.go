@@ -0,0 +1,119 @@
+package network
+
+import (
+	"bytes"
+
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+	"github.com/gatewayd-io/gatewayd/plugin"
+)
+
+// Directions of a COPY, from the point of view of the server.
+const (
+	CopyIn   = "in"
+	CopyOut  = "out"
+	CopyBoth = "both"
+)
+
+// copyEvent is the start or the end of a COPY, which is sent to the OnCopyStart
+// and OnCopyEnd hooks.
+type copyEvent struct {
+	hookName  v1.HookName
+	request   []byte
+	direction string
+	// bytes is the size of the CopyData messages sent in both directions.
+	bytes int
+	// tag is the tag of the CommandComplete message, like "COPY 10", and err
+	// is the message of the ErrorResponse that ends the COPY.
+	tag string
+	err string
+}
+
+// trackCopyRequest counts the CopyData messages that the client sends during a COPY.
+func (s *Session) trackCopyRequest(request []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.copyDirection == "" {
+		return
+	}
+	ForEachMessage(request, func(msgType byte, body []byte) bool {
+		if msgType == PostgresCopyData {
+			s.copyBytes += len(body)
+		}
+		return true
+	})
+}
+
+// trackCopyResponse starts a COPY on a CopyInResponse, CopyOutResponse or CopyBothResponse
+// message, and ends it on the CommandComplete or ErrorResponse message that follows the
+// data. It returns the start and the end of the COPY that are in the response, if any.
+func (s *Session) trackCopyResponse(exchange Exchange) []copyEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []copyEvent
+	ForEachMessage(exchange.Response, func(msgType byte, body []byte) bool {
+		switch msgType {
+		case PostgresCopyInResponse, PostgresCopyOutResponse, PostgresCopyBothResponse:
+			s.copyDirection = map[byte]string{
+				PostgresCopyInResponse:   CopyIn,
+				PostgresCopyOutResponse:  CopyOut,
+				PostgresCopyBothResponse: CopyBoth,
+			}[msgType]
+			s.copyBytes, s.copyBatches = 0, 0
+			events = append(events, copyEvent{
+				hookName:  plugin.OnCopyStart,
+				request:   exchange.Request,
+				direction: s.copyDirection,
+			})
+		case PostgresCopyData:
+			s.copyBytes += len(body)
+		case PostgresCommandComplete, PostgresErrorResponse:
+			if s.copyDirection == "" {
+				break
+			}
+			event := copyEvent{
+				hookName:  plugin.OnCopyEnd,
+				request:   exchange.Request,
+				direction: s.copyDirection,
+				bytes:     s.copyBytes,
+			}
+			if msgType == PostgresCommandComplete {
+				event.tag = string(bytes.TrimRight(body, "\x00"))
+			} else {
+				event.err = ErrorResponseMessage(body)
+			}
+			events = append(events, event)
+			s.copyDirection = ""
+		}
+		return true
+	})
+	return events
+}
+
+// skipCopyHooks returns true if the traffic hooks are skipped for the data, because it
+// only has COPY data. Every Nth batch of COPY data is sampled if sampling is set to N.
+func (s *Session) skipCopyHooks(data []byte, sampling int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.copyDirection == "" || len(data) == 0 {
+		return false
+	}
+
+	copyData := true
+	ForEachMessage(data, func(msgType byte, _ []byte) bool {
+		switch msgType {
+		case PostgresCopyData, PostgresCopyDone, PostgresCopyFail:
+			return true
+		}
+		copyData = false
+		return false
+	})
+	if !copyData {
+		return false
+	}
+
+	s.copyBatches++
+	return sampling <= 0 || s.copyBatches%sampling != 0
+}
@@ -0,0 +1,70 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/gatewayd-io/gatewayd/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSessionCopyIn tests that a COPY FROM STDIN is tracked from the CopyInResponse to the
+// CommandComplete, and the traffic hooks are skipped for the COPY data, unless it is sampled.
+func TestSessionCopyIn(t *testing.T) {
+	query := QueryMessage("COPY t FROM STDIN")
+	copyData := CreatePostgreSQLPacket(PostgresCopyData, []byte("1\n"))
+	copyDone := CreatePostgreSQLPacket(PostgresCopyDone, nil)
+
+	session := NewSession()
+	assert.False(t, session.skipCopyHooks(copyData, 0))
+
+	events := session.trackCopyResponse(Exchange{
+		Request:  query,
+		Response: CreatePostgreSQLPacket(PostgresCopyInResponse, []byte("\x00\x00\x00")),
+	})
+	require.Len(t, events, 1)
+	assert.Equal(t, plugin.OnCopyStart, events[0].hookName)
+	assert.Equal(t, CopyIn, events[0].direction)
+	assert.Equal(t, query, events[0].request)
+
+	// Every second batch of COPY data is sampled.
+	assert.True(t, session.skipCopyHooks(copyData, 2))
+	assert.False(t, session.skipCopyHooks(copyData, 2))
+	assert.True(t, session.skipCopyHooks(concat(copyData, copyDone), 2))
+	assert.False(t, session.skipCopyHooks(query, 2))
+	session.trackCopyRequest(concat(copyData, copyData, copyDone))
+
+	events = session.trackCopyResponse(Exchange{
+		Request: query,
+		Response: concat(
+			CreatePostgreSQLPacket(PostgresCommandComplete, []byte("COPY 2\x00")),
+			ReadyForQuery(PostgresTxIdle)),
+	})
+	require.Len(t, events, 1)
+	assert.Equal(t, plugin.OnCopyEnd, events[0].hookName)
+	assert.Equal(t, "COPY 2", events[0].tag)
+	assert.Equal(t, 2*len("1\n"), events[0].bytes)
+
+	// The hooks run again once the COPY is over.
+	assert.False(t, session.skipCopyHooks(copyData, 0))
+}
+
+// TestSessionCopyOut tests that a COPY TO STDOUT that fails is tracked in a single response.
+func TestSessionCopyOut(t *testing.T) {
+	session := NewSession()
+	events := session.trackCopyResponse(Exchange{
+		Request: QueryMessage("COPY t TO STDOUT"),
+		Response: concat(
+			CreatePostgreSQLPacket(PostgresCopyOutResponse, []byte("\x00\x00\x00")),
+			CreatePostgreSQLPacket(PostgresCopyData, []byte("1\n")),
+			ErrorResponse(PostgresSeverityError, "57014", "canceling statement"),
+			ReadyForQuery(PostgresTxIdle)),
+	})
+	require.Len(t, events, 2)
+	assert.Equal(t, plugin.OnCopyStart, events[0].hookName)
+	assert.Equal(t, plugin.OnCopyEnd, events[1].hookName)
+	assert.Equal(t, CopyOut, events[1].direction)
+	assert.Equal(t, len("1\n"), events[1].bytes)
+	assert.Equal(t, "canceling statement", events[1].err)
+	assert.Empty(t, events[1].tag)
+}
@@ -0,0 +1,129 @@
+package network
+
+import "sync"
+
+// Request is a request of the client that is answered with a ReadyForQuery message, like a
+// Query or the messages of the extended protocol up to a Sync, along with the passwords that
+// the client sends while the request is answered. The COPY data is not kept.
+type Request struct {
+	Data []byte
+	// closed is set once the message that is answered with a ReadyForQuery is received.
+	closed bool
+}
+
+// Exchange is a part of a response of the server, along with the request that it answers.
+// The request is empty if the server sent the response on its own, like a notification.
+type Exchange struct {
+	Request  []byte
+	Response []byte
+}
+
+// Correlator pairs the responses of the server with the requests of the client. The client
+// can send several requests before the server answers them, so the requests are queued, and
+// the server answers each of them in order, with a ReadyForQuery message at the end.
+type Correlator struct {
+	requests []*Request
+	mu       sync.Mutex
+}
+
+// NewCorrelator creates a new correlator.
+func NewCorrelator() *Correlator {
+	return &Correlator{
+		requests: make([]*Request, 0),
+		mu:       sync.Mutex{},
+	}
+}
+
+// Push adds the messages that the client sends to the server to the requests. It must be
+// called before the messages are sent, so that the responses always find their request.
+func (c *Correlator) Push(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if IsPostgresStartupMessage(data) {
+		// The server answers the StartupMessage with a ReadyForQuery once the session starts.
+		c.requests = append(c.requests, &Request{Data: data[:len(data):len(data)], closed: true})
+		return
+	}
+
+	rest := data
+	ForEachMessage(data, func(msgType byte, body []byte) bool {
+		msg := rest[:PostgresMessageHeaderSize+len(body)]
+		rest = rest[len(msg):]
+
+		var last *Request
+		if len(c.requests) > 0 {
+			last = c.requests[len(c.requests)-1]
+		}
+
+		switch msgType {
+		case PostgresCopyData, PostgresCopyDone, PostgresCopyFail:
+			// The COPY data continues the Query that the server is answering.
+			return true
+		case PostgresPassword:
+			// The passwords continue the StartupMessage that the server is answering.
+			if last != nil {
+				last.Data = append(last.Data, msg...)
+				return true
+			}
+		}
+
+		if last == nil || last.closed {
+			last = &Request{}
+			c.requests = append(c.requests, last)
+		}
+		last.Data = append(last.Data, msg...)
+
+		switch msgType {
+		case PostgresQuery, PostgresSync, PostgresFunctionCall:
+			last.closed = true
+		}
+		return true
+	})
+}
+
+// Match splits the response at the ReadyForQuery messages, and pairs each part with the
+// request that it answers. The requests that are completely answered are removed.
+func (c *Correlator) Match(response []byte) []Exchange {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var exchanges []Exchange
+	start, offset := 0, 0
+	ForEachMessage(response, func(msgType byte, body []byte) bool {
+		offset += PostgresMessageHeaderSize + len(body)
+		if msgType != PostgresReadyForQuery {
+			return true
+		}
+
+		exchanges = append(exchanges, Exchange{Request: c.current(), Response: response[start:offset]})
+		if len(c.requests) > 0 {
+			c.requests = c.requests[1:]
+		}
+		start = offset
+		return true
+	})
+
+	// The rest of the response answers the request that is not completely answered yet.
+	if start < len(response) {
+		exchanges = append(exchanges, Exchange{Request: c.current(), Response: response[start:]})
+	}
+	return exchanges
+}
+
+// Clear removes all the requests.
+func (c *Correlator) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requests = make([]*Request, 0)
+}
+
+// current returns the data of the request that the server is answering.
+// It must be called with the lock held.
+func (c *Correlator) current() []byte {
+	if len(c.requests) == 0 {
+		return []byte{}
+	}
+	return c.requests[0].Data
+}
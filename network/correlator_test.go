@@ -0,0 +1,85 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCorrelator tests that the responses are paired with the requests that they answer,
+// when the client pipelines several requests.
+func TestCorrelator(t *testing.T) {
+	query1 := CreatePostgreSQLPacket(PostgresQuery, []byte("SELECT 1\x00"))
+	query2 := CreatePostgreSQLPacket(PostgresQuery, []byte("SELECT 2\x00"))
+	extended := concat(
+		parseMessage("", "SELECT 3"),
+		bindMessage(""),
+		CreatePostgreSQLPacket(PostgresExecute, []byte("\x00\x00\x00\x00\x00")))
+	sync := CreatePostgreSQLPacket(PostgresSync, nil)
+
+	result1 := concat(
+		CreatePostgreSQLPacket(PostgresDataRow, []byte("1")),
+		CreatePostgreSQLPacket('C', []byte("SELECT 1\x00")),
+		ReadyForQuery(PostgresTxIdle))
+	result2 := concat(
+		CreatePostgreSQLPacket(PostgresDataRow, []byte("2")),
+		CreatePostgreSQLPacket('C', []byte("SELECT 1\x00")),
+		ReadyForQuery(PostgresTxIdle))
+	result3 := concat(
+		CreatePostgreSQLPacket(PostgresParseComplete, nil),
+		CreatePostgreSQLPacket('2', nil),
+		CreatePostgreSQLPacket(PostgresDataRow, []byte("3")))
+
+	correlator := NewCorrelator()
+	correlator.Push(concat(query1, query2))
+	// The Sync of the extended protocol comes with the next read.
+	correlator.Push(extended)
+	correlator.Push(sync)
+
+	// The first read has the responses to both queries and a part of the third one.
+	assert.Equal(t, []Exchange{
+		{Request: query1, Response: result1},
+		{Request: query2, Response: result2},
+		{Request: concat(extended, sync), Response: result3},
+	}, correlator.Match(concat(result1, result2, result3)))
+
+	// The end of the response is still paired with the third request.
+	end := concat(CreatePostgreSQLPacket('C', []byte("SELECT 1\x00")), ReadyForQuery(PostgresTxIdle))
+	assert.Equal(t, []Exchange{
+		{Request: concat(extended, sync), Response: end},
+	}, correlator.Match(end))
+
+	// A notification is not paired with any request.
+	notification := CreatePostgreSQLPacket('A', []byte("\x00\x00\x00\x01channel\x00\x00"))
+	assert.Equal(t, []Exchange{
+		{Request: []byte{}, Response: notification},
+	}, correlator.Match(notification))
+}
+
+// TestCorrelatorStartup tests that the passwords are paired with the StartupMessage, and the
+// COPY data is not kept.
+func TestCorrelatorStartup(t *testing.T) {
+	startupMessage := StartupMessage(map[string]string{"user": "postgres"})
+	password := PasswordMessage([]byte("secret\x00"))
+	query := CreatePostgreSQLPacket(PostgresQuery, []byte("COPY t FROM STDIN\x00"))
+
+	correlator := NewCorrelator()
+	correlator.Push(startupMessage)
+	correlator.Push(password)
+
+	authentication := Authentication(PostgresAuthCleartextPassword, nil)
+	assert.Equal(t, []Exchange{
+		{Request: concat(startupMessage, password), Response: authentication},
+	}, correlator.Match(authentication))
+	ready := concat(Authentication(PostgresAuthOk, nil), ReadyForQuery(PostgresTxIdle))
+	assert.Equal(t, []Exchange{
+		{Request: concat(startupMessage, password), Response: ready},
+	}, correlator.Match(ready))
+
+	correlator.Push(query)
+	correlator.Push(concat(
+		CreatePostgreSQLPacket(PostgresCopyData, []byte("1\n")),
+		CreatePostgreSQLPacket(PostgresCopyDone, nil)))
+	done := concat(CreatePostgreSQLPacket('C', []byte("COPY 1\x00")), ReadyForQuery(PostgresTxIdle))
+	assert.Equal(t, []Exchange{{Request: query, Response: done}}, correlator.Match(done))
+}
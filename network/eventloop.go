@@ -0,0 +1,167 @@
+package network
+
+import (
+	"sync"
+	"syscall"
+
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"go.opentelemetry.io/otel"
+)
+
+// eventConn is an incoming connection that is served by the event loop of the server. Its
+// requests are passed through by a goroutine that only runs while the client sends them,
+// and its responses by a goroutine that only runs while a server connection is attached to
+// its session. Between the transactions in transaction pooling mode, the connection is only
+// waited for by the poller, without any goroutine. In session mode, the server connection
+// stays attached, so the goroutine of the responses runs for the whole session.
+type eventConn struct {
+	server     *Server
+	conn       *ConnWrapper
+	proxy      IProxy
+	correlator *Correlator
+	fd         int
+
+	mu         sync.Mutex
+	responding bool
+	closed     bool
+	closeOnce  sync.Once
+}
+
+// pollableFD returns the file descriptor of the incoming connection, if it can be waited for
+// by the poller. The connections upgraded to TLS can't be, since the TLS records that are
+// read from the connection might hold more messages than the ones that are decrypted.
+func pollableFD(conn *ConnWrapper) (int, bool) {
+	if conn.tlsConn != nil {
+		return 0, false
+	}
+
+	sysConn, ok := conn.netConn.(syscall.Conn)
+	if !ok {
+		return 0, false
+	}
+	rawConn, err := sysConn.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+
+	fd := -1
+	if err := rawConn.Control(func(descriptor uintptr) {
+		fd = int(descriptor)
+	}); err != nil || fd < 0 {
+		return 0, false
+	}
+	return fd, true
+}
+
+// serveEventLoop passes the traffic of the connection through the proxy with the event loop.
+// It returns false if the connection can't be waited for by the poller, so that it is served
+// by goroutines instead.
+func (s *Server) serveEventLoop(conn *ConnWrapper) bool {
+	_, span := otel.Tracer("gatewayd").Start(s.ctx, "serveEventLoop")
+	defer span.End()
+
+	if s.poller == nil {
+		return false
+	}
+	fd, ok := pollableFD(conn)
+	if !ok {
+		return false
+	}
+
+	s.runOnTrafficHooks(conn)
+
+	ec := &eventConn{
+		server:     s,
+		conn:       conn,
+		proxy:      s.getProxy(conn),
+		correlator: NewCorrelator(),
+		fd:         fd,
+	}
+	// The StartupMessage is already read, so the requests
+	// are passed through without waiting for the poller.
+	go ec.receive()
+
+	s.logger.Trace().Str("from", RemoteAddr(conn.Conn())).Msg(
+		"Serving the connection with the event loop")
+	span.AddEvent("Serving the connection with the event loop")
+	return true
+}
+
+// receive passes the requests of the client through to the server, as long as the client
+// has sent some, and then leaves the connection to the poller until it sends more.
+func (ec *eventConn) receive() {
+	for {
+		ec.server.logger.Trace().Msg("Passing through traffic from client to server")
+		if err := ec.proxy.PassThroughToServer(ec.conn, ec.correlator); err != nil {
+			ec.server.logger.Trace().Err(err).Msg("Failed to pass through traffic")
+			ec.close(err)
+			return
+		}
+		ec.respond()
+
+		if ec.conn.Framer().Buffered() == 0 {
+			break
+		}
+	}
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	if ec.closed {
+		return
+	}
+	if err := ec.server.poller.wait(ec.fd, ec.receive); err != nil {
+		ec.server.logger.Error().Err(err).Msg("Failed to wait for the connection")
+		go ec.close(gerr.ErrEventLoopFailed.Wrap(err))
+	}
+}
+
+// respond starts the goroutine that passes the responses through to the client, unless it
+// is running. It is called after every request, which might have attached a server connection.
+func (ec *eventConn) respond() {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	if ec.responding || ec.closed {
+		return
+	}
+	ec.responding = true
+	go ec.send()
+}
+
+// send passes the responses of the server through to the client, until no server connection
+// is attached to the session.
+func (ec *eventConn) send() {
+	for {
+		ec.mu.Lock()
+		if ec.proxy.IsDetached(ec.conn) {
+			ec.responding = false
+			ec.mu.Unlock()
+			return
+		}
+		ec.mu.Unlock()
+
+		ec.server.logger.Trace().Msg("Passing through traffic from server to client")
+		if err := ec.proxy.PassThroughToClient(ec.conn, ec.correlator); err != nil {
+			ec.server.logger.Trace().Err(err).Msg("Failed to pass through traffic")
+			ec.close(err)
+			return
+		}
+	}
+}
+
+// close sends the error to the client, and closes the connection once, after either the
+// requests or the responses failed to pass through.
+func (ec *eventConn) close(err *gerr.GatewayDError) {
+	ec.proxy.SendError(ec.conn, err)
+
+	ec.closeOnce.Do(func() {
+		ec.mu.Lock()
+		ec.closed = true
+		ec.mu.Unlock()
+		ec.server.poller.remove(ec.fd)
+		ec.correlator.Clear()
+
+		ec.server.releaseConnection(ec.conn)
+		ec.server.OnClose(ec.conn, nil)
+	})
+}
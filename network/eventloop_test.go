@@ -0,0 +1,83 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPoller tests that the poller calls the callback of a connection once it becomes
+// readable, and only once until the connection is waited for again.
+func TestPoller(t *testing.T) {
+	poller, err := newPoller()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer poller.close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	serverConn, err := listener.Accept()
+	require.NoError(t, err)
+	defer serverConn.Close()
+
+	conn := NewConnWrapper(serverConn, nil, config.DefaultHandshakeTimeout)
+	fd, ok := pollableFD(conn)
+	require.True(t, ok)
+
+	ready := make(chan struct{}, 2)
+	require.NoError(t, poller.wait(fd, func() { ready <- struct{}{} }))
+	select {
+	case <-ready:
+		t.Fatal("the connection is not readable yet")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_, err = client.Write(CreatePgTerminatePacket())
+	require.NoError(t, err)
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatal("the connection is readable")
+	}
+
+	// The data is not read, but the connection is only reported once.
+	select {
+	case <-ready:
+		t.Fatal("the connection is reported again before it is waited for")
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.NoError(t, poller.wait(fd, func() { ready <- struct{}{} }))
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatal("the connection is still readable")
+	}
+
+	// The connection is no longer reported once it is removed.
+	_, err = serverConn.Read(make([]byte, len(CreatePgTerminatePacket())))
+	require.NoError(t, err)
+	require.NoError(t, poller.wait(fd, func() { ready <- struct{}{} }))
+	poller.remove(fd)
+	_, err = client.Write(CreatePgTerminatePacket())
+	require.NoError(t, err)
+	select {
+	case <-ready:
+		t.Fatal("the connection is reported after it is removed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The connections without a file descriptor are served by goroutines.
+	pipe, _ := net.Pipe()
+	defer pipe.Close()
+	_, ok = pollableFD(NewConnWrapper(pipe, nil, config.DefaultHandshakeTimeout))
+	assert.False(t, ok)
+}
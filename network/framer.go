@@ -0,0 +1,197 @@
+package network
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+)
+
+// PostgreSQL wire-protocol constants used for framing messages.
+// See https://www.postgresql.org/docs/current/protocol-message-formats.html
+const (
+	// PostgresMessageHeaderSize is the size of the type byte and the length prefix.
+	PostgresMessageHeaderSize = 5
+	// PostgresLengthSize is the size of the length prefix.
+	PostgresLengthSize = 4
+	// PostgresMaxStartupMessageLength is the maximum length of an untyped
+	// message, which matches MAX_STARTUP_PACKET_LENGTH in PostgreSQL.
+	PostgresMaxStartupMessageLength = 10000
+	// PostgresMaxMessageLength is the maximum length of a typed message,
+	// which matches PQ_LARGE_MESSAGE_LIMIT in PostgreSQL.
+	PostgresMaxMessageLength = 0x3fffffff
+
+	PostgresProtocolVersion = 196608   // 3.0
+	PostgresCancelRequest   = 80877102 // 1234.5678
+	PostgresSSLRequest      = 80877103 // 1234.5679
+	PostgresGSSENCRequest   = 80877104 // 1234.5680
+)
+
+// Framer reads whole PostgreSQL wire-protocol messages from a connection, using
+// the type byte and the length prefix to find the message boundaries. The untyped
+// messages that can be sent by the client before the session starts, StartupMessage,
+// SSLRequest, GSSENCRequest and CancelRequest, are framed using the length prefix only.
+type Framer struct {
+	reader    *bufio.Reader
+	batchSize int
+	startup   bool
+	unread    []byte
+}
+
+// NewFramer creates a new framer that reads from the given reader. The batch size
+// is used both as the size of the read buffer and as the soft limit of the number
+// of bytes returned by ReadMessages. If startup is true, the framer expects the
+// untyped messages of the startup phase first, which is the case for client connections.
+func NewFramer(reader io.Reader, batchSize int, startup bool) *Framer {
+	if batchSize <= 0 {
+		batchSize = config.DefaultChunkSize
+	}
+
+	return &Framer{
+		reader:    bufio.NewReaderSize(reader, batchSize),
+		batchSize: batchSize,
+		startup:   startup,
+	}
+}
+
+// ReadMessage reads a single whole message from the connection. It blocks until
+// the message is completely received or an error occurs.
+func (f *Framer) ReadMessage() ([]byte, error) {
+	if f.startup {
+		return f.readUntypedMessage()
+	}
+
+	return f.readTypedMessage()
+}
+
+// ReadMessages reads at least one whole message from the connection, and then keeps
+// reading the messages that are already (partially) buffered, without waiting for
+// more data to arrive. It stops once the batch size is reached or after an untyped
+// message, since the connection might be upgraded to TLS after an SSLRequest. The
+// typed messages are read into a buffer of the buffer pool, which the caller can give
+// back with releaseBuffer once it no longer uses the batch.
+func (f *Framer) ReadMessages() ([]byte, error) {
+	if f.startup {
+		return f.readUntypedMessage()
+	}
+
+	batch := buffers.get(f.batchSize)
+	for {
+		var err error
+		batch, err = f.appendTypedMessage(batch)
+		if err != nil {
+			if len(batch) == 0 {
+				releaseBuffer(batch)
+				return nil, err
+			}
+			return batch, err
+		}
+
+		if f.reader.Buffered() == 0 || len(batch) >= f.batchSize {
+			return batch, nil
+		}
+	}
+}
+
+// Buffered returns the number of bytes that are read from the connection,
+// but not yet returned as a message.
+func (f *Framer) Buffered() int {
+	return f.reader.Buffered()
+}
+
+// Drain returns the data that is read from the connection, but not yet returned as a
+// message, and discards it, so that the rest of the data can be read from the connection.
+func (f *Framer) Drain() []byte {
+	data := f.unread
+	f.unread = nil
+	buffered, _ := f.reader.Peek(f.reader.Buffered())
+	data = append(data, buffered...)
+	_, _ = f.reader.Discard(len(buffered))
+	return data
+}
+
+// UnreadStartupMessage puts back an untyped message that was read in the startup phase,
+// so that the next read returns it again. It is used to inspect the StartupMessage before
+// the connection is assigned to a proxy, which then receives the message as usual.
+func (f *Framer) UnreadStartupMessage(msg []byte) {
+	f.unread = msg
+	f.startup = true
+}
+
+// IsStartup returns true if the framer is still expecting untyped messages.
+func (f *Framer) IsStartup() bool {
+	return f.startup
+}
+
+// readTypedMessage reads a message that starts with a type byte and a length prefix.
+func (f *Framer) readTypedMessage() ([]byte, error) {
+	return f.appendTypedMessage(nil)
+}
+
+// appendTypedMessage reads a message that starts with a type byte and a length prefix, and
+// appends it to the batch, which is grown if needed. The header is peeked from the read
+// buffer, so that the message is copied once, directly to its place in the batch.
+func (f *Framer) appendTypedMessage(batch []byte) ([]byte, error) {
+	header, err := f.reader.Peek(PostgresMessageHeaderSize)
+	if err != nil {
+		if len(header) > 0 && errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return batch, err //nolint:wrapcheck
+	}
+
+	length := int(binary.BigEndian.Uint32(header[1:PostgresMessageHeaderSize]))
+	if length < PostgresLengthSize || length > PostgresMaxMessageLength {
+		return batch, gerr.ErrMalformedMessage.Wrap(
+			fmt.Errorf("invalid length %d for message type %q", length, header[0]))
+	}
+
+	start := len(batch)
+	batch = slices.Grow(batch, 1+length)[:start+1+length]
+	if _, err := io.ReadFull(f.reader, batch[start:]); err != nil {
+		return batch[:start], err //nolint:wrapcheck
+	}
+
+	return batch, nil
+}
+
+// readUntypedMessage reads a message that only starts with a length prefix, followed
+// by the protocol version or request code. Once a StartupMessage is read, the framer
+// switches to typed messages.
+func (f *Framer) readUntypedMessage() ([]byte, error) {
+	msg := f.unread
+	f.unread = nil
+	if msg == nil {
+		header := make([]byte, PostgresLengthSize)
+		if _, err := io.ReadFull(f.reader, header); err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+
+		length := int(binary.BigEndian.Uint32(header))
+		if length < 2*PostgresLengthSize || length > PostgresMaxStartupMessageLength {
+			return nil, gerr.ErrMalformedMessage.Wrap(
+				fmt.Errorf("invalid length %d for startup message", length))
+		}
+
+		msg = make([]byte, length)
+		copy(msg, header)
+		if _, err := io.ReadFull(f.reader, msg[PostgresLengthSize:]); err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+	}
+
+	switch binary.BigEndian.Uint32(msg[PostgresLengthSize : 2*PostgresLengthSize]) {
+	case PostgresSSLRequest, PostgresGSSENCRequest, PostgresCancelRequest:
+		// The client either negotiates encryption and sends another untyped
+		// message, or cancels a query and closes the connection.
+	default:
+		f.startup = false
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,153 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFramerReadMessage tests that the framer reads whole typed messages,
+// even if the data arrives one byte at a time.
+func TestFramerReadMessage(t *testing.T) {
+	query := CreatePostgreSQLPacket('Q', []byte("select 1;\x00"))
+	sync := CreatePostgreSQLPacket('S', nil)
+	data := append(append([]byte{}, query...), sync...)
+
+	framer := NewFramer(iotest.OneByteReader(bytes.NewReader(data)), 8, false)
+
+	msg, err := framer.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, query, msg)
+
+	msg, err = framer.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, sync, msg)
+
+	_, err = framer.ReadMessage()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+// TestFramerReadMessages tests that the framer batches the buffered messages.
+func TestFramerReadMessages(t *testing.T) {
+	parse := CreatePostgreSQLPacket('P', []byte("\x00select 1\x00\x00\x00"))
+	bind := CreatePostgreSQLPacket('B', []byte("\x00\x00\x00\x00\x00\x00\x00\x00"))
+	sync := CreatePostgreSQLPacket('S', nil)
+	data := bytes.Join([][]byte{parse, bind, sync}, nil)
+
+	framer := NewFramer(bytes.NewReader(data), 1024, false)
+	batch, err := framer.ReadMessages()
+	require.NoError(t, err)
+	assert.Equal(t, data, batch)
+	assert.Zero(t, framer.Buffered())
+
+	// The batch is cut once the batch size is reached.
+	framer = NewFramer(bytes.NewReader(data), len(parse), false)
+	batch, err = framer.ReadMessages()
+	require.NoError(t, err)
+	assert.Equal(t, parse, batch)
+}
+
+// TestFramerDrain tests that the data that is buffered after a message is drained.
+func TestFramerDrain(t *testing.T) {
+	query := CreatePostgreSQLPacket('Q', []byte("select 1\x00"))
+	data := bytes.Join([][]byte{query, query}, nil)
+
+	framer := NewFramer(bytes.NewReader(data), 1024, false)
+	msg, err := framer.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, query, msg)
+	assert.Equal(t, query, framer.Drain())
+	assert.Zero(t, framer.Buffered())
+	assert.Empty(t, framer.Drain())
+}
+
+// TestFramerStartup tests the untyped messages of the startup phase.
+func TestFramerStartup(t *testing.T) {
+	sslRequest := []byte{0x00, 0x00, 0x00, 0x8, 0x04, 0xd2, 0x16, 0x2f}
+	startup := CreatePgStartupPacket()
+	terminate := CreatePgTerminatePacket()
+	data := bytes.Join([][]byte{sslRequest, startup, terminate}, nil)
+
+	framer := NewFramer(bytes.NewReader(data), 1024, true)
+	assert.True(t, framer.IsStartup())
+
+	// The SSLRequest is returned alone, since the connection might be upgraded.
+	batch, err := framer.ReadMessages()
+	require.NoError(t, err)
+	assert.Equal(t, sslRequest, batch)
+	assert.True(t, framer.IsStartup())
+
+	batch, err = framer.ReadMessages()
+	require.NoError(t, err)
+	assert.Equal(t, startup, batch)
+	assert.False(t, framer.IsStartup())
+
+	batch, err = framer.ReadMessages()
+	require.NoError(t, err)
+	assert.Equal(t, terminate, batch)
+}
+
+// TestFramerUnreadStartupMessage tests that the StartupMessage can be put back and read again.
+func TestFramerUnreadStartupMessage(t *testing.T) {
+	startup := CreatePgStartupPacket()
+	terminate := CreatePgTerminatePacket()
+	data := bytes.Join([][]byte{startup, terminate}, nil)
+
+	framer := NewFramer(bytes.NewReader(data), 1024, true)
+	msg, err := framer.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, startup, msg)
+	assert.False(t, framer.IsStartup())
+
+	framer.UnreadStartupMessage(msg)
+	assert.True(t, framer.IsStartup())
+
+	batch, err := framer.ReadMessages()
+	require.NoError(t, err)
+	assert.Equal(t, startup, batch)
+	assert.False(t, framer.IsStartup())
+
+	batch, err = framer.ReadMessages()
+	require.NoError(t, err)
+	assert.Equal(t, terminate, batch)
+}
+
+// TestFramerMalformedMessage tests that invalid lengths are rejected.
+func TestFramerMalformedMessage(t *testing.T) {
+	framer := NewFramer(bytes.NewReader([]byte{'Q', 0x00, 0x00, 0x00, 0x01}), 1024, false)
+	_, err := framer.ReadMessage()
+	assert.True(t, errors.Is(err, gerr.ErrMalformedMessage))
+
+	framer = NewFramer(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}), 1024, true)
+	_, err = framer.ReadMessage()
+	assert.True(t, errors.Is(err, gerr.ErrMalformedMessage))
+}
+
+// BenchmarkFramerReadMessages measures the allocations of reading a batch of messages.
+func BenchmarkFramerReadMessages(b *testing.B) {
+	var batch []byte
+	for i := 0; i < 10; i++ {
+		batch = append(batch, CreatePostgreSQLPacket(PostgresDataRow, []byte("\x00\x01\x00\x00\x00\x011"))...)
+	}
+	batch = append(batch, ReadyForQuery(PostgresTxIdle)...)
+	reader := bytes.NewReader(batch)
+	framer := NewFramer(reader, config.DefaultChunkSize, false)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader.Reset(batch)
+		messages, err := framer.ReadMessages()
+		if err != nil {
+			b.Fatal(err)
+		}
+		releaseBuffer(messages)
+	}
+}
@@ -0,0 +1,152 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+)
+
+// ListenerFDsEnv is the environment variable that passes the listeners of a gatewayd process
+// to the new process that replaces it, as a comma-separated list of network/address=fd.
+const ListenerFDsEnv = "GATEWAYD_LISTENER_FDS"
+
+var (
+	// listenersMu guards the listeners and the inherited file descriptors.
+	listenersMu sync.Mutex
+	// listeners are the open listeners by network/address, which are passed to the new process.
+	listeners = map[string]*inheritableListener{}
+	// inheritedFDs are the file descriptors of the listeners that are passed by the previous
+	// process, by network/address, until they are used.
+	inheritedFDs = parseListenerFDs(os.Getenv(ListenerFDsEnv))
+)
+
+// inheritableListener is a listener opened by Listen, which is
+// passed to the new process as long as it is not closed.
+type inheritableListener struct {
+	net.Listener
+	key string
+}
+
+// Close closes the listener and stops passing it to the new process.
+func (l *inheritableListener) Close() error {
+	listenersMu.Lock()
+	if listeners[l.key] == l {
+		delete(listeners, l.key)
+	}
+	listenersMu.Unlock()
+
+	return l.Listener.Close() //nolint:wrapcheck
+}
+
+// parseListenerFDs parses the value of ListenerFDsEnv. The invalid entries are skipped.
+func parseListenerFDs(value string) map[string]int {
+	fds := map[string]int{}
+	for _, entry := range strings.Split(value, ",") {
+		separator := strings.LastIndex(entry, "=")
+		if separator <= 0 {
+			continue
+		}
+		if fd, err := strconv.Atoi(entry[separator+1:]); err == nil && fd > 2 {
+			fds[entry[:separator]] = fd
+		}
+	}
+	return fds
+}
+
+// listenerKey returns the key of the listener of the address in ListenerFDsEnv.
+func listenerKey(network, address string) string {
+	return network + "/" + address
+}
+
+// IsInheritedListener returns true if the listener of the address is passed by the previous
+// process, and not used yet.
+func IsInheritedListener(network, address string) bool {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+
+	_, ok := inheritedFDs[listenerKey(network, address)]
+	return ok
+}
+
+// Listen returns the listener of the address that is passed by the previous process, or
+// listens on the address otherwise. The listener is passed to the new process by StartProcess,
+// so that the connections are accepted by either process while they both run.
+func Listen(network, address string) (net.Listener, error) {
+	key := listenerKey(network, address)
+
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+
+	var listener net.Listener
+	var err error
+	if fd, ok := inheritedFDs[key]; ok {
+		delete(inheritedFDs, key)
+		file := os.NewFile(uintptr(fd), key)
+		listener, err = net.FileListener(file)
+		// The listener has its own copy of the file descriptor.
+		file.Close()
+	} else {
+		listener, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	inheritable := &inheritableListener{Listener: listener, key: key}
+	listeners[key] = inheritable
+	return inheritable, nil
+}
+
+// StartProcess starts a new gatewayd process with the same executable, arguments and
+// environment, which inherits the open listeners. The new process accepts the connections
+// once it runs, so this process can stop accepting them and drain its own connections.
+func StartProcess() (*os.Process, *gerr.GatewayDError) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, gerr.ErrRestartFailed.Wrap(err)
+	}
+
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	fds := make([]string, 0, len(listeners))
+	defer func() {
+		for _, file := range files[3:] {
+			file.Close()
+		}
+	}()
+	for key, listener := range listeners {
+		filer, ok := listener.Listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			continue
+		}
+		file, err := filer.File()
+		if err != nil {
+			return nil, gerr.ErrRestartFailed.Wrap(err)
+		}
+		if unixListener, ok := listener.Listener.(*net.UnixListener); ok {
+			// Keep the socket file for the new process when this listener is closed.
+			unixListener.SetUnlinkOnClose(false)
+		}
+		fds = append(fds, fmt.Sprintf("%s=%d", key, len(files)))
+		files = append(files, file)
+	}
+
+	env := slices.DeleteFunc(os.Environ(), func(variable string) bool {
+		return strings.HasPrefix(variable, ListenerFDsEnv+"=")
+	})
+	env = append(env, ListenerFDsEnv+"="+strings.Join(fds, ","))
+
+	process, err := os.StartProcess(executable, os.Args, &os.ProcAttr{Env: env, Files: files})
+	if err != nil {
+		return nil, gerr.ErrRestartFailed.Wrap(err)
+	}
+	return process, nil
+}
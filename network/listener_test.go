@@ -0,0 +1,65 @@
+//go:build !windows
+// +build !windows
+
+package network
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseListenerFDs tests that the listeners of the previous process are parsed from the
+// environment variable, and that the invalid entries are skipped.
+func TestParseListenerFDs(t *testing.T) {
+	assert.Equal(t,
+		map[string]int{"tcp/0.0.0.0:15432": 3, "unix//tmp/.s.PGSQL.5432": 4},
+		parseListenerFDs("tcp/0.0.0.0:15432=3,unix//tmp/.s.PGSQL.5432=4,tcp/:9090=x,=5,tcp/:19090=1"))
+	assert.Empty(t, parseListenerFDs(""))
+}
+
+// TestListen tests that the listener that is passed by the previous process is used instead of
+// listening again, and that the listeners are passed to the new process until they are closed.
+func TestListen(t *testing.T) {
+	previous, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer previous.Close()
+	address := previous.Addr().String()
+
+	// Pass a copy of the listener, as the previous process would.
+	file, err := previous.(*net.TCPListener).File()
+	require.NoError(t, err)
+	fd, err := syscall.Dup(int(file.Fd()))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	listenersMu.Lock()
+	inheritedFDs[listenerKey("tcp", address)] = fd
+	listenersMu.Unlock()
+	assert.True(t, IsInheritedListener("tcp", address))
+
+	// The address is in use, so the listener is the inherited one.
+	listener, err := Listen("tcp", address)
+	require.NoError(t, err)
+	assert.False(t, IsInheritedListener("tcp", address))
+	assert.Equal(t, address, listener.Addr().String())
+
+	// The connections are accepted by the inherited listener, even after the previous one is closed.
+	require.NoError(t, previous.Close())
+	client, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	conn.Close()
+
+	listenersMu.Lock()
+	assert.Contains(t, listeners, listenerKey("tcp", address))
+	listenersMu.Unlock()
+	require.NoError(t, listener.Close())
+	listenersMu.Lock()
+	assert.NotContains(t, listeners, listenerKey("tcp", address))
+	listenersMu.Unlock()
+}
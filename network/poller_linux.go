@@ -0,0 +1,107 @@
+//go:build linux
+
+package network
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+const (
+	// pollerEvents is the number of events that are read at once from epoll.
+	pollerEvents = 128
+	// pollerTimeout is how long epoll is waited for, in milliseconds, before
+	// the poller checks whether it is closed.
+	pollerTimeout = 500
+)
+
+// poller waits with epoll for the incoming connections to become readable, so that an idle
+// connection doesn't need a goroutine blocked on reading it. Each connection is waited for
+// once, and its callback is called in a new goroutine once it becomes readable or closed.
+type poller struct {
+	epfd      int
+	callbacks map[int]func()
+	mu        sync.Mutex
+	closed    atomic.Bool
+}
+
+// newPoller creates a poller and starts waiting for the events of its connections.
+func newPoller() (*poller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	p := &poller{
+		epfd:      epfd,
+		callbacks: map[int]func(){},
+	}
+	go p.run()
+	return p, nil
+}
+
+// wait waits for the file descriptor to become readable, and then calls ready. It is added
+// to epoll the first time, and re-armed the next times, since it is only reported once.
+func (p *poller) wait(fd int, ready func()) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	operation := syscall.EPOLL_CTL_MOD
+	if _, ok := p.callbacks[fd]; !ok {
+		operation = syscall.EPOLL_CTL_ADD
+	}
+	event := syscall.EpollEvent{
+		Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT,
+		Fd:     int32(fd),
+	}
+	if err := syscall.EpollCtl(p.epfd, operation, fd, &event); err != nil {
+		return err //nolint:wrapcheck
+	}
+	p.callbacks[fd] = ready
+	return nil
+}
+
+// remove stops waiting for the file descriptor. It must be called before the connection is
+// closed, since its file descriptor can be reused by another connection right afterwards.
+func (p *poller) remove(fd int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.callbacks[fd]; ok {
+		delete(p.callbacks, fd)
+		_ = syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+	}
+}
+
+// close stops the poller. The connections that are still waited for are closed by the
+// shutdown of their proxies.
+func (p *poller) close() {
+	p.closed.Store(true)
+}
+
+// run reads the events from epoll and calls the callbacks of the readable connections,
+// until the poller is closed.
+func (p *poller) run() {
+	defer syscall.Close(p.epfd)
+
+	events := make([]syscall.EpollEvent, pollerEvents)
+	for !p.closed.Load() {
+		count, err := syscall.EpollWait(p.epfd, events, pollerTimeout)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			return
+		}
+
+		p.mu.Lock()
+		for _, event := range events[:count] {
+			if ready, ok := p.callbacks[int(event.Fd)]; ok {
+				go ready()
+			}
+		}
+		p.mu.Unlock()
+	}
+}
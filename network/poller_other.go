@@ -0,0 +1,20 @@
+//go:build !linux
+
+package network
+
+import "errors"
+
+// poller is only implemented with epoll, so the incoming
+// connections are always served by goroutines on other systems.
+type poller struct{}
+
+// newPoller returns an error, since epoll is not available.
+func newPoller() (*poller, error) {
+	return nil, errors.New("the event loop is only supported on Linux")
+}
+
+func (p *poller) wait(int, func()) error { return nil }
+
+func (p *poller) remove(int) {}
+
+func (p *poller) close() {}
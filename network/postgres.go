@@ -0,0 +1,496 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"regexp"
+	"slices"
+	"strings"
+
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+)
+
+// PostgreSQL wire-protocol message types used by the proxy.
+// See https://www.postgresql.org/docs/current/protocol-message-formats.html
+const (
+	// Frontend messages.
+	PostgresQuery        byte = 'Q'
+	PostgresParse        byte = 'P'
+	PostgresBind         byte = 'B'
+	PostgresDescribe     byte = 'D'
+	PostgresExecute      byte = 'E'
+	PostgresClose        byte = 'C'
+	PostgresFlush        byte = 'H'
+	PostgresSync         byte = 'S'
+	PostgresFunctionCall byte = 'F'
+	PostgresTerminate    byte = 'X'
+	PostgresPassword     byte = 'p'
+	PostgresCopyData     byte = 'd'
+	PostgresCopyDone     byte = 'c'
+	PostgresCopyFail     byte = 'f'
+
+	// Backend messages.
+	PostgresAuthentication   byte = 'R'
+	PostgresBackendKeyData   byte = 'K'
+	PostgresParameterStatus  byte = 'S'
+	PostgresDataRow          byte = 'D'
+	PostgresReadyForQuery    byte = 'Z'
+	PostgresErrorResponse    byte = 'E'
+	PostgresParseComplete    byte = '1'
+	PostgresCloseComplete    byte = '3'
+	PostgresCommandComplete  byte = 'C'
+	PostgresCopyInResponse   byte = 'G'
+	PostgresCopyOutResponse  byte = 'H'
+	PostgresCopyBothResponse byte = 'W'
+
+	// Transaction status indicators of the ReadyForQuery message.
+	PostgresTxIdle          byte = 'I'
+	PostgresTxInTransaction byte = 'T'
+	PostgresTxFailed        byte = 'E'
+)
+
+// Request codes of the Authentication message.
+const (
+	PostgresAuthOk                = 0
+	PostgresAuthCleartextPassword = 3
+	PostgresAuthMD5Password       = 5
+	PostgresAuthSASL              = 10
+	PostgresAuthSASLContinue      = 11
+	PostgresAuthSASLFinal         = 12
+)
+
+// QueryKind is the kind of a request for read/write splitting.
+type QueryKind int
+
+const (
+	// WriteQuery must be sent to the primary, and keeps the session on the primary.
+	WriteQuery QueryKind = iota
+	// ReadOnlyQuery can be sent to a replica.
+	ReadOnlyQuery
+	// UtilityQuery neither reads nor writes data, like SET or COMMIT, and is sent to the primary.
+	UtilityQuery
+)
+
+var (
+	sqlComments = regexp.MustCompile(`(?s)/\*.*?\*/|--[^\n]*`)
+	sqlWords    = regexp.MustCompile(`[a-z_][a-z0-9_$]*`)
+)
+
+// Severities of the errors sent to the client by GatewayD.
+const (
+	PostgresSeverityError = "ERROR"
+	PostgresSeverityFatal = "FATAL"
+)
+
+// ForEachMessage calls the given function for each whole typed message in data,
+// with the message type and its body. It stops if the function returns false or
+// if the rest of the data is not a whole message.
+func ForEachMessage(data []byte, callback func(msgType byte, body []byte) bool) {
+	for len(data) >= PostgresMessageHeaderSize {
+		length := int(binary.BigEndian.Uint32(data[1:PostgresMessageHeaderSize]))
+		if length < PostgresLengthSize || len(data) < 1+length {
+			return
+		}
+
+		if !callback(data[0], data[PostgresMessageHeaderSize:1+length]) {
+			return
+		}
+
+		data = data[1+length:]
+	}
+}
+
+// IsPostgresStartupMessage returns true if the message is a StartupMessage.
+func IsPostgresStartupMessage(data []byte) bool {
+	if len(data) < 2*PostgresLengthSize {
+		return false
+	}
+
+	if int(binary.BigEndian.Uint32(data[0:4])) != len(data) {
+		return false
+	}
+
+	return binary.BigEndian.Uint32(data[4:8]) == PostgresProtocolVersion
+}
+
+// ParseStartupMessage returns the parameters of a StartupMessage, like user,
+// database and application_name. It returns nil if the message is not a StartupMessage.
+func ParseStartupMessage(data []byte) map[string]string {
+	if !IsPostgresStartupMessage(data) {
+		return nil
+	}
+
+	parameters := map[string]string{}
+	fields := bytes.Split(data[2*PostgresLengthSize:], []byte{0})
+	for i := 0; i+1 < len(fields); i += 2 {
+		if len(fields[i]) == 0 {
+			break
+		}
+		parameters[string(fields[i])] = string(fields[i+1])
+	}
+
+	return parameters
+}
+
+// CountReadyForQueryRequests returns the number of messages in the request that the
+// server answers with a ReadyForQuery message, which are Query, Sync and FunctionCall.
+func CountReadyForQueryRequests(data []byte) int {
+	count := 0
+	ForEachMessage(data, func(msgType byte, _ []byte) bool {
+		switch msgType {
+		case PostgresQuery, PostgresSync, PostgresFunctionCall:
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// HasTerminateMessage returns true if the request contains a Terminate message.
+func HasTerminateMessage(data []byte) bool {
+	found := false
+	ForEachMessage(data, func(msgType byte, _ []byte) bool {
+		found = msgType == PostgresTerminate
+		return !found
+	})
+	return found
+}
+
+// ReadyForQueryStatus returns the number of ReadyForQuery messages in the response
+// and the transaction status of the last one.
+func ReadyForQueryStatus(data []byte) (int, byte) {
+	count := 0
+	var status byte
+	ForEachMessage(data, func(msgType byte, body []byte) bool {
+		if msgType == PostgresReadyForQuery && len(body) == 1 {
+			count++
+			status = body[0]
+		}
+		return true
+	})
+	return count, status
+}
+
+// ResponseSummary returns the response without the DataRow and CopyData messages, so that
+// only the header and the summary of a result set are left, like RowDescription and
+// CommandComplete. It returns nil if nothing is left.
+func ResponseSummary(data []byte) []byte {
+	var summary []byte
+	rest := data
+	ForEachMessage(data, func(msgType byte, body []byte) bool {
+		msg := rest[:PostgresMessageHeaderSize+len(body)]
+		rest = rest[len(msg):]
+		if msgType != PostgresDataRow && msgType != PostgresCopyData {
+			summary = append(summary, msg...)
+		}
+		return true
+	})
+	return summary
+}
+
+// ErrorResponse creates an ErrorResponse message with the given severity,
+// SQLSTATE code and message.
+func ErrorResponse(severity, code, message string) []byte {
+	var body []byte
+	for _, field := range []struct {
+		Type  byte
+		Value string
+	}{
+		{'S', severity},
+		{'V', severity},
+		{'C', code},
+		{'M', message},
+	} {
+		body = append(body, field.Type)
+		body = append(body, field.Value...)
+		body = append(body, 0)
+	}
+	body = append(body, 0)
+
+	msg := make([]byte, PostgresMessageHeaderSize, PostgresMessageHeaderSize+len(body))
+	msg[0] = PostgresErrorResponse
+	binary.BigEndian.PutUint32(msg[1:PostgresMessageHeaderSize], uint32(PostgresLengthSize+len(body)))
+	return append(msg, body...)
+}
+
+// GatewayDErrorResponse creates an ErrorResponse message for an error that is caused by
+// GatewayD. It returns nil if the error has no PostgreSQL error, for example if the client
+// has closed the connection.
+func GatewayDErrorResponse(err error) []byte {
+	pgErr, ok := gerr.GetPostgresError(err)
+	if !ok {
+		return nil
+	}
+
+	return ErrorResponse(pgErr.Severity, pgErr.SQLState, pgErr.Message)
+}
+
+// ReadyForQuery creates a ReadyForQuery message with the given transaction status.
+func ReadyForQuery(txStatus byte) []byte {
+	return []byte{PostgresReadyForQuery, 0, 0, 0, PostgresLengthSize + 1, txStatus}
+}
+
+// BackendKeyData creates a BackendKeyData message with the given process ID and secret key.
+func BackendKeyData(processID, secretKey uint32) []byte {
+	msg := make([]byte, PostgresMessageHeaderSize+2*PostgresLengthSize)
+	msg[0] = PostgresBackendKeyData
+	binary.BigEndian.PutUint32(msg[1:PostgresMessageHeaderSize], uint32(3*PostgresLengthSize))
+	binary.BigEndian.PutUint32(msg[PostgresMessageHeaderSize:], processID)
+	binary.BigEndian.PutUint32(msg[PostgresMessageHeaderSize+PostgresLengthSize:], secretKey)
+	return msg
+}
+
+// CancelRequest creates a CancelRequest message with the given process ID and secret key.
+func CancelRequest(processID, secretKey uint32) []byte {
+	msg := make([]byte, 4*PostgresLengthSize)
+	binary.BigEndian.PutUint32(msg[0:4], uint32(4*PostgresLengthSize))
+	binary.BigEndian.PutUint32(msg[4:8], PostgresCancelRequest)
+	binary.BigEndian.PutUint32(msg[8:12], processID)
+	binary.BigEndian.PutUint32(msg[12:16], secretKey)
+	return msg
+}
+
+// ParseCancelRequest returns the process ID and secret key of a CancelRequest message.
+// It returns false if the message is not a CancelRequest.
+func ParseCancelRequest(data []byte) (uint32, uint32, bool) {
+	if !IsPostgresCancelRequest(data) {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint32(data[8:12]), binary.BigEndian.Uint32(data[12:16]), true
+}
+
+// QueryMessage creates a Query message for the given query.
+func QueryMessage(query string) []byte {
+	msg := make([]byte, PostgresMessageHeaderSize, PostgresMessageHeaderSize+len(query)+1)
+	msg[0] = PostgresQuery
+	binary.BigEndian.PutUint32(msg[1:PostgresMessageHeaderSize], uint32(PostgresLengthSize+len(query)+1))
+	msg = append(msg, query...)
+	return append(msg, 0)
+}
+
+// ParameterizedQuery creates the Parse, Bind, Execute and Sync messages that run the query
+// with the given text parameters, which are not interpolated into the query.
+func ParameterizedQuery(query string, params ...string) []byte {
+	parse := append([]byte{0}, query...)
+	parse = append(parse, 0, 0, 0)
+
+	bind := []byte{0, 0, 0, 0}
+	bind = binary.BigEndian.AppendUint16(bind, uint16(len(params)))
+	for _, param := range params {
+		bind = binary.BigEndian.AppendUint32(bind, uint32(len(param)))
+		bind = append(bind, param...)
+	}
+	bind = append(bind, 0, 0)
+
+	msg := newMessage(PostgresParse, parse)
+	msg = append(msg, newMessage(PostgresBind, bind)...)
+	msg = append(msg, newMessage(PostgresExecute, []byte{0, 0, 0, 0, 0})...)
+	return append(msg, newMessage(PostgresSync, nil)...)
+}
+
+// StartupMessage creates a StartupMessage with the given parameters.
+func StartupMessage(parameters map[string]string) []byte {
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	msg := make([]byte, 2*PostgresLengthSize)
+	binary.BigEndian.PutUint32(msg[PostgresLengthSize:], PostgresProtocolVersion)
+	for _, name := range names {
+		msg = append(msg, name...)
+		msg = append(msg, 0)
+		msg = append(msg, parameters[name]...)
+		msg = append(msg, 0)
+	}
+	msg = append(msg, 0)
+	binary.BigEndian.PutUint32(msg, uint32(len(msg)))
+	return msg
+}
+
+// Authentication creates an Authentication message with the given request code and data.
+func Authentication(code uint32, data []byte) []byte {
+	return newMessage(PostgresAuthentication, append(binary.BigEndian.AppendUint32(nil, code), data...))
+}
+
+// PasswordMessage creates a message of the password exchange, which is a
+// PasswordMessage, SASLInitialResponse or SASLResponse, with the given body.
+func PasswordMessage(body []byte) []byte {
+	return newMessage(PostgresPassword, body)
+}
+
+// newMessage creates a typed message with the given type and body.
+func newMessage(msgType byte, body []byte) []byte {
+	msg := make([]byte, PostgresMessageHeaderSize, PostgresMessageHeaderSize+len(body))
+	msg[0] = msgType
+	binary.BigEndian.PutUint32(msg[1:PostgresMessageHeaderSize], uint32(PostgresLengthSize+len(body)))
+	return append(msg, body...)
+}
+
+// ErrorResponseMessage returns the message field of the body of an ErrorResponse message.
+func ErrorResponseMessage(body []byte) string {
+	for len(body) > 0 && body[0] != 0 {
+		value, rest, _ := bytes.Cut(body[1:], []byte{0})
+		if body[0] == 'M' {
+			return string(value)
+		}
+		body = rest
+	}
+	return ""
+}
+
+// DataRowValues returns the values of the columns of the body of a DataRow message.
+// The NULL values are returned as nil.
+func DataRowValues(body []byte) [][]byte {
+	if len(body) < 2 {
+		return nil
+	}
+
+	count := int(binary.BigEndian.Uint16(body))
+	body = body[2:]
+	values := make([][]byte, 0, count)
+	for i := 0; i < count && len(body) >= PostgresLengthSize; i++ {
+		length := int(int32(binary.BigEndian.Uint32(body)))
+		body = body[PostgresLengthSize:]
+		if length < 0 {
+			values = append(values, nil)
+			continue
+		}
+		if length > len(body) {
+			break
+		}
+		values = append(values, body[:length])
+		body = body[length:]
+	}
+	return values
+}
+
+// ClassifyRequest returns the kind of the request from the queries of its Query and Parse
+// messages. It is a write if any of the queries is a write, and read-only if all of them are
+// read-only. A Bind message of a statement that is not parsed in the same request and any
+// other message, like FunctionCall, is a write, since what it runs is unknown.
+func ClassifyRequest(data []byte) QueryKind {
+	kinds := []QueryKind{}
+	parsed := map[string]bool{}
+	ForEachMessage(data, func(msgType byte, body []byte) bool {
+		switch msgType {
+		case PostgresQuery:
+			query, _, _ := bytes.Cut(body, []byte{0})
+			kinds = append(kinds, ClassifyQuery(string(query)))
+		case PostgresParse:
+			// The name of the statement is followed by the query.
+			name, rest, _ := bytes.Cut(body, []byte{0})
+			query, _, _ := bytes.Cut(rest, []byte{0})
+			parsed[string(name)] = true
+			kinds = append(kinds, ClassifyQuery(string(query)))
+		case PostgresBind:
+			// The name of the portal is followed by the name of the statement.
+			_, rest, _ := bytes.Cut(body, []byte{0})
+			statement, _, _ := bytes.Cut(rest, []byte{0})
+			if !parsed[string(statement)] {
+				kinds = append(kinds, WriteQuery)
+			}
+		case PostgresDescribe, PostgresExecute, PostgresClose, PostgresFlush, PostgresSync:
+		default:
+			kinds = append(kinds, WriteQuery)
+		}
+		return !slices.Contains(kinds, WriteQuery)
+	})
+
+	return combineQueryKinds(kinds)
+}
+
+// ClassifyQuery returns the kind of the query from the keywords of its statements. The
+// read-only statements are SELECT, VALUES and TABLE without locking clauses, INTO or
+// sequence functions, WITH without data-modifying statements, SHOW, EXPLAIN without
+// ANALYZE, and BEGIN or START TRANSACTION with READ ONLY. The statements that can't be
+// classified are writes, so that they are sent to the primary.
+func ClassifyQuery(query string) QueryKind {
+	kinds := []QueryKind{}
+	// Splitting a string literal that contains a semicolon leaves a statement that
+	// doesn't start with a keyword, which is a write.
+	for _, statement := range strings.Split(query, ";") {
+		statement = strings.TrimSpace(sqlComments.ReplaceAllString(statement, " "))
+		if statement == "" {
+			continue
+		}
+		kinds = append(kinds,
+			classifyStatement(sqlWords.FindAllString(strings.ToLower(statement), -1)))
+	}
+
+	return combineQueryKinds(kinds)
+}
+
+// classifyStatement returns the kind of a statement from its lowercase words.
+func classifyStatement(words []string) QueryKind {
+	if len(words) == 0 {
+		return WriteQuery
+	}
+
+	switch words[0] {
+	case "select", "values", "table":
+		if hasSideEffects(words) {
+			return WriteQuery
+		}
+		return ReadOnlyQuery
+	case "with":
+		if hasSideEffects(words) ||
+			slices.ContainsFunc(words, func(word string) bool {
+				return word == "insert" || word == "update" || word == "delete" || word == "merge"
+			}) {
+			return WriteQuery
+		}
+		return ReadOnlyQuery
+	case "show":
+		return ReadOnlyQuery
+	case "explain":
+		// EXPLAIN ANALYZE runs the statement.
+		if slices.Contains(words, "analyze") || slices.Contains(words, "analyse") {
+			return WriteQuery
+		}
+		return ReadOnlyQuery
+	case "begin", "start":
+		if index := slices.Index(words, "read"); index >= 0 &&
+			index+1 < len(words) && words[index+1] == "only" {
+			return ReadOnlyQuery
+		}
+		return UtilityQuery
+	case "set", "reset", "discard", "commit", "rollback", "end", "abort", "savepoint",
+		"release", "deallocate", "listen", "unlisten", "close", "fetch", "move":
+		return UtilityQuery
+	default:
+		return WriteQuery
+	}
+}
+
+// hasSideEffects returns true if the words of a SELECT statement contain a locking clause,
+// INTO, which creates a table, or a function that changes a sequence.
+func hasSideEffects(words []string) bool {
+	for index, word := range words {
+		switch word {
+		case "into", "nextval", "setval":
+			return true
+		case "for":
+			if index+1 < len(words) {
+				switch words[index+1] {
+				case "update", "share", "no", "key":
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// combineQueryKinds returns the kind of a request or query from the kinds of its parts.
+func combineQueryKinds(kinds []QueryKind) QueryKind {
+	switch {
+	case slices.Contains(kinds, WriteQuery):
+		return WriteQuery
+	case len(kinds) > 0 && !slices.Contains(kinds, UtilityQuery):
+		return ReadOnlyQuery
+	default:
+		return UtilityQuery
+	}
+}
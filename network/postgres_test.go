@@ -0,0 +1,184 @@
+package network
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseStartupMessage tests the parameters of a StartupMessage.
+func TestParseStartupMessage(t *testing.T) {
+	startup := CreatePgStartupPacket()
+	assert.True(t, IsPostgresStartupMessage(startup))
+	assert.Equal(t,
+		map[string]string{
+			"user":             "postgres",
+			"database":         "postgres",
+			"application_name": "gatewayd",
+		},
+		ParseStartupMessage(startup))
+
+	sslRequest := []byte{0x00, 0x00, 0x00, 0x8, 0x04, 0xd2, 0x16, 0x2f}
+	assert.False(t, IsPostgresStartupMessage(sslRequest))
+	assert.Nil(t, ParseStartupMessage(sslRequest))
+	assert.Nil(t, ParseStartupMessage(CreatePgTerminatePacket()))
+}
+
+// TestCountReadyForQueryRequests tests counting the requests that are answered
+// with a ReadyForQuery message.
+func TestCountReadyForQueryRequests(t *testing.T) {
+	query := CreatePostgreSQLPacket(PostgresQuery, []byte("select 1;\x00"))
+	parse := CreatePostgreSQLPacket('P', []byte("\x00select 1\x00\x00\x00"))
+	sync := CreatePostgreSQLPacket(PostgresSync, nil)
+
+	assert.Equal(t, 1, CountReadyForQueryRequests(query))
+	assert.Equal(t, 0, CountReadyForQueryRequests(parse))
+	assert.Equal(t, 2, CountReadyForQueryRequests(bytes.Join([][]byte{query, parse, sync}, nil)))
+	// Partial messages are ignored.
+	assert.Equal(t, 0, CountReadyForQueryRequests(query[:len(query)-1]))
+
+	assert.False(t, HasTerminateMessage(query))
+	assert.True(t, HasTerminateMessage(append(query, CreatePgTerminatePacket()...)))
+}
+
+// TestReadyForQueryStatus tests the transaction status of the ReadyForQuery messages.
+func TestReadyForQueryStatus(t *testing.T) {
+	complete := CreatePostgreSQLPacket('C', []byte("BEGIN\x00"))
+	idle := CreatePostgreSQLPacket(PostgresReadyForQuery, []byte{PostgresTxIdle})
+	inTx := CreatePostgreSQLPacket(PostgresReadyForQuery, []byte{PostgresTxInTransaction})
+
+	count, _ := ReadyForQueryStatus(complete)
+	assert.Zero(t, count)
+
+	count, status := ReadyForQueryStatus(bytes.Join([][]byte{idle, complete, inTx}, nil))
+	assert.Equal(t, 2, count)
+	assert.Equal(t, PostgresTxInTransaction, status)
+}
+
+// TestResponseSummary tests that the rows are removed from the summary of a response.
+func TestResponseSummary(t *testing.T) {
+	description := CreatePostgreSQLPacket('T', []byte("\x00\x00"))
+	row := CreatePostgreSQLPacket(PostgresDataRow, []byte("\x00\x01\x00\x00\x00\x011"))
+	complete := CreatePostgreSQLPacket('C', []byte("SELECT 2\x00"))
+	idle := ReadyForQuery(PostgresTxIdle)
+
+	assert.Equal(t,
+		bytes.Join([][]byte{description, complete, idle}, nil),
+		ResponseSummary(bytes.Join([][]byte{description, row, row, complete, idle}, nil)))
+	assert.Nil(t, ResponseSummary(bytes.Join([][]byte{row, row}, nil)))
+	assert.Nil(t, ResponseSummary(CreatePostgreSQLPacket(PostgresCopyData, []byte("1\n"))))
+}
+
+// TestErrorResponse tests the encoding of an ErrorResponse message.
+func TestErrorResponse(t *testing.T) {
+	msg := ErrorResponse(PostgresSeverityFatal, "53300", "too many clients")
+	assert.Equal(t,
+		CreatePostgreSQLPacket(
+			PostgresErrorResponse,
+			[]byte("SFATAL\x00VFATAL\x00C53300\x00Mtoo many clients\x00\x00")),
+		msg)
+}
+
+// TestCancelRequest tests the encoding of the BackendKeyData and CancelRequest messages.
+func TestCancelRequest(t *testing.T) {
+	assert.Equal(t,
+		CreatePostgreSQLPacket(PostgresBackendKeyData, []byte{0, 0, 0x04, 0xd2, 0, 0, 0x16, 0x2e}),
+		BackendKeyData(1234, 5678))
+
+	request := CancelRequest(1234, 5678)
+	assert.Equal(t,
+		[]byte{0, 0, 0, 16, 0x04, 0xd2, 0x16, 0x2e, 0, 0, 0x04, 0xd2, 0, 0, 0x16, 0x2e},
+		request)
+	assert.True(t, IsPostgresCancelRequest(request))
+	processID, secretKey, ok := ParseCancelRequest(request)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1234), processID)
+	assert.Equal(t, uint32(5678), secretKey)
+
+	_, _, ok = ParseCancelRequest(CreatePgStartupPacket())
+	assert.False(t, ok)
+}
+
+// TestGatewayDErrorResponse tests the ErrorResponse messages of the GatewayD errors.
+func TestGatewayDErrorResponse(t *testing.T) {
+	assert.Equal(t,
+		ErrorResponse(PostgresSeverityFatal, "53300", "gatewayd: connection pool exhausted"),
+		GatewayDErrorResponse(gerr.ErrPoolExhausted))
+	assert.Nil(t, GatewayDErrorResponse(gerr.ErrClientNotConnected.Wrap(io.EOF)))
+
+	assert.Equal(t,
+		CreatePostgreSQLPacket(PostgresReadyForQuery, []byte{PostgresTxIdle}),
+		ReadyForQuery(PostgresTxIdle))
+}
+
+// TestClassifyQuery tests the classification of queries for read/write splitting.
+func TestClassifyQuery(t *testing.T) {
+	tests := map[string]QueryKind{
+		"SELECT 1": ReadOnlyQuery,
+		"  /* comment */ select * from t -- comment": ReadOnlyQuery,
+		"SELECT 1; SHOW search_path;":                ReadOnlyQuery,
+		"VALUES (1), (2)":                            ReadOnlyQuery,
+		"TABLE t":                                    ReadOnlyQuery,
+		"EXPLAIN SELECT * FROM t":                    ReadOnlyQuery,
+		"WITH a AS (SELECT 1) SELECT * FROM a":       ReadOnlyQuery,
+		"BEGIN READ ONLY":                            ReadOnlyQuery,
+		"START TRANSACTION ISOLATION LEVEL SERIALIZABLE, READ ONLY": ReadOnlyQuery,
+		"SELECT * FROM t FOR UPDATE":                                WriteQuery,
+		"SELECT * INTO t2 FROM t":                                   WriteQuery,
+		"SELECT nextval('s')":                                       WriteQuery,
+		"EXPLAIN ANALYZE DELETE FROM t":                             WriteQuery,
+		"WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d":     WriteQuery,
+		"INSERT INTO t VALUES (1)":                                  WriteQuery,
+		"SELECT 1; UPDATE t SET a = 1":                              WriteQuery,
+		"SELECT '--'; DELETE FROM t":                                WriteQuery,
+		"SET search_path TO public":                                 UtilityQuery,
+		"BEGIN":                                                     UtilityQuery,
+		"BEGIN READ WRITE":                                          UtilityQuery,
+		"COMMIT":                                                    UtilityQuery,
+		"SELECT 1; COMMIT":                                          UtilityQuery,
+		"":                                                          UtilityQuery,
+	}
+	for query, kind := range tests {
+		assert.Equal(t, kind, ClassifyQuery(query), query)
+	}
+}
+
+// TestClassifyRequest tests the classification of requests for read/write splitting.
+func TestClassifyRequest(t *testing.T) {
+	query := func(query string) []byte {
+		return QueryMessage(query)
+	}
+	parse := func(name, query string) []byte {
+		return CreatePostgreSQLPacket(PostgresParse, []byte(name+"\x00"+query+"\x00\x00\x00"))
+	}
+	bind := func(statement string) []byte {
+		return CreatePostgreSQLPacket(PostgresBind, []byte("\x00"+statement+"\x00\x00\x00\x00\x00\x00\x00"))
+	}
+	execute := CreatePostgreSQLPacket(PostgresExecute, []byte("\x00\x00\x00\x00\x00"))
+	sync := CreatePostgreSQLPacket(PostgresSync, nil)
+
+	assert.Equal(t, ReadOnlyQuery, ClassifyRequest(query("SELECT 1")))
+	assert.Equal(t, WriteQuery, ClassifyRequest(query("DELETE FROM t")))
+	assert.Equal(t, ReadOnlyQuery, ClassifyRequest(
+		bytes.Join([][]byte{parse("", "SELECT $1"), bind(""), execute, sync}, nil)))
+	assert.Equal(t, WriteQuery, ClassifyRequest(
+		bytes.Join([][]byte{parse("s1", "UPDATE t SET a = $1"), bind("s1"), execute, sync}, nil)))
+	// The statement is parsed in an earlier request, so it might be a write.
+	assert.Equal(t, WriteQuery, ClassifyRequest(
+		bytes.Join([][]byte{bind("s1"), execute, sync}, nil)))
+	assert.Equal(t, WriteQuery, ClassifyRequest(
+		CreatePostgreSQLPacket(PostgresFunctionCall, []byte{0, 0, 0, 1})))
+	assert.Equal(t, UtilityQuery, ClassifyRequest(sync))
+}
+
+// TestDataRowValues tests the decoding of the values of a DataRow message.
+func TestDataRowValues(t *testing.T) {
+	values := DataRowValues([]byte{0, 2, 0, 0, 0, 3, '1', '.', '5', 0xff, 0xff, 0xff, 0xff})
+	assert.Equal(t, [][]byte{[]byte("1.5"), nil}, values)
+
+	assert.Equal(t, "missing",
+		ErrorResponseMessage(ErrorResponse(PostgresSeverityError, "42P01", "missing")[PostgresMessageHeaderSize:]))
+}
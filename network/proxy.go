@@ -1,11 +1,14 @@
 package network
 
 import (
-	"bytes"
+	"container/list"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
@@ -20,13 +23,23 @@ import (
 	"go.opentelemetry.io/otel"
 )
 
+// closeClientTimeout is how long the error that is sent to a client
+// before closing its connection can take to be written.
+const closeClientTimeout = time.Second
+
 type IProxy interface {
 	Connect(conn *ConnWrapper) *gerr.GatewayDError
 	Disconnect(conn *ConnWrapper) *gerr.GatewayDError
-	PassThroughToServer(conn *ConnWrapper, stack *Stack) *gerr.GatewayDError
-	PassThroughToClient(conn *ConnWrapper, stack *Stack) *gerr.GatewayDError
+	Authenticate(conn *ConnWrapper) *gerr.GatewayDError
+	PassThroughToServer(conn *ConnWrapper, correlator *Correlator) *gerr.GatewayDError
+	PassThroughToClient(conn *ConnWrapper, correlator *Correlator) *gerr.GatewayDError
+	SendError(conn *ConnWrapper, err *gerr.GatewayDError)
 	IsHealthy(cl *Client) (*Client, *gerr.GatewayDError)
 	IsExhausted() bool
+	IsDetached(conn *ConnWrapper) bool
+	Drain() int
+	CloseIdle(conn *ConnWrapper, timeout time.Duration) bool
+	TrackActivity()
 	Shutdown()
 	AvailableConnections() []string
 	BusyConnections() []string
@@ -35,15 +48,80 @@ type IProxy interface {
 type Proxy struct {
 	availableConnections pool.IPool
 	busyConnections      pool.IPool
+	sessions             pool.IPool
 	logger               zerolog.Logger
 	pluginRegistry       *plugin.Registry
 	scheduler            *gocron.Scheduler
 	ctx                  context.Context //nolint:containedctx
 	pluginTimeout        time.Duration
 
+	// mu guards the selection of server connections in transaction pooling mode,
+	// along with the number of server connections and sessions per session key.
+	mu          sync.Mutex
+	released    chan struct{}
+	keyClients  map[string]int
+	keySessions map[string]int
+	// waiters is the queue of incoming connections that wait for a server connection
+	// when the pool is exhausted. It is also guarded by mu.
+	waiters *list.List
+	// borrowers is the number of sessions that wait for a server connection to be
+	// released in transaction pooling mode. It is also guarded by mu.
+	borrowers int
+	// nextReplica is the index of the replica that receives the next read-only
+	// transaction. It is also guarded by mu.
+	nextReplica int
+	// authFailures are the session keys that the replica refuses to start a session
+	// for. It is also guarded by mu.
+	authFailures map[string]bool
+	// lagging is set on a replica while its replication lag is greater than the maximum.
+	lagging atomic.Bool
+	// healthCheckResults are the results of the last health check. They are also guarded by mu.
+	healthCheckResults HealthCheckResults
+	// retired is the number of clients that were closed by retireClients and can be opened
+	// again when the pool is exhausted, unless the proxy is elastic. It is also guarded by mu.
+	retired int
+	// draining is set by Drain, so that the sessions are disconnected once their transaction ends.
+	draining atomic.Bool
+	// trackActivity is set by TrackActivity, so that the traffic is never spliced.
+	trackActivity atomic.Bool
+
+	// Name is the name of the proxy in the config, which is used in the metrics.
+	Name                string
 	Elastic             bool
 	ReuseElasticClients bool
 	HealthCheckPeriod   time.Duration
+	HealthCheckType     config.HealthCheckType
+	HealthCheckQuery    string
+	HealthCheckTimeout  time.Duration
+	// ResetQuery is run on the server connection of a session that is disconnected, so
+	// that the connection is reused instead of reconnecting. It is disabled if empty.
+	ResetQuery string
+	// AuthType is the method of the clients that are authenticated by the proxy, with the
+	// passwords of the AuthFile or the AuthQuery, instead of the server.
+	AuthType  config.AuthType
+	AuthFile  string
+	AuthQuery string
+	// MaxPreparedStatements is the maximum number of statements of the sessions that are
+	// prepared on each server connection in transaction pooling mode. 0 disables the tracking.
+	MaxPreparedStatements int
+	// CopyHookSampling runs the traffic hooks on every Nth batch of COPY data instead of
+	// skipping them. 0 skips the traffic hooks for all the batches of COPY data.
+	CopyHookSampling int
+	// DisableSplice makes the proxy read the traffic of the sessions, even if nothing needs it.
+	DisableSplice  bool
+	PoolMode       config.PoolMode
+	MaxWaitTime    time.Duration
+	MaxQueueLength int
+	// Replicas are the proxies of the read replicas, which receive the read-only
+	// transactions in transaction pooling mode. They are added by AddReplica.
+	Replicas        []*Proxy
+	MaxReplicaLag   time.Duration
+	ReplicaLagCheck time.Duration
+	// MaxLifetime and MaxIdleTime retire the idle clients that are older or idle for longer,
+	// while keeping at least MinIdle idle clients. They are set from the pool config.
+	MaxLifetime time.Duration
+	MaxIdleTime time.Duration
+	MinIdle     int
 
 	// ClientConfig is used for elastic proxy and reconnection
 	ClientConfig *config.Client
@@ -51,12 +129,20 @@ type Proxy struct {
 
 var _ IProxy = (*Proxy)(nil)
 
+// HealthCheckResults are the results of the last health check of the idle server connections.
+type HealthCheckResults struct {
+	Healthy   int
+	Failed    int
+	Replaced  int
+	LastCheck time.Time
+}
+
 // NewProxy creates a new proxy.
 func NewProxy(
 	ctx context.Context,
-	connPool pool.IPool, pluginRegistry *plugin.Registry,
-	elastic, reuseElasticClients bool,
-	healthCheckPeriod time.Duration,
+	connPool pool.IPool, poolConfig *config.Pool,
+	pluginRegistry *plugin.Registry,
+	proxyConfig *config.Proxy,
 	clientConfig *config.Client, logger zerolog.Logger,
 	pluginTimeout time.Duration,
 ) *Proxy {
@@ -64,17 +150,52 @@ func NewProxy(
 	defer span.End()
 
 	proxy := Proxy{
-		availableConnections: connPool,
-		busyConnections:      pool.NewPool(proxyCtx, config.EmptyPoolCapacity),
-		logger:               logger,
-		pluginRegistry:       pluginRegistry,
-		scheduler:            gocron.NewScheduler(time.UTC),
-		ctx:                  proxyCtx,
-		pluginTimeout:        pluginTimeout,
-		Elastic:              elastic,
-		ReuseElasticClients:  reuseElasticClients,
-		ClientConfig:         clientConfig,
-		HealthCheckPeriod:    healthCheckPeriod,
+		availableConnections:  connPool,
+		busyConnections:       pool.NewPool(proxyCtx, config.EmptyPoolCapacity),
+		sessions:              pool.NewPool(proxyCtx, config.EmptyPoolCapacity),
+		logger:                logger,
+		pluginRegistry:        pluginRegistry,
+		scheduler:             gocron.NewScheduler(time.UTC),
+		ctx:                   proxyCtx,
+		pluginTimeout:         pluginTimeout,
+		released:              make(chan struct{}),
+		keyClients:            map[string]int{},
+		keySessions:           map[string]int{},
+		waiters:               list.New(),
+		authFailures:          map[string]bool{},
+		Elastic:               proxyConfig.Elastic,
+		ReuseElasticClients:   proxyConfig.ReuseElasticClients,
+		ClientConfig:          clientConfig,
+		HealthCheckPeriod:     proxyConfig.HealthCheckPeriod,
+		HealthCheckType:       proxyConfig.GetHealthCheckType(),
+		HealthCheckQuery:      proxyConfig.HealthCheckQuery,
+		ResetQuery:            proxyConfig.ResetQuery,
+		AuthType:              proxyConfig.GetAuthType(),
+		AuthFile:              proxyConfig.AuthFile,
+		AuthQuery:             proxyConfig.AuthQuery,
+		MaxPreparedStatements: proxyConfig.MaxPreparedStatements,
+		CopyHookSampling:      proxyConfig.CopyHookSampling,
+		DisableSplice:         proxyConfig.DisableSplice,
+		PoolMode:              proxyConfig.GetPoolMode(),
+		HealthCheckTimeout: config.If[time.Duration](
+			proxyConfig.HealthCheckTimeout > 0,
+			proxyConfig.HealthCheckTimeout,
+			config.DefaultHealthCheckTimeout,
+		),
+		MaxWaitTime: config.If[time.Duration](
+			proxyConfig.MaxWaitTime > 0,
+			proxyConfig.MaxWaitTime,
+			config.DefaultMaxWaitTime,
+		),
+		MaxQueueLength:  proxyConfig.MaxQueueLength,
+		MaxReplicaLag:   proxyConfig.MaxReplicaLag,
+		ReplicaLagCheck: proxyConfig.ReplicaLagCheck,
+	}
+
+	if poolConfig != nil {
+		proxy.MaxLifetime = poolConfig.MaxLifetime
+		proxy.MaxIdleTime = poolConfig.MaxIdleTime
+		proxy.MinIdle = poolConfig.MinIdle
 	}
 
 	startDelay := time.Now().Add(proxy.HealthCheckPeriod)
@@ -83,38 +204,7 @@ func NewProxy(
 		func() {
 			now := time.Now()
 			logger.Trace().Msg("Running the client health check to recycle connection(s).")
-			proxy.availableConnections.ForEach(func(_, value interface{}) bool {
-				if client, ok := value.(*Client); ok {
-					// Connection is probably dead by now.
-					proxy.availableConnections.Remove(client.ID)
-					client.Close()
-					// Create a new client.
-					client = NewClient(
-						proxyCtx, proxy.ClientConfig, proxy.logger,
-						NewRetry(
-							proxy.ClientConfig.Retries,
-							config.If[time.Duration](
-								proxy.ClientConfig.Backoff > 0,
-								proxy.ClientConfig.Backoff,
-								config.DefaultBackoff,
-							),
-							proxy.ClientConfig.BackoffMultiplier,
-							proxy.ClientConfig.DisableBackoffCaps,
-							proxy.logger,
-						),
-					)
-					if client != nil && client.ID != "" {
-						if err := proxy.availableConnections.Put(client.ID, client); err != nil {
-							proxy.logger.Err(err).Msg("Failed to update the client connection")
-							// Close the client, because we don't want to have orphaned connections.
-							client.Close()
-						}
-					} else {
-						proxy.logger.Error().Msg("Failed to create a new client connection")
-					}
-				}
-				return true
-			})
+			proxy.checkClients()
 			logger.Trace().Str("duration", time.Since(now).String()).Msg(
 				"Finished the client health check")
 			metrics.ProxyHealthChecks.Inc()
@@ -125,6 +215,17 @@ func NewProxy(
 		span.RecordError(err)
 	}
 
+	if proxy.MaxLifetime > 0 || proxy.MaxIdleTime > 0 {
+		// Schedule the retirement of the old and idle clients.
+		if _, err := proxy.scheduler.Every(config.DefaultRetireCheckPeriod).SingletonMode().Do(
+			proxy.retireClients,
+		); err != nil {
+			proxy.logger.Error().Err(err).Msg("Failed to schedule the retirement of the clients")
+			sentry.CaptureException(err)
+			span.RecordError(err)
+		}
+	}
+
 	// Start the scheduler.
 	proxy.scheduler.StartAsync()
 	logger.Info().Fields(
@@ -144,45 +245,44 @@ func (pr *Proxy) Connect(conn *ConnWrapper) *gerr.GatewayDError {
 	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "Connect")
 	defer span.End()
 
-	var clientID string
-	// Get the first available client from the pool.
-	pr.availableConnections.ForEach(func(key, _ interface{}) bool {
-		if cid, ok := key.(string); ok {
-			clientID = cid
-			return false // stop the loop.
-		}
-		return true
-	})
-
 	var client *Client
-	if pr.IsExhausted() {
-		// Pool is exhausted or is elastic.
-		if pr.Elastic {
-			// Create a new client.
-			client = NewClient(
-				pr.ctx, pr.ClientConfig, pr.logger,
-				NewRetry(
-					pr.ClientConfig.Retries,
-					config.If[time.Duration](
-						pr.ClientConfig.Backoff > 0,
-						pr.ClientConfig.Backoff,
-						config.DefaultBackoff,
-					),
-					pr.ClientConfig.BackoffMultiplier,
-					pr.ClientConfig.DisableBackoffCaps,
-					pr.logger,
-				),
-			)
+	var queueErr *gerr.GatewayDError
+	switch {
+	case pr.hasWaiters():
+		// Other incoming connections are waiting for a server connection,
+		// so wait in the queue after them.
+		if client, queueErr = pr.waitInQueue(); queueErr != nil {
+			span.RecordError(queueErr)
+			return queueErr
+		}
+	default:
+		// The client authenticates itself with the server, so it needs a server
+		// connection that is not authenticated for another user or database yet.
+		client = pr.popAvailableClient()
+		if client == nil {
+			if !pr.Elastic {
+				if client, queueErr = pr.waitInQueue(); queueErr != nil {
+					span.RecordError(queueErr)
+					return queueErr
+				}
+				break
+			}
+			if client = pr.newClientFor(conn); client == nil {
+				span.RecordError(gerr.ErrClientConnectionFailed)
+				return gerr.ErrClientConnectionFailed
+			}
 			span.AddEvent("Created a new client connection")
-			pr.logger.Debug().Str("id", client.ID[:7]).Msg("Reused the client connection")
-		} else {
-			span.AddEvent(gerr.ErrPoolExhausted.Error())
-			return gerr.ErrPoolExhausted
 		}
-	} else {
-		// Get the client from the pool with the given clientID.
-		if cl, ok := pr.availableConnections.Pop(clientID).(*Client); ok {
-			client = cl
+	}
+
+	if pr.HealthCheckType != config.ReconnectHealthCheck && client != nil && client.sessionKey == "" {
+		// The server might have closed the idle connection since the last health check.
+		if err := client.Probe(); err != nil {
+			pr.logger.Debug().Err(err).Msg("Reconnecting the client that failed the probe")
+			if err := client.Reconnect(); err != nil {
+				pr.logger.Error().Err(err).Msg("Failed to reconnect to the client")
+				span.RecordError(err)
+			}
 		}
 	}
 
@@ -192,6 +292,14 @@ func (pr *Proxy) Connect(conn *ConnWrapper) *gerr.GatewayDError {
 		span.RecordError(err)
 	}
 
+	session := NewSession()
+	session.attach(client)
+	if err := pr.sessions.Put(conn, session); err != nil {
+		// This should never happen.
+		span.RecordError(err)
+		return err
+	}
+
 	if err := pr.busyConnections.Put(conn, client); err != nil {
 		// This should never happen.
 		span.RecordError(err)
@@ -232,6 +340,14 @@ func (pr *Proxy) Disconnect(conn *ConnWrapper) *gerr.GatewayDError {
 	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "Disconnect")
 	defer span.End()
 
+	session, ok := pr.sessions.Pop(conn).(*Session)
+	if ok {
+		session.Close()
+		if pr.PoolMode == config.Transaction {
+			return pr.disconnectSession(conn, session)
+		}
+	}
+
 	client := pr.busyConnections.Pop(conn)
 	if client == nil {
 		// If this ever happens, it means that the client connection
@@ -244,16 +360,16 @@ func (pr *Proxy) Disconnect(conn *ConnWrapper) *gerr.GatewayDError {
 	//nolint:nestif
 	if client, ok := client.(*Client); ok {
 		if (pr.Elastic && pr.ReuseElasticClients) || !pr.Elastic {
-			// Recycle the server connection by reconnecting.
-			if err := client.Reconnect(); err != nil {
-				pr.logger.Error().Err(err).Msg("Failed to reconnect to the client")
-				span.RecordError(err)
-			}
-
-			// If the client is not in the pool, put it back.
-			if err := pr.availableConnections.Put(client.ID, client); err != nil {
-				pr.logger.Error().Err(err).Msg("Failed to put the client back in the pool")
-				span.RecordError(err)
+			// Recycle the server connection by resetting it, and put it back in the pool
+			// as authenticated for the session key, or by reconnecting if the reset fails.
+			if session != nil && pr.resetClient(client, session) {
+				pr.releaseClient(client, session.key)
+			} else {
+				if err := client.Reconnect(); err != nil {
+					pr.logger.Error().Err(err).Msg("Failed to reconnect to the client")
+					span.RecordError(err)
+				}
+				pr.releaseClient(client, "")
 			}
 		} else {
 			span.RecordError(gerr.ErrClientNotConnected)
@@ -286,137 +402,124 @@ func (pr *Proxy) Disconnect(conn *ConnWrapper) *gerr.GatewayDError {
 }
 
 // PassThroughToServer sends the data from the client to the server.
-func (pr *Proxy) PassThroughToServer(conn *ConnWrapper, stack *Stack) *gerr.GatewayDError {
+func (pr *Proxy) PassThroughToServer(conn *ConnWrapper, correlator *Correlator) *gerr.GatewayDError {
 	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "PassThrough")
 	defer span.End()
 
-	var client *Client
-	// Check if the proxy has a egress client for the incoming connection.
-	if pr.busyConnections.Get(conn) == nil {
+	session, ok := pr.sessions.Get(conn).(*Session)
+	if !ok {
 		span.RecordError(gerr.ErrClientNotFound)
 		return gerr.ErrClientNotFound
 	}
 
-	// Get the client from the busy connection pool.
-	if cl, ok := pr.busyConnections.Get(conn).(*Client); ok {
-		client = cl
-	} else {
-		span.RecordError(gerr.ErrCastFailed)
-		return gerr.ErrCastFailed
-	}
-	span.AddEvent("Got the client from the busy connection pool")
+	var client *Client
+	if pr.PoolMode != config.Transaction {
+		// Get the client from the busy connection pool.
+		var err *gerr.GatewayDError
+		if client, err = pr.getClient(conn); err != nil {
+			span.RecordError(err)
+			return err
+		}
+		span.AddEvent("Got the client from the busy connection pool")
 
-	if !client.IsConnected() {
-		return gerr.ErrClientNotConnected
+		// Without anything to inspect the traffic, let the kernel copy it.
+		if pr.canSplice(session, client) {
+			return pr.spliceToServer(conn, client)
+		}
 	}
 
 	// Receive the request from the client.
-	request, origErr := pr.receiveTrafficFromClient(conn.Conn())
+	startup := conn.Framer().IsStartup()
+	request, origErr := pr.receiveTrafficFromClient(conn)
 	span.AddEvent("Received traffic from client")
-
-	// Run the OnTrafficFromClient hooks.
-	pluginTimeoutCtx, cancel := context.WithTimeout(context.Background(), pr.pluginTimeout)
-	defer cancel()
-
-	result, err := pr.pluginRegistry.Run(
-		pluginTimeoutCtx,
-		trafficData(
-			conn.Conn(),
-			client,
-			[]Field{
-				{
-					Name:  "request",
-					Value: request,
-				},
-			},
-			origErr),
-		v1.HookName_HOOK_NAME_ON_TRAFFIC_FROM_CLIENT)
-	if err != nil {
-		pr.logger.Error().Err(err).Msg("Error running hook")
-		span.RecordError(err)
+	if !startup {
+		// The StartupMessage is kept by the session, and the rest is copied
+		// by the hooks and the correlator, so the buffer is reused afterwards.
+		defer releaseBuffer(request)
 	}
-	span.AddEvent("Ran the OnTrafficFromClient hooks")
 
-	if origErr != nil && errors.Is(origErr, io.EOF) {
-		// Client closed the connection.
+	if pr.PoolMode != config.Transaction && pr.resetsClient(client) &&
+		origErr == nil && HasTerminateMessage(request) {
+		// The server connection is reset for the next session, so the
+		// client closing the connection must not close it.
 		span.AddEvent("Client closed the connection")
-		return gerr.ErrClientNotConnected.Wrap(origErr)
+		return gerr.ErrClientNotConnected
 	}
 
-	// Check if the client sent a SSL request and the server supports SSL.
-	//nolint:nestif
-	if conn.IsTLSEnabled() && IsPostgresSSLRequest(request) {
-		// Perform TLS handshake.
-		if err := conn.UpgradeToTLS(func(c net.Conn) {
-			// Acknowledge the SSL request:
-			// https://www.postgresql.org/docs/current/protocol-flow.html#PROTOCOL-FLOW-SSL
-			if sent, err := conn.Write([]byte{'S'}); err != nil {
-				pr.logger.Error().Err(err).Msg("Failed to acknowledge the SSL request")
-				span.RecordError(err)
-			} else {
-				pr.logger.Debug().Fields(
-					map[string]interface{}{
-						"function": "upgradeToTLS",
-						"local":    LocalAddr(conn.Conn()),
-						"remote":   RemoteAddr(conn.Conn()),
-						"length":   sent,
-					},
-				).Msg("Sent data to database")
+	if pr.PoolMode == config.Transaction {
+		if origErr != nil || HasTerminateMessage(request) {
+			// The server connection is shared between the sessions, so the client
+			// closing the connection must not close the server connection.
+			span.AddEvent("Client closed the connection")
+			if origErr != nil {
+				return gerr.ErrClientNotConnected.Wrap(origErr)
 			}
-		}); err != nil {
-			pr.logger.Error().Err(err).Msg("Failed to perform the TLS handshake")
+			return gerr.ErrClientNotConnected
+		}
+
+		// Get a server connection for the session, waiting for one to be released
+		// if there is no server connection attached to the session.
+		var err *gerr.GatewayDError
+		if client, err = pr.acquireClient(conn, session, request); err != nil {
 			span.RecordError(err)
+			return err
 		}
+		defer session.finishRequest()
+		span.AddEvent("Acquired a client for the transaction")
+	}
 
-		// Check if the TLS handshake was successful.
-		if conn.IsTLSEnabled() {
-			pr.logger.Debug().Fields(
-				map[string]interface{}{
-					"local":  LocalAddr(conn.Conn()),
-					"remote": RemoteAddr(conn.Conn()),
-				},
-			).Msg("Performed the TLS handshake")
-			span.AddEvent("Performed the TLS handshake")
-			metrics.TLSConnections.Inc()
-		} else {
-			pr.logger.Error().Fields(
-				map[string]interface{}{
-					"local":  LocalAddr(conn.Conn()),
-					"remote": RemoteAddr(conn.Conn()),
-				},
-			).Msg("Failed to perform the TLS handshake")
-			span.AddEvent("Failed to perform the TLS handshake")
+	if startup {
+		if parameters := ParseStartupMessage(request); parameters != nil {
+			key := session.setStartupParameters(parameters, request)
+			if pr.PoolMode == config.Transaction {
+				pr.mu.Lock()
+				pr.keySessions[key]++
+				pr.mu.Unlock()
+			}
 		}
+	}
 
-		// This return causes the client to start sending
-		// StartupMessage over the TLS connection.
-		return nil
-	} else if !conn.IsTLSEnabled() && IsPostgresSSLRequest(request) {
-		// Client sent a SSL request, but the server does not support SSL.
+	// The traffic hooks are skipped for the COPY data, unless the batch is sampled.
+	skipHooks := origErr == nil && session.skipCopyHooks(request, pr.CopyHookSampling)
 
-		pr.logger.Warn().Fields(
-			map[string]interface{}{
-				"local":  LocalAddr(conn.Conn()),
-				"remote": RemoteAddr(conn.Conn()),
-			},
-		).Msg("Server does not support SSL, but SSL was requested by the client")
-		span.AddEvent("Server does not support SSL, but SSL was requested by the client")
-
-		// Server does not support SSL, and SSL was preferred by the client,
-		// so we need to switch to a plaintext connection:
-		// https://www.postgresql.org/docs/current/protocol-flow.html#PROTOCOL-FLOW-SSL
-		if _, err := conn.Write([]byte{'N'}); err != nil {
-			pr.logger.Warn().Err(err).Msg("Server does not support SSL, but SSL was required by the client")
+	// Run the OnTrafficFromClient hooks.
+	var result map[string]interface{}
+	var err *gerr.GatewayDError
+	if !skipHooks && pr.pluginRegistry.HasHooks(v1.HookName_HOOK_NAME_ON_TRAFFIC_FROM_CLIENT) {
+		pluginTimeoutCtx, cancel := context.WithTimeout(context.Background(), pr.pluginTimeout)
+		defer cancel()
+
+		result, err = pr.pluginRegistry.Run(
+			pluginTimeoutCtx,
+			trafficData(
+				conn.Conn(),
+				client,
+				[]Field{
+					{
+						Name:  "request",
+						Value: request,
+					},
+				},
+				origErr),
+			v1.HookName_HOOK_NAME_ON_TRAFFIC_FROM_CLIENT)
+		if err != nil {
+			pr.logger.Error().Err(err).Msg("Error running hook")
 			span.RecordError(err)
 		}
+		span.AddEvent("Ran the OnTrafficFromClient hooks")
+	}
 
-		// This return causes the client to start sending
-		// StartupMessage over the plaintext connection.
-		return nil
+	if origErr != nil && errors.Is(origErr, io.EOF) {
+		// Client closed the connection.
+		span.AddEvent("Client closed the connection")
+		return gerr.ErrClientNotConnected.Wrap(origErr)
 	}
 
-	// Push the client's request to the stack.
-	stack.Push(&Request{Data: request})
+	// Answer the SSLRequest and GSSENCRequest of the client. This return causes the client
+	// to start sending the StartupMessage over the TLS or plaintext connection.
+	if negotiateEncryption(pr.ctx, conn, request, pr.logger) {
+		return nil
+	}
 
 	// If the hook wants to terminate the connection, do it.
 	if pr.shouldTerminate(result) {
@@ -428,9 +531,6 @@ func (pr *Proxy) PassThroughToServer(conn *ConnWrapper, stack *Stack) *gerr.Gate
 
 			span.AddEvent("Terminating connection")
 
-			// Remove the request from the stack if the response is modified.
-			stack.PopLastRequest()
-
 			return pr.sendTrafficToClient(conn.Conn(), modResponse, modReceived)
 		}
 		span.RecordError(gerr.ErrHookTerminatedConnection)
@@ -442,34 +542,50 @@ func (pr *Proxy) PassThroughToServer(conn *ConnWrapper, stack *Stack) *gerr.Gate
 		span.AddEvent("Plugin(s) modified the request")
 	}
 
-	stack.UpdateLastRequest(&Request{Data: request})
+	// Queue the request before sending it, so that the responses are paired with it.
+	correlator.Push(request)
+
+	// Count the requests that are answered with ReadyForQuery before sending them,
+	// so that the session is never considered idle while they are in flight.
+	session.trackRequest(request)
+	session.trackCopyRequest(request)
+	if HasTerminateMessage(request) {
+		session.terminate()
+	}
+
+	if pr.PoolMode == config.Transaction && pr.MaxPreparedStatements > 0 && !startup {
+		// The server connection might not have seen the statements prepared by the session.
+		request = client.statements.rewriteRequest(session.statements, request, pr.MaxPreparedStatements)
+	}
 
 	// Send the request to the server.
 	_, err = pr.sendTrafficToServer(client, request)
 	span.AddEvent("Sent traffic to server")
 
-	pluginTimeoutCtx, cancel = context.WithTimeout(context.Background(), pr.pluginTimeout)
-	defer cancel()
-
-	// Run the OnTrafficToServer hooks.
-	_, err = pr.pluginRegistry.Run(
-		pluginTimeoutCtx,
-		trafficData(
-			conn.Conn(),
-			client,
-			[]Field{
-				{
-					Name:  "request",
-					Value: request,
+	if !skipHooks && pr.pluginRegistry.HasHooks(v1.HookName_HOOK_NAME_ON_TRAFFIC_TO_SERVER) {
+		pluginTimeoutCtx, cancel := context.WithTimeout(context.Background(), pr.pluginTimeout)
+		defer cancel()
+
+		// Run the OnTrafficToServer hooks.
+		_, err = pr.pluginRegistry.Run(
+			pluginTimeoutCtx,
+			trafficData(
+				conn.Conn(),
+				client,
+				[]Field{
+					{
+						Name:  "request",
+						Value: request,
+					},
 				},
-			},
-			err),
-		v1.HookName_HOOK_NAME_ON_TRAFFIC_TO_SERVER)
-	if err != nil {
-		pr.logger.Error().Err(err).Msg("Error running hook")
-		span.RecordError(err)
+				err),
+			v1.HookName_HOOK_NAME_ON_TRAFFIC_TO_SERVER)
+		if err != nil {
+			pr.logger.Error().Err(err).Msg("Error running hook")
+			span.RecordError(err)
+		}
+		span.AddEvent("Ran the OnTrafficToServer hooks")
 	}
-	span.AddEvent("Ran the OnTrafficToServer hooks")
 
 	metrics.ProxyPassThroughsToServer.Inc()
 
@@ -477,33 +593,53 @@ func (pr *Proxy) PassThroughToServer(conn *ConnWrapper, stack *Stack) *gerr.Gate
 }
 
 // PassThroughToClient sends the data from the server to the client.
-func (pr *Proxy) PassThroughToClient(conn *ConnWrapper, stack *Stack) *gerr.GatewayDError {
+func (pr *Proxy) PassThroughToClient(conn *ConnWrapper, correlator *Correlator) *gerr.GatewayDError {
 	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "PassThrough")
 	defer span.End()
 
-	var client *Client
-	// Check if the proxy has a egress client for the incoming connection.
-	if pr.busyConnections.Get(conn) == nil {
+	session, ok := pr.sessions.Get(conn).(*Session)
+	if !ok {
 		span.RecordError(gerr.ErrClientNotFound)
 		return gerr.ErrClientNotFound
 	}
 
-	// Get the client from the busy connection pool.
-	if cl, ok := pr.busyConnections.Get(conn).(*Client); ok {
-		client = cl
+	var client *Client
+	var err *gerr.GatewayDError
+	if pr.PoolMode == config.Transaction {
+		// Wait until a server connection is attached to the session.
+		client, err = pr.waitForClient(session)
 	} else {
-		span.RecordError(gerr.ErrCastFailed)
-		return gerr.ErrCastFailed
+		// Get the client from the busy connection pool.
+		client, err = pr.getClient(conn)
+	}
+	if err != nil {
+		span.RecordError(err)
+		return err
 	}
 	span.AddEvent("Got the client from the busy connection pool")
 
-	if !client.IsConnected() {
-		return gerr.ErrClientNotConnected
+	// Without anything to inspect the traffic, let the kernel copy it.
+	if pr.canSplice(session, client) {
+		return pr.spliceToClient(conn, client)
 	}
 
 	// Receive the response from the server.
 	received, response, err := pr.receiveTrafficFromServer(client)
 	span.AddEvent("Received traffic from server")
+	defer releaseBuffer(response)
+	serverResponse := response[:received]
+
+	// Give the client the key of the session instead of the key of the server connection.
+	session.mapBackendKeyData(client, serverResponse)
+
+	if pr.PoolMode == config.Transaction && pr.MaxPreparedStatements > 0 && err == nil {
+		// Remove the answers to the statements that were prepared by GatewayD.
+		serverResponse = client.statements.filterResponse(serverResponse)
+		if len(serverResponse) == 0 {
+			return nil
+		}
+		response, received = serverResponse, len(serverResponse)
+	}
 
 	// If the response is empty, don't send anything, instead just close the ingress connection.
 	if received == 0 || err != nil {
@@ -518,92 +654,123 @@ func (pr *Proxy) PassThroughToClient(conn *ConnWrapper, stack *Stack) *gerr.Gate
 		span.AddEvent("No data to send to client")
 		span.RecordError(err)
 
-		stack.PopLastRequest()
-
 		return err
 	}
 
-	pluginTimeoutCtx, cancel := context.WithTimeout(context.Background(), pr.pluginTimeout)
-	defer cancel()
+	// Pair each part of the response with the request that it answers,
+	// since the client might have sent several requests at once.
+	exchanges := correlator.Match(response[:received])
+
+	// Run the OnTrafficFromServer hooks for each request, except for the COPY data.
+	skipHooks := make([]bool, len(exchanges))
+	var copyEvents []copyEvent
+	modified := false
+	for i, exchange := range exchanges {
+		copyEvents = append(copyEvents, session.trackCopyResponse(exchange)...)
+		if skipHooks[i] = session.skipCopyHooks(exchange.Response, pr.CopyHookSampling); skipHooks[i] {
+			continue
+		}
 
-	// Get the last request from the stack.
-	lastRequest := stack.PopLastRequest()
-	request := make([]byte, 0)
-	if lastRequest != nil {
-		request = lastRequest.Data
-	}
-
-	// Run the OnTrafficFromServer hooks.
-	result, err := pr.pluginRegistry.Run(
-		pluginTimeoutCtx,
-		trafficData(
-			conn.Conn(),
-			client,
-			[]Field{
-				{
-					Name:  "request",
-					Value: request,
-				},
-				{
-					Name:  "response",
-					Value: response[:received],
-				},
-			},
-			err),
-		v1.HookName_HOOK_NAME_ON_TRAFFIC_FROM_SERVER)
-	if err != nil {
-		pr.logger.Error().Err(err).Msg("Error running hook")
-		span.RecordError(err)
-	}
-	span.AddEvent("Ran the OnTrafficFromServer hooks")
+		result := pr.runResponseHooks(conn, client, exchange, v1.HookName_HOOK_NAME_ON_TRAFFIC_FROM_SERVER)
 
-	// If the hook modified the response, use the modified response.
-	if modResponse, modReceived := pr.getPluginModifiedResponse(result); modResponse != nil {
-		response = modResponse
-		received = modReceived
-		span.AddEvent("Plugin(s) modified the response")
+		// If the hook modified the response, use the modified response.
+		if modResponse, modReceived := pr.getPluginModifiedResponse(result); modResponse != nil {
+			exchanges[i].Response = modResponse[:modReceived]
+			modified = true
+			span.AddEvent("Plugin(s) modified the response")
+		}
+	}
+	if modified {
+		// Put the parts of the response back together, with the modified ones.
+		response = make([]byte, 0, received)
+		for _, exchange := range exchanges {
+			response = append(response, exchange.Response...)
+		}
+		received = len(response)
 	}
+	span.AddEvent("Ran the OnTrafficFromServer hooks")
 
 	// Send the response to the client.
 	errVerdict := pr.sendTrafficToClient(conn.Conn(), response, received)
 	span.AddEvent("Sent traffic to client")
 
-	// Run the OnTrafficToClient hooks.
-	pluginTimeoutCtx, cancel = context.WithTimeout(context.Background(), pr.pluginTimeout)
-	defer cancel()
+	// Run the OnTrafficToClient hooks for each request, except for the COPY data.
+	for i, exchange := range exchanges {
+		if !skipHooks[i] {
+			pr.runResponseHooks(conn, client, exchange, v1.HookName_HOOK_NAME_ON_TRAFFIC_TO_CLIENT)
+		}
+	}
 
-	_, err = pr.pluginRegistry.Run(
-		pluginTimeoutCtx,
-		trafficData(
-			conn.Conn(),
-			client,
-			[]Field{
-				{
-					Name:  "request",
-					Value: request,
-				},
-				{
-					Name:  "response",
-					Value: response[:received],
-				},
-			},
-			nil,
-		),
-		v1.HookName_HOOK_NAME_ON_TRAFFIC_TO_CLIENT)
-	if err != nil {
-		pr.logger.Error().Err(err).Msg("Error running hook")
-		span.RecordError(err)
+	// Notify the plugins of the COPY operations that started or ended.
+	for _, event := range copyEvents {
+		pr.runCopyHooks(conn, client, event)
 	}
 
 	if errVerdict != nil {
 		span.RecordError(errVerdict)
 	}
 
+	// Update the transaction status of the session and, in transaction pooling mode,
+	// release the server connection once the transaction is finished.
+	pr.trackResponse(conn, session, client, serverResponse)
+
+	// While the server drains its connections, the session is disconnected once it is idle.
+	if pr.draining.Load() {
+		pr.drainSession(conn, session)
+	}
+
 	metrics.ProxyPassThroughsToClient.Inc()
 
 	return errVerdict
 }
 
+// SendError sends an ErrorResponse for an error that is caused by GatewayD to the client,
+// before the connection is closed, so the client sees why the connection is closed. If
+// the session has started, a ReadyForQuery message follows, so the client doesn't wait for
+// the rest of the response. The error is sent at most once per session.
+func (pr *Proxy) SendError(conn *ConnWrapper, err *gerr.GatewayDError) {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "SendError")
+	defer span.End()
+
+	// The session is removed once the connection is closed.
+	session, ok := pr.sessions.Get(conn).(*Session)
+	if !ok || err == nil {
+		return
+	}
+
+	response := GatewayDErrorResponse(err)
+	if response == nil {
+		return
+	}
+
+	session.mu.Lock()
+	if session.errorSent || session.terminated {
+		// The client doesn't expect anything after sending a Terminate message.
+		session.mu.Unlock()
+		return
+	}
+	session.errorSent = true
+	if session.started {
+		response = append(response, ReadyForQuery(session.txStatus)...)
+	}
+	session.mu.Unlock()
+
+	if _, origErr := conn.Write(response); origErr != nil {
+		pr.logger.Debug().Err(origErr).Msg("Failed to send the error to the client")
+		span.RecordError(origErr)
+		return
+	}
+
+	pr.logger.Debug().Fields(
+		map[string]interface{}{
+			"function": "proxy.sendError",
+			"error":    err.Error(),
+			"remote":   RemoteAddr(conn.Conn()),
+		},
+	).Msg("Sent the error to the client")
+	span.AddEvent("Sent the error to the client")
+}
+
 // IsHealthy checks if the pool is exhausted or the client is disconnected.
 func (pr *Proxy) IsHealthy(client *Client) (*Client, *gerr.GatewayDError) {
 	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "IsHealthy")
@@ -623,6 +790,24 @@ func (pr *Proxy) IsHealthy(client *Client) (*Client, *gerr.GatewayDError) {
 	return client, nil
 }
 
+// IsDetached returns true if no server connection is attached to the session of the incoming
+// connection, so no response can arrive for it. It only happens between the transactions in
+// transaction pooling mode.
+func (pr *Proxy) IsDetached(conn *ConnWrapper) bool {
+	if pr.PoolMode != config.Transaction {
+		return false
+	}
+
+	session, ok := pr.sessions.Get(conn).(*Session)
+	if !ok {
+		return false
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.client == nil
+}
+
 // IsExhausted checks if the available connection pool is exhausted.
 func (pr *Proxy) IsExhausted() bool {
 	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "IsExhausted")
@@ -635,6 +820,88 @@ func (pr *Proxy) IsExhausted() bool {
 	return pr.availableConnections.Size() == 0 && pr.availableConnections.Cap() > 0
 }
 
+// Drain disconnects the clients whose session is idle, between two transactions, with an
+// ErrorResponse, and returns the number of sessions that are still in a transaction or starting.
+// The other sessions are disconnected as soon as their transaction ends.
+func (pr *Proxy) Drain() int {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "Drain")
+	defer span.End()
+
+	pr.draining.Store(true)
+
+	busy := 0
+	pr.sessions.ForEach(func(key, value interface{}) bool {
+		conn, connOK := key.(*ConnWrapper)
+		session, sessionOK := value.(*Session)
+		if connOK && sessionOK && !pr.drainSession(conn, session) {
+			busy++
+		}
+		return true
+	})
+	return busy
+}
+
+// drainSession disconnects the client if its session is idle, by sending it an ErrorResponse
+// and shutting down the reading side of its connection, so that the connection is closed like
+// any other one once the EOF is read. It returns false if the session is busy.
+func (pr *Proxy) drainSession(conn *ConnWrapper, session *Session) bool {
+	session.mu.Lock()
+	idle := session.started && session.isIdle()
+	session.mu.Unlock()
+	if !idle {
+		return false
+	}
+
+	pr.closeClient(conn, gerr.ErrServerShuttingDown)
+	return true
+}
+
+// CloseIdle disconnects the client with an ErrorResponse if its session is idle for the
+// timeout, even in a transaction, since the session holds its server connection until then.
+// It returns true if the client is disconnected. The server connection is released by
+// Disconnect once the incoming connection is closed.
+func (pr *Proxy) CloseIdle(conn *ConnWrapper, timeout time.Duration) bool {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "CloseIdle")
+	defer span.End()
+
+	session, ok := pr.sessions.Get(conn).(*Session)
+	if !ok {
+		return false
+	}
+
+	session.mu.Lock()
+	// The clients that are already sent an error are being disconnected.
+	idle := !session.errorSent && session.isIdleFor(timeout)
+	session.mu.Unlock()
+	if !idle {
+		return false
+	}
+
+	span.AddEvent("Closing the idle client connection")
+	pr.closeClient(conn, gerr.ErrClientIdleTimeout)
+	return true
+}
+
+// TrackActivity makes the proxy read the traffic of all the sessions instead of splicing it,
+// so that their transaction status and their last request are always known. It is needed by
+// the servers that drain the sessions or close the idle ones, which also send them errors.
+func (pr *Proxy) TrackActivity() {
+	pr.trackActivity.Store(true)
+}
+
+// closeClient sends the error to the client and shuts down the reading side of its connection,
+// so that the connection is closed like any other one once the EOF is read. The error is sent
+// with a deadline, since a dead client might not acknowledge it.
+func (pr *Proxy) closeClient(conn *ConnWrapper, err *gerr.GatewayDError) {
+	if origErr := conn.Conn().SetWriteDeadline(time.Now().Add(closeClientTimeout)); origErr != nil {
+		pr.logger.Debug().Err(origErr).Msg("Failed to set the deadline of the error")
+	}
+	pr.SendError(conn, err)
+	if origErr := conn.CloseRead(); origErr != nil {
+		pr.logger.Debug().Err(origErr).Msg("Failed to close the reading side of the connection")
+	}
+}
+
 // Shutdown closes all connections and clears the connection pools.
 func (pr *Proxy) Shutdown() {
 	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "Shutdown")
@@ -652,9 +919,9 @@ func (pr *Proxy) Shutdown() {
 	pr.logger.Debug().Msg("All available connections have been closed")
 
 	pr.busyConnections.ForEach(func(key, value interface{}) bool {
-		if conn, ok := key.(net.Conn); ok {
+		if conn, ok := key.(*ConnWrapper); ok && conn != nil && conn.Conn() != nil {
 			// This will stop all the Conn.Read() and Conn.Write() calls.
-			if err := conn.SetDeadline(time.Now()); err != nil {
+			if err := conn.Conn().SetDeadline(time.Now()); err != nil {
 				pr.logger.Error().Err(err).Msg("Error setting the deadline")
 				span.RecordError(err)
 			}
@@ -674,6 +941,10 @@ func (pr *Proxy) Shutdown() {
 	pr.scheduler.Stop()
 	pr.scheduler.Clear()
 	pr.logger.Debug().Msg("All busy connections have been closed")
+
+	for _, replica := range pr.Replicas {
+		replica.Shutdown()
+	}
 }
 
 // AvailableConnections returns a list of available connections.
@@ -698,8 +969,8 @@ func (pr *Proxy) BusyConnections() []string {
 
 	connections := make([]string, 0)
 	pr.busyConnections.ForEach(func(key, _ interface{}) bool {
-		if conn, ok := key.(net.Conn); ok {
-			connections = append(connections, RemoteAddr(conn))
+		if conn, ok := key.(*ConnWrapper); ok && conn != nil {
+			connections = append(connections, RemoteAddr(conn.Conn()))
 		}
 		return true
 	})
@@ -707,44 +978,29 @@ func (pr *Proxy) BusyConnections() []string {
 }
 
 // receiveTrafficFromClient is a function that waits to receive data from the client.
-func (pr *Proxy) receiveTrafficFromClient(conn net.Conn) ([]byte, *gerr.GatewayDError) {
+// It returns whole messages, so the hooks always see complete, well-formed messages.
+func (pr *Proxy) receiveTrafficFromClient(conn *ConnWrapper) ([]byte, *gerr.GatewayDError) {
 	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "receiveTrafficFromClient")
 	defer span.End()
 
 	// request contains the data from the client.
-	received := 0
-	buffer := bytes.NewBuffer(nil)
-	for {
-		chunk := make([]byte, pr.ClientConfig.ReceiveChunkSize)
-		read, err := conn.Read(chunk)
-		if read == 0 || err != nil {
-			pr.logger.Debug().Err(err).Msg("Error reading from client")
-			span.RecordError(err)
-
-			metrics.BytesReceivedFromClient.Observe(float64(read))
-			metrics.TotalTrafficBytes.Observe(float64(read))
-
-			return chunk[:read], gerr.ErrReadFailed.Wrap(err)
-		}
-
-		received += read
-		buffer.Write(chunk[:read])
+	request, err := conn.Framer().ReadMessages()
+	if err != nil {
+		pr.logger.Debug().Err(err).Msg("Error reading from client")
+		span.RecordError(err)
 
-		if received == 0 || received < pr.ClientConfig.ReceiveChunkSize {
-			break
-		}
+		metrics.BytesReceivedFromClient.Observe(float64(len(request)))
+		metrics.TotalTrafficBytes.Observe(float64(len(request)))
 
-		if !pr.isConnectionHealthy(conn) {
-			break
-		}
+		return request, gerr.ErrReadFailed.Wrap(err)
 	}
 
-	length := len(buffer.Bytes())
+	length := len(request)
 	pr.logger.Debug().Fields(
 		map[string]interface{}{
 			"length": length,
-			"local":  LocalAddr(conn),
-			"remote": RemoteAddr(conn),
+			"local":  LocalAddr(conn.Conn()),
+			"remote": RemoteAddr(conn.Conn()),
 		},
 	).Msg("Received data from client")
 
@@ -753,7 +1009,7 @@ func (pr *Proxy) receiveTrafficFromClient(conn net.Conn) ([]byte, *gerr.GatewayD
 	metrics.BytesReceivedFromClient.Observe(float64(length))
 	metrics.TotalTrafficBytes.Observe(float64(length))
 
-	return buffer.Bytes(), nil
+	return request, nil
 }
 
 // sendTrafficToServer is a function that sends data to the server.
@@ -859,21 +1115,116 @@ func (pr *Proxy) sendTrafficToClient(
 	return nil
 }
 
-// shouldTerminate is a function that retrieves the terminate field from the hook result.
-// Only the OnTrafficFromClient hook will terminate the connection.
-func (pr *Proxy) shouldTerminate(result map[string]interface{}) bool {
-	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "shouldTerminate")
+// runResponseHooks runs the hooks of a part of a response. The hooks in the stream mode
+// receive each batch of messages as it arrives, and the hooks in the summary mode receive
+// the batch without the rows, unless it only has rows. Only the result of the hooks in the
+// stream mode is returned, since the others don't see the whole response.
+func (pr *Proxy) runResponseHooks(
+	conn *ConnWrapper, client *Client, exchange Exchange, hookName v1.HookName,
+) map[string]interface{} {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "runResponseHooks")
 	defer span.End()
 
-	// If the hook wants to terminate the connection, do it.
-	if result != nil {
-		if terminate, ok := result["terminate"].(bool); ok && terminate {
-			pr.logger.Debug().Str("function", "proxy.passthrough").Msg("Terminating connection")
-			return true
+	if !pr.pluginRegistry.HasHooks(hookName) {
+		return nil
+	}
+
+	var result map[string]interface{}
+	for _, mode := range []config.ResponseMode{config.StreamResponse, config.SummaryResponse} {
+		response := exchange.Response
+		if mode == config.SummaryResponse {
+			if response = ResponseSummary(response); response == nil {
+				break
+			}
+		}
+
+		pluginTimeoutCtx, cancel := context.WithTimeout(context.Background(), pr.pluginTimeout)
+		modeResult, err := pr.pluginRegistry.RunWithResponseMode(
+			pluginTimeoutCtx,
+			trafficData(
+				conn.Conn(),
+				client,
+				[]Field{
+					{
+						Name:  "request",
+						Value: exchange.Request,
+					},
+					{
+						Name:  "response",
+						Value: response,
+					},
+				},
+				nil,
+			),
+			hookName,
+			mode)
+		cancel()
+		if err != nil {
+			pr.logger.Error().Err(err).Msg("Error running hook")
+			span.RecordError(err)
+		}
+
+		if mode == config.StreamResponse {
+			result = modeResult
 		}
 	}
 
-	return false
+	return result
+}
+
+// runCopyHooks runs the OnCopyStart or OnCopyEnd hooks for the start or the end of a COPY.
+func (pr *Proxy) runCopyHooks(conn *ConnWrapper, client *Client, event copyEvent) {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "runCopyHooks")
+	defer span.End()
+
+	if event.hookName == plugin.OnCopyStart {
+		metrics.ProxyCopies.WithLabelValues(event.direction).Inc()
+	}
+
+	pr.logger.Debug().Fields(
+		map[string]interface{}{
+			"hook":      plugin.HookNames[event.hookName],
+			"direction": event.direction,
+			"bytes":     event.bytes,
+		},
+	).Msg("Running the hooks of the COPY")
+
+	pluginTimeoutCtx, cancel := context.WithTimeout(context.Background(), pr.pluginTimeout)
+	defer cancel()
+
+	var origErr interface{}
+	if event.err != "" {
+		origErr = event.err
+	}
+	args := trafficData(conn.Conn(), client, []Field{{Name: "request", Value: event.request}}, origErr)
+	if args != nil {
+		args["hook"] = plugin.HookNames[event.hookName]
+		args["direction"] = event.direction
+		args["bytes"] = event.bytes
+		args["tag"] = event.tag
+	}
+	_, err := pr.pluginRegistry.Run(pluginTimeoutCtx, args, event.hookName)
+	if err != nil {
+		pr.logger.Error().Err(err).Msg("Error running hook")
+		span.RecordError(err)
+	}
+}
+
+// shouldTerminate is a function that retrieves the terminate field from the hook result.
+// Only the OnTrafficFromClient hook will terminate the connection.
+func (pr *Proxy) shouldTerminate(result map[string]interface{}) bool {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "shouldTerminate")
+	defer span.End()
+
+	// If the hook wants to terminate the connection, do it.
+	if result != nil {
+		if terminate, ok := result["terminate"].(bool); ok && terminate {
+			pr.logger.Debug().Str("function", "proxy.passthrough").Msg("Terminating connection")
+			return true
+		}
+	}
+
+	return false
 }
 
 // getPluginModifiedRequest is a function that retrieves the modified request
@@ -908,16 +1259,814 @@ func (pr *Proxy) getPluginModifiedResponse(result map[string]interface{}) ([]byt
 	return nil, 0
 }
 
-func (pr *Proxy) isConnectionHealthy(conn net.Conn) bool {
-	if n, err := conn.Read([]byte{}); n == 0 && err != nil {
+// newClient creates a new client using the client config of the proxy.
+func (pr *Proxy) newClient() *Client {
+	return pr.newClientFor(nil)
+}
+
+// newClientFor creates a new client for the incoming connection, which sends the addresses
+// of the incoming connection in its PROXY header, or a new shared client if conn is nil.
+func (pr *Proxy) newClientFor(conn *ConnWrapper) *Client {
+	ctx := pr.ctx
+	if conn != nil {
+		ctx = context.WithValue(ctx, proxySourceKey{}, conn.Conn())
+	}
+	return NewClient(
+		ctx, pr.ClientConfig, pr.logger,
+		NewRetry(
+			pr.ClientConfig.Retries,
+			config.If[time.Duration](
+				pr.ClientConfig.Backoff > 0,
+				pr.ClientConfig.Backoff,
+				config.DefaultBackoff,
+			),
+			pr.ClientConfig.BackoffMultiplier,
+			pr.ClientConfig.DisableBackoffCaps,
+			pr.logger,
+		),
+	)
+}
+
+// checkClients runs the health check on the idle clients and replaces the ones that fail it.
+// The reconnect health check replaces all the clients that are not authenticated instead,
+// since the server closes them after its authentication timeout anyway.
+func (pr *Proxy) checkClients() {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "checkClients")
+	defer span.End()
+
+	// The clients that are put back in the pool must not be checked again.
+	var clients []*Client
+	pr.availableConnections.ForEach(func(_, value interface{}) bool {
+		if client, ok := value.(*Client); ok {
+			clients = append(clients, client)
+		}
+		return true
+	})
+
+	results := HealthCheckResults{LastCheck: time.Now()}
+	for _, client := range clients {
+		if pr.HealthCheckType == config.ReconnectHealthCheck &&
+			client.sessionKey != "" && client.IsAddressHealthy() {
+			// The connection is authenticated for the sessions of a user
+			// and database, and can't be replaced by a new connection.
+			continue
+		}
+		// If it can't be popped, it has just been assigned to an incoming connection.
+		if pr.availableConnections.Pop(client.ID) == nil {
+			continue
+		}
+
+		if pr.HealthCheckType != config.ReconnectHealthCheck {
+			err := pr.checkClient(client)
+			if err == nil {
+				results.Healthy++
+				pr.releaseClient(client, "")
+				continue
+			}
+
+			results.Failed++
+			pr.logger.Warn().Err(err).Fields(
+				map[string]interface{}{
+					"client":  client.ID[:7],
+					"address": client.Address,
+				},
+			).Msg("Client failed the health check")
+			span.RecordError(err)
+		}
+
+		if pr.replaceClient(client) {
+			results.Replaced++
+		}
+	}
+
+	pr.mu.Lock()
+	pr.healthCheckResults = results
+	pr.mu.Unlock()
+
+	metrics.ProxyHealthCheckResults.WithLabelValues(pr.Name, "healthy").Add(float64(results.Healthy))
+	metrics.ProxyHealthCheckResults.WithLabelValues(pr.Name, "failed").Add(float64(results.Failed))
+	metrics.ProxyHealthCheckResults.WithLabelValues(pr.Name, "replaced").Add(float64(results.Replaced))
+
+	pr.logger.Trace().Fields(
+		map[string]interface{}{
+			"healthy":  results.Healthy,
+			"failed":   results.Failed,
+			"replaced": results.Replaced,
+		},
+	).Msg("Client health check results")
+}
+
+// checkClient runs the health check on an idle client. The query health check runs the health
+// check query on the authenticated clients, and probes the others, like the tcp health check.
+func (pr *Proxy) checkClient(client *Client) *gerr.GatewayDError {
+	if !client.IsAddressHealthy() {
+		return gerr.ErrHealthCheckFailed.Wrap(
+			fmt.Errorf("the address %s is unreachable", client.Address))
+	}
+
+	if pr.HealthCheckType == config.QueryHealthCheck &&
+		(client.sessionKey != "" || client.authenticatesItself()) {
+		return client.Ping(pr.HealthCheckQuery, pr.HealthCheckTimeout)
+	}
+	return client.Probe()
+}
+
+// replaceClient closes the client and puts a new client in the pool instead. It returns false
+// if the new client can't be created. The sessions of the user and database of an authenticated
+// client can't use it anymore.
+func (pr *Proxy) replaceClient(client *Client) bool {
+	if client.sessionKey != "" {
+		pr.mu.Lock()
+		pr.untagClient(client)
+		pr.mu.Unlock()
+	}
+	client.Close()
+
+	// Create a new client.
+	client = pr.newClient()
+	if client == nil || client.ID == "" {
+		pr.logger.Error().Msg("Failed to create a new client connection")
+		return false
+	}
+	if err := pr.availableConnections.Put(client.ID, client); err != nil {
+		pr.logger.Err(err).Msg("Failed to update the client connection")
+		// Close the client, because we don't want to have orphaned connections.
+		client.Close()
+		return false
+	}
+	pr.notifyWaiter()
+	return true
+}
+
+// retireClients closes the idle clients that are older than MaxLifetime or idle for longer
+// than MaxIdleTime. The old clients are replaced by new ones, which rebalances them after a
+// failover, and the idle clients are closed until there are MinIdle idle clients left. The
+// last client of a session key with live sessions is kept, since they can't use another one.
+func (pr *Proxy) retireClients() {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "retireClients")
+	defer span.End()
+
+	// The clients that are put back in the pool must not be checked again.
+	var clients []*Client
+	pr.availableConnections.ForEach(func(_, value interface{}) bool {
+		if client, ok := value.(*Client); ok {
+			clients = append(clients, client)
+		}
+		return true
+	})
+
+	idle := len(clients)
+	for _, client := range clients {
+		idleSince := pr.availableConnections.IdleSince(client.ID)
+		tooOld := pr.MaxLifetime > 0 && client.Age() >= pr.MaxLifetime
+		tooIdle := pr.MaxIdleTime > 0 && !idleSince.IsZero() &&
+			time.Since(idleSince) >= pr.MaxIdleTime && idle > pr.MinIdle
+		if !tooOld && !tooIdle {
+			continue
+		}
+
+		pr.mu.Lock()
+		lastClient := client.sessionKey != "" &&
+			pr.keyClients[client.sessionKey] <= 1 && pr.keySessions[client.sessionKey] > 0
+		pr.mu.Unlock()
+		if lastClient {
+			continue
+		}
+
+		// If it can't be popped, it has just been assigned to an incoming connection.
+		if pr.availableConnections.Pop(client.ID) == nil {
+			continue
+		}
+
+		reason := "lifetime"
+		if tooIdle {
+			reason = "idle"
+		}
 		pr.logger.Debug().Fields(
 			map[string]interface{}{
-				"remote": RemoteAddr(conn),
-				"local":  LocalAddr(conn),
-				"reason": "read 0 bytes",
-			}).Msg("Connection to client is closed")
+				"client":  client.ID[:7],
+				"address": client.Address,
+				"age":     client.Age().String(),
+				"reason":  reason,
+			},
+		).Msg("Retiring the client")
+		pr.retireClient(client)
+		metrics.ProxyRetiredConnections.WithLabelValues(pr.Name, reason).Inc()
+
+		if tooIdle {
+			idle--
+		} else if client := pr.reopenClient(); client != nil {
+			pr.releaseClient(client, "")
+		} else {
+			idle--
+		}
+	}
+
+	// Keep MinIdle idle clients, so that the incoming connections don't wait for new ones.
+	for ; idle < pr.MinIdle; idle++ {
+		client := pr.reopenClient()
+		if client == nil {
+			break
+		}
+		pr.releaseClient(client, "")
+	}
+}
+
+// retireClient closes a client that is retired. Unless the proxy is elastic, a new client
+// can be opened instead by reopenClient.
+func (pr *Proxy) retireClient(client *Client) {
+	pr.mu.Lock()
+	pr.untagClient(client)
+	if !pr.Elastic {
+		pr.retired++
+	}
+	pr.mu.Unlock()
+	client.Close()
+}
+
+// reopenClient opens a new client instead of a client that is retired, or returns nil if
+// there is none or the new client can't be created. An elastic proxy opens a new client anyway.
+func (pr *Proxy) reopenClient() *Client {
+	pr.mu.Lock()
+	if !pr.Elastic {
+		if pr.retired == 0 {
+			pr.mu.Unlock()
+			return nil
+		}
+		pr.retired--
+	}
+	pr.mu.Unlock()
+
+	client := pr.newClient()
+	if client == nil || client.ID == "" {
+		pr.logger.Error().Msg("Failed to create a new client connection")
+		pr.mu.Lock()
+		if !pr.Elastic {
+			pr.retired++
+		}
+		pr.mu.Unlock()
+		return nil
+	}
+	return client
+}
+
+// HealthCheckResults returns the results of the last health check of the idle clients.
+func (pr *Proxy) HealthCheckResults() HealthCheckResults {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	return pr.healthCheckResults
+}
+
+// getClient returns the client that is mapped to the incoming connection.
+func (pr *Proxy) getClient(conn *ConnWrapper) (*Client, *gerr.GatewayDError) {
+	// Check if the proxy has a egress client for the incoming connection.
+	value := pr.busyConnections.Get(conn)
+	if value == nil {
+		return nil, gerr.ErrClientNotFound
+	}
+
+	client, ok := value.(*Client)
+	if !ok {
+		return nil, gerr.ErrCastFailed
+	}
+
+	if !client.IsConnected() {
+		return nil, gerr.ErrClientNotConnected
+	}
+
+	return client, nil
+}
+
+// popUnauthenticatedClient pops a client from the pool that can be used to start a new
+// session. A client that is authenticated for a session key is reconnected, unless it is
+// the last one of a session key with live sessions in transaction pooling mode. A client
+// that authenticates with its own credentials can be used by any session as is.
+func (pr *Proxy) popUnauthenticatedClient() *Client {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "popUnauthenticatedClient")
+	defer span.End()
+
+	pr.mu.Lock()
+	var client *Client
+	for client == nil {
+		var candidate *Client
+		pr.availableConnections.ForEach(func(_, value interface{}) bool {
+			cl, ok := value.(*Client)
+			if !ok {
+				return true
+			}
+			if cl.sessionKey == "" || cl.authenticatesItself() {
+				candidate = cl
+				return false
+			}
+			if candidate == nil &&
+				(pr.keyClients[cl.sessionKey] > 1 || pr.keySessions[cl.sessionKey] == 0) {
+				candidate = cl
+			}
+			return true
+		})
+		if candidate == nil {
+			pr.mu.Unlock()
+			return nil
+		}
+
+		// The health check might have replaced the client in the meantime.
+		if cl, ok := pr.availableConnections.Pop(candidate.ID).(*Client); ok && cl == candidate {
+			client = cl
+		}
+	}
+	authenticated := client.sessionKey != "" && !client.authenticatesItself()
+	pr.untagClient(client)
+	pr.mu.Unlock()
+
+	if authenticated {
+		// Start over with a new connection, since the server connection
+		// can't be authenticated again for another user or database.
+		if err := client.Reconnect(); err != nil {
+			pr.logger.Error().Err(err).Msg("Failed to reconnect to the client")
+			span.RecordError(err)
+		}
+		span.AddEvent("Reconnected an authenticated client")
+	}
+
+	return client
+}
+
+// acquireClient returns the client that is attached to the session in transaction pooling
+// mode. If there is none, it borrows an idle client that is authenticated for the same
+// session key and attaches it to the session. A read-only request is sent to a replica,
+// unless the session has sent a write before. The session is marked as sending a request
+// until finishRequest is called, so the client is not released in the meantime.
+func (pr *Proxy) acquireClient(
+	conn *ConnWrapper, session *Session, request []byte,
+) (*Client, *gerr.GatewayDError) {
+	session.mu.Lock()
+	kind := UtilityQuery
+	if len(pr.Replicas) > 0 && session.key != "" {
+		kind = ClassifyRequest(request)
+		if kind == WriteQuery {
+			session.sticky = true
+		}
+	}
+	if session.client != nil {
+		session.sending = true
+		client := session.client
+		session.mu.Unlock()
+		return client, nil
+	}
+	key := session.key
+	readOnly := kind == ReadOnlyQuery && !session.sticky
+	startupMessage := session.startupMessage
+	session.mu.Unlock()
+
+	var client *Client
+	var owner *Proxy
+	if readOnly {
+		client, owner = pr.borrowReplicaClient(key, startupMessage)
+	}
+	if client == nil {
+		var err *gerr.GatewayDError
+		if client, err = pr.borrowClient(session, key); err != nil {
+			return nil, err
+		}
+		owner = pr
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.isClosed() {
+		// The session is closed while waiting for a client.
+		owner.releaseClient(client, key)
+		return nil, gerr.ErrClientNotConnected
+	}
+
+	if err := pr.busyConnections.Put(conn, client); err != nil {
+		owner.releaseClient(client, key)
+		return nil, err
+	}
+	session.attach(client)
+	session.owner = owner
+	session.sending = true
+
+	if owner != pr {
+		metrics.ProxyReplicaRequests.Inc()
+	}
+
+	pr.logger.Trace().Fields(
+		map[string]interface{}{
+			"function": "proxy.acquireClient",
+			"client":   client.ID[:7],
+			"server":   RemoteAddr(conn.Conn()),
+			"replica":  owner != pr,
+		},
+	).Msg("Client has been assigned for the transaction")
+
+	return client, nil
+}
+
+// borrowClient pops an idle client that is authenticated for the given session key,
+// waiting for one to be released if all of them are busy, like the incoming connections
+// wait in the queue, for at most MaxWaitTime. If the proxy authenticates the clients,
+// any client can be used, since they authenticate with their own credentials.
+func (pr *Proxy) borrowClient(session *Session, key string) (*Client, *gerr.GatewayDError) {
+	var timer *time.Timer
+	var start time.Time
+	for {
+		pr.mu.Lock()
+		if pr.keyClients[key] == 0 && pr.AuthType == config.PassthroughAuth {
+			// All the clients of the session key are closed or reconnected,
+			// so the session can't continue.
+			pr.mu.Unlock()
+			return nil, gerr.ErrPoolExhausted
+		}
+
+		client := pr.popClient(func(cl *Client) bool {
+			return cl.sessionKey == key || cl.authenticatesItself()
+		})
+		released := pr.released
+		pr.mu.Unlock()
+
+		if client == nil && pr.AuthType != config.PassthroughAuth {
+			// The retired clients can be opened again, since any client can be used.
+			client = pr.reopenClient()
+		}
+		if client != nil {
+			return client, nil
+		}
+
+		if timer == nil {
+			pr.mu.Lock()
+			if pr.MaxQueueLength <= 0 || pr.borrowers >= pr.MaxQueueLength {
+				pr.mu.Unlock()
+				return nil, gerr.ErrPoolExhausted
+			}
+			pr.borrowers++
+			pr.mu.Unlock()
+
+			metrics.ProxyWaitQueueLength.Inc()
+			start = time.Now()
+			defer func() {
+				pr.mu.Lock()
+				pr.borrowers--
+				pr.mu.Unlock()
+				metrics.ProxyWaitQueueLength.Dec()
+				metrics.ProxyWaitDuration.Observe(time.Since(start).Seconds())
+			}()
+
+			timer = time.NewTimer(pr.MaxWaitTime)
+			defer timer.Stop()
+		}
+
+		select {
+		case <-released:
+		case <-session.closed:
+			return nil, gerr.ErrClientNotConnected
+		case <-timer.C:
+			metrics.ProxyWaitTimeouts.Inc()
+			pr.logger.Warn().Fields(
+				map[string]interface{}{
+					"function": "proxy.borrowClient",
+					"duration": time.Since(start).String(),
+				},
+			).Msg("Timed out waiting for a client connection")
+			return nil, gerr.ErrPoolWaitTimeout
+		}
+	}
+}
+
+// popClient pops the first idle client that matches, or returns nil if there is none.
+// It must be called with the lock held.
+func (pr *Proxy) popClient(match func(cl *Client) bool) *Client {
+	var client *Client
+	pr.availableConnections.ForEach(func(_, value interface{}) bool {
+		if cl, ok := value.(*Client); ok && match(cl) {
+			if cl, ok := pr.availableConnections.Pop(cl.ID).(*Client); ok {
+				client = cl
+				return false
+			}
+		}
+		return true
+	})
+	return client
+}
+
+// waitForClient waits until a client is attached to the session in transaction pooling mode.
+func (pr *Proxy) waitForClient(session *Session) (*Client, *gerr.GatewayDError) {
+	for {
+		session.mu.Lock()
+		client := session.client
+		session.mu.Unlock()
+
+		if client != nil {
+			if !client.IsConnected() {
+				return nil, gerr.ErrClientNotConnected
+			}
+			return client, nil
+		}
+
+		select {
+		case <-session.attached:
+		case <-session.closed:
+			return nil, gerr.ErrClientNotConnected
+		}
+	}
+}
+
+// trackResponse updates the transaction status of the session from the response. In
+// transaction pooling mode, the client is released back to the pool once the server
+// has answered all the requests of the session and the transaction is finished.
+func (pr *Proxy) trackResponse(conn *ConnWrapper, session *Session, client *Client, response []byte) {
+	session.mu.Lock()
+	if !session.trackResponse(response) ||
+		pr.PoolMode != config.Transaction ||
+		!session.isIdle() ||
+		session.client != client {
+		session.mu.Unlock()
+		return
+	}
+	session.client = nil
+	owner := pr
+	if session.owner != nil {
+		owner = session.owner
+	}
+	session.owner = nil
+	pr.busyConnections.Remove(conn)
+	key := session.key
+	session.mu.Unlock()
+
+	owner.releaseClient(client, key)
+
+	pr.logger.Trace().Fields(
+		map[string]interface{}{
+			"function": "proxy.trackResponse",
+			"client":   client.ID[:7],
+			"server":   RemoteAddr(conn.Conn()),
+		},
+	).Msg("Client has been released after the transaction")
+}
+
+// releaseClient puts the client back in the pool, as authenticated for the given session
+// key, and wakes up the sessions that are waiting for a client.
+func (pr *Proxy) releaseClient(client *Client, key string) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if client.sessionKey == "" && key != "" {
+		client.sessionKey = key
+		pr.keyClients[key]++
+	}
+
+	if err := pr.availableConnections.Put(client.ID, client); err != nil {
+		pr.logger.Error().Err(err).Msg("Failed to put the client back in the pool")
+		pr.untagClient(client)
+		client.Close()
+	}
+
+	close(pr.released)
+	pr.released = make(chan struct{})
+	pr.wakeUpWaiter()
+}
+
+// untagClient marks the client as not authenticated for any session key.
+// It must be called with the lock held.
+func (pr *Proxy) untagClient(client *Client) {
+	if client.sessionKey == "" {
+		return
+	}
+
+	pr.keyClients[client.sessionKey]--
+	if pr.keyClients[client.sessionKey] <= 0 {
+		delete(pr.keyClients, client.sessionKey)
+	}
+	client.sessionKey = ""
+}
+
+// disconnectSession detaches the client from the session in transaction pooling mode.
+// If the session is disconnected in the middle of a transaction, the client is reset,
+// or recycled by reconnecting if the reset fails or the session is not started yet.
+func (pr *Proxy) disconnectSession(conn *ConnWrapper, session *Session) *gerr.GatewayDError {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "disconnectSession")
+	defer span.End()
+
+	session.mu.Lock()
+	client := session.client
+	session.client = nil
+	owner := pr
+	if session.owner != nil {
+		owner = session.owner
+	}
+	session.owner = nil
+	key := session.key
+	session.mu.Unlock()
+
+	pr.busyConnections.Remove(conn)
+
+	pr.mu.Lock()
+	if key != "" {
+		pr.keySessions[key]--
+		if pr.keySessions[key] <= 0 {
+			delete(pr.keySessions, key)
+		}
+	}
+	pr.mu.Unlock()
+
+	if client != nil {
+		if !(owner.Elastic && !owner.ReuseElasticClients) && pr.resetClient(client, session) {
+			// The client stays authenticated for the session key.
+			owner.releaseClient(client, key)
+			client = nil
+		}
+	}
+
+	if client != nil {
+		owner.mu.Lock()
+		owner.untagClient(client)
+		owner.mu.Unlock()
+
+		if owner.Elastic && !owner.ReuseElasticClients {
+			client.Close()
+		} else {
+			// Recycle the server connection by reconnecting.
+			if err := client.Reconnect(); err != nil {
+				pr.logger.Error().Err(err).Msg("Failed to reconnect to the client")
+				span.RecordError(err)
+			}
+			owner.releaseClient(client, "")
+		}
+	}
+
+	metrics.ProxiedConnections.Dec()
+
+	pr.logger.Debug().Fields(
+		map[string]interface{}{
+			"function": "proxy.disconnect",
+			"count":    pr.availableConnections.Size(),
+		},
+	).Msg("Available client connections")
+	pr.logger.Debug().Fields(
+		map[string]interface{}{
+			"function": "proxy.disconnect",
+			"count":    pr.busyConnections.Size(),
+		},
+	).Msg("Busy client connections")
+
+	return nil
+}
+
+// resetsClient returns true if the client is reset once its session is disconnected, so that
+// it is reused by the next sessions. In session pooling mode, a client that is authenticated by
+// the StartupMessage of its session can't be authenticated again for the next session, so it is
+// reconnected right away instead of being reset.
+func (pr *Proxy) resetsClient(client *Client) bool {
+	return pr.ResetQuery != "" && (pr.PoolMode == config.Transaction || client.authenticatesItself())
+}
+
+// resetClient runs the reset query on the client of a session that is disconnected, after
+// rolling back the transaction of the session, if any. It returns false if the client
+// can't be reset, in which case it must be recycled by reconnecting. The client can only
+// be reset if the session has started and the server has answered all of its requests.
+func (pr *Proxy) resetClient(client *Client, session *Session) bool {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "resetClient")
+	defer span.End()
+
+	if !pr.resetsClient(client) {
+		return false
+	}
+
+	session.mu.Lock()
+	resettable := session.started && session.pending == 0 && !session.sending
+	txStatus := session.txStatus
+	session.mu.Unlock()
+	if !resettable {
 		return false
 	}
 
+	queries := []string{pr.ResetQuery}
+	if txStatus != PostgresTxIdle {
+		queries = []string{"ROLLBACK", pr.ResetQuery}
+	}
+
+	start := time.Now()
+	err := client.Reset(config.DefaultResetTimeout, queries...)
+	metrics.ProxyResetDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		pr.logger.Warn().Err(err).Str("client", client.ID[:7]).Msg(
+			"Failed to reset the client, reconnecting")
+		span.RecordError(err)
+		return false
+	}
+
+	pr.logger.Trace().Str("client", client.ID[:7]).Msg("Reset the client")
 	return true
 }
+
+// hasWaiters returns true if there are incoming connections waiting for a server connection.
+func (pr *Proxy) hasWaiters() bool {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	return pr.waiters.Len() > 0
+}
+
+// waitInQueue waits in the queue until a server connection is put back in the pool, for
+// at most MaxWaitTime. The queue is FIFO, so only the incoming connection at the front of
+// the queue tries to get a server connection. It returns ErrPoolExhausted if the queue is
+// disabled or full, and ErrPoolWaitTimeout if the wait times out.
+func (pr *Proxy) waitInQueue() (*Client, *gerr.GatewayDError) {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "waitInQueue")
+	defer span.End()
+
+	pr.mu.Lock()
+	if pr.MaxQueueLength <= 0 || pr.waiters.Len() >= pr.MaxQueueLength {
+		pr.mu.Unlock()
+		span.RecordError(gerr.ErrPoolExhausted)
+		return nil, gerr.ErrPoolExhausted
+	}
+	ready := make(chan struct{}, 1)
+	waiter := pr.waiters.PushBack(ready)
+	pr.mu.Unlock()
+
+	metrics.ProxyWaitQueueLength.Inc()
+	start := time.Now()
+	defer func() {
+		metrics.ProxyWaitQueueLength.Dec()
+		metrics.ProxyWaitDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	timer := time.NewTimer(pr.MaxWaitTime)
+	defer timer.Stop()
+
+	for {
+		pr.mu.Lock()
+		first := pr.waiters.Front() == waiter
+		pr.mu.Unlock()
+
+		if first {
+			if client := pr.popAvailableClient(); client != nil {
+				pr.leaveQueue(waiter)
+				pr.logger.Debug().Fields(
+					map[string]interface{}{
+						"function": "proxy.waitInQueue",
+						"duration": time.Since(start).String(),
+					},
+				).Msg("Got a client connection after waiting in the queue")
+				return client, nil
+			}
+		}
+
+		select {
+		case <-ready:
+		case <-timer.C:
+			pr.leaveQueue(waiter)
+			metrics.ProxyWaitTimeouts.Inc()
+			pr.logger.Warn().Fields(
+				map[string]interface{}{
+					"function": "proxy.waitInQueue",
+					"duration": time.Since(start).String(),
+				},
+			).Msg("Timed out waiting for a client connection")
+			span.RecordError(gerr.ErrPoolWaitTimeout)
+			return nil, gerr.ErrPoolWaitTimeout
+		}
+	}
+}
+
+// leaveQueue removes the waiter from the queue and wakes up the next one,
+// since there might be more server connections available.
+func (pr *Proxy) leaveQueue(waiter *list.Element) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	pr.waiters.Remove(waiter)
+	pr.wakeUpWaiter()
+}
+
+// notifyWaiter wakes up the incoming connection at the front of the queue,
+// after a server connection is put back in the pool.
+func (pr *Proxy) notifyWaiter() {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.wakeUpWaiter()
+}
+
+// wakeUpWaiter is the lock-free version of notifyWaiter.
+// It must be called with the lock held.
+func (pr *Proxy) wakeUpWaiter() {
+	if front := pr.waiters.Front(); front != nil {
+		if ready, ok := front.Value.(chan struct{}); ok {
+			select {
+			case ready <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// popAvailableClient pops a client from the pool that can be assigned to a new incoming
+// connection, or returns nil if there is none.
+func (pr *Proxy) popAvailableClient() *Client {
+	if client := pr.popUnauthenticatedClient(); client != nil {
+		return client
+	}
+	// The clients that were retired can be opened again.
+	return pr.reopenClient()
+}
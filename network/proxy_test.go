@@ -2,15 +2,22 @@ package network
 
 import (
 	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
 	"github.com/gatewayd-io/gatewayd/logging"
 	"github.com/gatewayd-io/gatewayd/plugin"
 	"github.com/gatewayd-io/gatewayd/pool"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestNewProxy tests the creation of a new proxy with a fixed connection pool.
@@ -47,6 +54,7 @@ func TestNewProxy(t *testing.T) {
 	proxy := NewProxy(
 		context.Background(),
 		newPool,
+		nil,
 		plugin.NewRegistry(
 			context.Background(),
 			config.Loose,
@@ -56,9 +64,9 @@ func TestNewProxy(t *testing.T) {
 			logger,
 			false,
 		),
-		false,
-		false,
-		config.DefaultHealthCheckPeriod,
+		&config.Proxy{
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+		},
 		nil,
 		logger,
 		config.DefaultPluginTimeout)
@@ -95,6 +103,7 @@ func TestNewProxyElastic(t *testing.T) {
 	proxy := NewProxy(
 		context.Background(),
 		newPool,
+		nil,
 		plugin.NewRegistry(
 			context.Background(),
 			config.Loose,
@@ -104,9 +113,10 @@ func TestNewProxyElastic(t *testing.T) {
 			logger,
 			false,
 		),
-		true,
-		false,
-		config.DefaultHealthCheckPeriod,
+		&config.Proxy{
+			Elastic:           true,
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+		},
 		&config.Client{
 			Network:            "tcp",
 			Address:            "localhost:5432",
@@ -130,6 +140,728 @@ func TestNewProxyElastic(t *testing.T) {
 	assert.Equal(t, "localhost:5432", proxy.ClientConfig.Address)
 }
 
+// TestProxyCheckClients tests that the health check only replaces the clients that fail it.
+func TestProxyCheckClients(t *testing.T) {
+	idleServer := startFakeServer(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+	closingServer := startFakeServer(t, func(conn net.Conn) {
+		conn.Close()
+	})
+
+	newPool := pool.NewPool(context.Background(), config.EmptyPoolCapacity)
+	healthy := newFakeServerClient(t, idleServer)
+	require.Nil(t, newPool.Put(healthy.ID, healthy))
+	failed := newFakeServerClient(t, closingServer)
+	require.Nil(t, newPool.Put(failed.ID, failed))
+	time.Sleep(100 * time.Millisecond)
+
+	proxy := NewProxy(
+		context.Background(),
+		newPool,
+		nil,
+		nil,
+		&config.Proxy{
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+			HealthCheckType:   string(config.TCPHealthCheck),
+		},
+		&config.Client{
+			Network:          "tcp",
+			Address:          idleServer,
+			ReceiveChunkSize: config.DefaultChunkSize,
+			DialTimeout:      time.Second,
+		},
+		zerolog.Nop(),
+		config.DefaultPluginTimeout,
+	)
+	defer proxy.Shutdown()
+
+	proxy.checkClients()
+
+	results := proxy.HealthCheckResults()
+	assert.Equal(t, 1, results.Healthy)
+	assert.Equal(t, 1, results.Failed)
+	assert.Equal(t, 1, results.Replaced)
+	assert.False(t, results.LastCheck.IsZero())
+
+	assert.Equal(t, 2, newPool.Size())
+	assert.Equal(t, healthy, newPool.Get(healthy.ID))
+	assert.Nil(t, newPool.Get(failed.ID))
+	assert.False(t, failed.IsConnected())
+}
+
+// TestProxyRetireClients tests that the old clients are replaced and that the idle
+// clients are closed down to the minimum, and opened again when the pool is exhausted.
+func TestProxyRetireClients(t *testing.T) {
+	server := startFakeServer(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+
+	newPool := pool.NewPool(context.Background(), 3)
+	clients := make([]*Client, 0, 3)
+	for i := 0; i < 3; i++ {
+		client := newFakeServerClient(t, server)
+		require.Nil(t, newPool.Put(client.ID, client))
+		clients = append(clients, client)
+	}
+
+	proxy := NewProxy(
+		context.Background(),
+		newPool,
+		nil,
+		nil,
+		&config.Proxy{
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+		},
+		&config.Client{
+			Network:          "tcp",
+			Address:          server,
+			ReceiveChunkSize: config.DefaultChunkSize,
+			DialTimeout:      time.Second,
+		},
+		zerolog.Nop(),
+		config.DefaultPluginTimeout,
+	)
+	defer proxy.Shutdown()
+
+	// The clients are neither too old nor idle for too long.
+	proxy.MaxLifetime = time.Hour
+	proxy.MaxIdleTime = time.Hour
+	proxy.MinIdle = 1
+	proxy.retireClients()
+	assert.Equal(t, 3, newPool.Size())
+
+	// The old clients are replaced by new ones.
+	proxy.MaxLifetime = time.Nanosecond
+	proxy.retireClients()
+	assert.Equal(t, 3, newPool.Size())
+	for _, client := range clients {
+		assert.Nil(t, newPool.Get(client.ID))
+		assert.False(t, client.IsConnected())
+	}
+
+	// The idle clients are closed, except for MinIdle of them.
+	proxy.MaxLifetime = time.Hour
+	proxy.MaxIdleTime = time.Nanosecond
+	proxy.retireClients()
+	assert.Equal(t, 1, newPool.Size())
+
+	// The closed clients are opened again when the pool is exhausted.
+	for i := 0; i < 3; i++ {
+		client := proxy.popAvailableClient()
+		require.NotNil(t, client)
+		client.Close()
+	}
+	assert.Nil(t, proxy.popAvailableClient())
+	assert.Equal(t, 0, newPool.Size())
+}
+
+// TestProxyResetClient tests that the server connection of a session that disconnects is reset
+// and kept, instead of reconnecting, if the next sessions can use it. A server connection that
+// is authenticated by the StartupMessage of its session is reconnected right away instead.
+func TestProxyResetClient(t *testing.T) {
+	queries := make(chan string, 10)
+	var accepted atomic.Int32
+	server := startFakeServer(t, func(conn net.Conn) {
+		accepted.Add(1)
+		framer := NewFramer(conn, config.DefaultChunkSize, false)
+		for {
+			message, err := framer.ReadMessage()
+			if err != nil {
+				return
+			}
+			queries <- string(message[5 : len(message)-1])
+			if _, err := conn.Write(ReadyForQuery(PostgresTxIdle)); err != nil {
+				return
+			}
+		}
+	})
+
+	newPool := pool.NewPool(context.Background(), 1)
+	client := newFakeServerClient(t, server)
+	require.Nil(t, newPool.Put(client.ID, client))
+
+	proxy := NewProxy(
+		context.Background(),
+		newPool,
+		nil,
+		nil,
+		&config.Proxy{
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+			ResetQuery:        config.DefaultResetQuery,
+		},
+		&config.Client{
+			Network:          "tcp",
+			Address:          server,
+			ReceiveChunkSize: config.DefaultChunkSize,
+			DialTimeout:      time.Second,
+		},
+		zerolog.Nop(),
+		config.DefaultPluginTimeout,
+	)
+	defer proxy.Shutdown()
+
+	connect := func(started bool, txStatus byte) *ConnWrapper {
+		netConn, _ := net.Pipe()
+		conn := NewConnWrapper(netConn, nil, config.DefaultHandshakeTimeout)
+		require.Nil(t, proxy.Connect(conn))
+		session, ok := proxy.sessions.Get(conn).(*Session)
+		require.True(t, ok)
+		session.setStartupParameters(map[string]string{"user": "postgres", "database": "postgres"}, nil)
+		session.started = started
+		session.txStatus = txStatus
+		return conn
+	}
+
+	// The server connection is authenticated by the session, so it is reconnected without
+	// being reset, and the next session uses it without reconnecting again.
+	require.Nil(t, proxy.Disconnect(connect(true, PostgresTxInTransaction)))
+	assert.Equal(t, client, newPool.Get(client.ID))
+	assert.Empty(t, client.sessionKey)
+	assert.Eventually(t, func() bool { return accepted.Load() == 2 }, time.Second, 10*time.Millisecond)
+	conn := connect(true, PostgresTxIdle)
+	assert.Never(t, func() bool { return accepted.Load() != 2 }, 100*time.Millisecond, 10*time.Millisecond)
+
+	// The server connection that authenticates with the credentials of the client config
+	// is reset and kept for the next sessions, after the transaction is rolled back.
+	client.User = "postgres"
+	require.Nil(t, proxy.Disconnect(conn))
+	assert.Equal(t, config.DefaultResetQuery, <-queries)
+	assert.Equal(t, client, newPool.Get(client.ID))
+	assert.Equal(t, "user=postgres database=postgres", client.sessionKey)
+	require.Nil(t, proxy.Disconnect(connect(true, PostgresTxInTransaction)))
+	assert.Equal(t, "ROLLBACK", <-queries)
+	assert.Equal(t, config.DefaultResetQuery, <-queries)
+	assert.Equal(t, client, newPool.Get(client.ID))
+	assert.Equal(t, int32(2), accepted.Load())
+	assert.Empty(t, queries)
+}
+
+// TestProxyDrain tests that the clients are disconnected with an ErrorResponse once their
+// session is idle, while the ones in a transaction are left until their transaction ends.
+func TestProxyDrain(t *testing.T) {
+	server := startFakeServer(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+
+	newPool := pool.NewPool(context.Background(), 2)
+	for i := 0; i < 2; i++ {
+		client := newFakeServerClient(t, server)
+		require.Nil(t, newPool.Put(client.ID, client))
+	}
+
+	proxy := NewProxy(
+		context.Background(),
+		newPool,
+		nil,
+		nil,
+		&config.Proxy{
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+		},
+		&config.Client{
+			Network:          "tcp",
+			Address:          server,
+			ReceiveChunkSize: config.DefaultChunkSize,
+			DialTimeout:      time.Second,
+		},
+		zerolog.Nop(),
+		config.DefaultPluginTimeout,
+	)
+	defer proxy.Shutdown()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	connect := func(txStatus byte) (*ConnWrapper, net.Conn, *Session) {
+		peer, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { peer.Close() })
+		netConn, err := listener.Accept()
+		require.NoError(t, err)
+		t.Cleanup(func() { netConn.Close() })
+
+		conn := NewConnWrapper(netConn, nil, config.DefaultHandshakeTimeout)
+		require.Nil(t, proxy.Connect(conn))
+		session, ok := proxy.sessions.Get(conn).(*Session)
+		require.True(t, ok)
+		session.started = true
+		session.txStatus = txStatus
+		return conn, peer, session
+	}
+
+	idleConn, idlePeer, _ := connect(PostgresTxIdle)
+	busyConn, busyPeer, busySession := connect(PostgresTxInTransaction)
+	assert.Equal(t, 1, proxy.Drain())
+
+	// The idle client receives the error, and the proxy reads the end of its connection.
+	expected := append(
+		ErrorResponse(PostgresSeverityFatal, "57P01",
+			"gatewayd: terminating connection due to administrator command"),
+		ReadyForQuery(PostgresTxIdle)...)
+	response := make([]byte, len(expected))
+	_, err = io.ReadFull(idlePeer, response)
+	require.NoError(t, err)
+	assert.Equal(t, expected, response)
+	_, err = idleConn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	// The client in a transaction is disconnected once the transaction ends.
+	require.NoError(t, busyPeer.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, err = busyPeer.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	busySession.mu.Lock()
+	busySession.txStatus = PostgresTxIdle
+	busySession.mu.Unlock()
+	assert.Equal(t, 0, proxy.Drain())
+	_, err = busyConn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	require.Nil(t, proxy.Disconnect(idleConn))
+	require.Nil(t, proxy.Disconnect(busyConn))
+}
+
+// TestProxyCloseIdle tests that the clients are disconnected with an error once their session
+// is idle for the timeout, even in a transaction, but not while they wait for a response.
+func TestProxyCloseIdle(t *testing.T) {
+	server := startFakeServer(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+
+	newPool := pool.NewPool(context.Background(), 2)
+	for i := 0; i < 2; i++ {
+		client := newFakeServerClient(t, server)
+		require.Nil(t, newPool.Put(client.ID, client))
+	}
+
+	proxy := NewProxy(
+		context.Background(),
+		newPool,
+		nil,
+		nil,
+		&config.Proxy{
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+		},
+		&config.Client{
+			Network:          "tcp",
+			Address:          server,
+			ReceiveChunkSize: config.DefaultChunkSize,
+			DialTimeout:      time.Second,
+		},
+		zerolog.Nop(),
+		config.DefaultPluginTimeout,
+	)
+	defer proxy.Shutdown()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	connect := func() (*ConnWrapper, net.Conn, *Session) {
+		peer, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { peer.Close() })
+		netConn, err := listener.Accept()
+		require.NoError(t, err)
+		t.Cleanup(func() { netConn.Close() })
+
+		conn := NewConnWrapper(netConn, nil, config.DefaultHandshakeTimeout)
+		require.Nil(t, proxy.Connect(conn))
+		session, ok := proxy.sessions.Get(conn).(*Session)
+		require.True(t, ok)
+		session.started = true
+		session.txStatus = PostgresTxInTransaction
+		return conn, peer, session
+	}
+
+	idleConn, idlePeer, _ := connect()
+	busyConn, _, busySession := connect()
+	busySession.pending = 1
+
+	assert.False(t, proxy.CloseIdle(idleConn, time.Hour))
+	assert.True(t, proxy.CloseIdle(idleConn, 0))
+	assert.False(t, proxy.CloseIdle(busyConn, 0))
+	// The client is only disconnected once.
+	assert.False(t, proxy.CloseIdle(idleConn, 0))
+
+	expected := append(
+		ErrorResponse(PostgresSeverityFatal, "57P05",
+			"gatewayd: terminating connection due to idle timeout"),
+		ReadyForQuery(PostgresTxInTransaction)...)
+	response := make([]byte, len(expected))
+	_, err = io.ReadFull(idlePeer, response)
+	require.NoError(t, err)
+	assert.Equal(t, expected, response)
+	_, err = idleConn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	require.Nil(t, proxy.Disconnect(idleConn))
+	require.Nil(t, proxy.Disconnect(busyConn))
+}
+
+// TestProxyWaitQueue tests that the incoming connections wait for a server connection
+// when the pool is exhausted.
+func TestProxyWaitQueue(t *testing.T) {
+	logger := logging.NewLogger(context.Background(), logging.LoggerConfig{
+		Output:            []config.LogOutput{config.Console},
+		TimeFormat:        zerolog.TimeFormatUnix,
+		ConsoleTimeFormat: time.RFC3339,
+		Level:             zerolog.WarnLevel,
+		NoColor:           true,
+	})
+
+	clientConfig := config.Client{
+		Network:            "tcp",
+		Address:            "localhost:5432",
+		ReceiveChunkSize:   config.DefaultChunkSize,
+		ReceiveDeadline:    config.DefaultReceiveDeadline,
+		SendDeadline:       config.DefaultSendDeadline,
+		DialTimeout:        config.DefaultDialTimeout,
+		TCPKeepAlive:       false,
+		TCPKeepAlivePeriod: config.DefaultTCPKeepAlivePeriod,
+	}
+
+	// Create a connection newPool with a single client.
+	newPool := pool.NewPool(context.Background(), 1)
+	client := NewClient(context.Background(), &clientConfig, logger, nil)
+	require.NotNil(t, client)
+	require.Nil(t, newPool.Put(client.ID, client))
+
+	proxy := NewProxy(
+		context.Background(),
+		newPool,
+		nil,
+		plugin.NewRegistry(
+			context.Background(),
+			config.Loose,
+			config.PassDown,
+			config.Accept,
+			config.Stop,
+			logger,
+			false,
+		),
+		&config.Proxy{
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+			MaxWaitTime:       200 * time.Millisecond,
+			MaxQueueLength:    1,
+		},
+		&clientConfig,
+		logger,
+		config.DefaultPluginTimeout)
+	defer proxy.Shutdown()
+
+	newConn := func() *ConnWrapper {
+		conn, _ := net.Pipe()
+		return NewConnWrapper(conn, nil, config.DefaultHandshakeTimeout)
+	}
+
+	conn1, conn2, conn3, conn4 := newConn(), newConn(), newConn(), newConn()
+	require.Nil(t, proxy.Connect(conn1))
+	assert.True(t, proxy.IsExhausted())
+
+	// The second connection gets the client once the first one disconnects.
+	connected := make(chan *gerr.GatewayDError)
+	go func() {
+		connected <- proxy.Connect(conn2)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, proxy.hasWaiters())
+	require.Nil(t, proxy.Disconnect(conn1))
+	assert.Nil(t, <-connected)
+	assert.False(t, proxy.hasWaiters())
+
+	// The third connection times out, and the fourth doesn't fit in the queue.
+	go func() {
+		connected <- proxy.Connect(conn3)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.ErrorIs(t, proxy.Connect(conn4), gerr.ErrPoolExhausted)
+	assert.ErrorIs(t, <-connected, gerr.ErrPoolWaitTimeout)
+	assert.False(t, proxy.hasWaiters())
+
+	require.Nil(t, proxy.Disconnect(conn2))
+}
+
+// startFakeSQLServer starts a server that answers the queries with the transaction status
+// that follows BEGIN and COMMIT. It answers the StartupMessage with the startup response,
+// if any, and the ReplicationLagQuery with the lag. The other queries are sent to the
+// channel, prefixed with the name of the server.
+func startFakeSQLServer(
+	t *testing.T, name string, startupResponse []byte, queries chan<- string, lag *atomic.Value,
+) string {
+	t.Helper()
+
+	return startFakeServer(t, func(conn net.Conn) {
+		framer := NewFramer(conn, config.DefaultChunkSize, startupResponse != nil)
+		txStatus := PostgresTxIdle
+		for {
+			message, err := framer.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			var response []byte
+			switch {
+			case IsPostgresStartupMessage(message):
+				response = startupResponse
+			case message[0] == PostgresPassword:
+				queries <- name + ": password " + string(message[5:len(message)-1])
+				response = replicaStartup
+			case string(message[5:len(message)-1]) == ReplicationLagQuery:
+				value, _ := lag.Load().(string)
+				row := binary.BigEndian.AppendUint16(nil, 1)
+				row = binary.BigEndian.AppendUint32(row, uint32(len(value)))
+				response = append(
+					CreatePostgreSQLPacket('D', append(row, value...)), ReadyForQuery(txStatus)...)
+			default:
+				query := string(message[5 : len(message)-1])
+				switch query {
+				case "BEGIN":
+					txStatus = PostgresTxInTransaction
+				case "COMMIT":
+					txStatus = PostgresTxIdle
+				}
+				queries <- name + ": " + query
+				response = ReadyForQuery(txStatus)
+			}
+			if _, err := conn.Write(response); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// newTransactionProxy creates a proxy in transaction pooling mode with a single
+// server connection to the primary.
+func newTransactionProxy(t *testing.T, primary string) *Proxy {
+	t.Helper()
+
+	newPool := pool.NewPool(context.Background(), 1)
+	client := newFakeServerClient(t, primary)
+	require.Nil(t, newPool.Put(client.ID, client))
+
+	proxy := NewProxy(
+		context.Background(),
+		newPool,
+		nil,
+		nil,
+		&config.Proxy{
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+			PoolMode:          string(config.Transaction),
+			MaxWaitTime:       config.DefaultMaxWaitTime,
+			MaxQueueLength:    config.DefaultMaxQueueLength,
+		},
+		&config.Client{
+			Network:          "tcp",
+			Address:          primary,
+			ReceiveChunkSize: config.DefaultChunkSize,
+			DialTimeout:      time.Second,
+		},
+		zerolog.Nop(),
+		config.DefaultPluginTimeout,
+	)
+	t.Cleanup(proxy.Shutdown)
+	return proxy
+}
+
+// startTransactionSession connects a session to the proxy in transaction pooling mode, and
+// starts it as if the server had answered its StartupMessage, which releases its server
+// connection.
+func startTransactionSession(t *testing.T, proxy *Proxy) (*ConnWrapper, *Session) {
+	t.Helper()
+
+	netConn, _ := net.Pipe()
+	t.Cleanup(func() { netConn.Close() })
+	conn := NewConnWrapper(netConn, nil, config.DefaultHandshakeTimeout)
+	require.Nil(t, proxy.Connect(conn))
+	session, ok := proxy.sessions.Get(conn).(*Session)
+	require.True(t, ok)
+	session.setStartupParameters(
+		map[string]string{"user": "postgres", "database": "postgres"}, CreatePgStartupPacket())
+	proxy.trackResponse(conn, session, session.client, ReadyForQuery(PostgresTxIdle))
+	require.Nil(t, session.client)
+	return conn, session
+}
+
+// runTransactionQuery sends the query of the session to the server connection that the
+// proxy assigns to it, and tracks the response, like the passthrough does.
+func runTransactionQuery(t *testing.T, proxy *Proxy, conn *ConnWrapper, session *Session, query string) {
+	t.Helper()
+
+	client, err := proxy.acquireClient(conn, session, QueryMessage(query))
+	require.Nil(t, err)
+	_, err = client.Send(QueryMessage(query))
+	require.Nil(t, err)
+	session.finishRequest()
+	_, response, err := client.Receive()
+	require.Nil(t, err)
+	proxy.trackResponse(conn, session, client, response)
+}
+
+// TestProxyTransactionRelease tests that the server connection of a session is released once
+// its transaction is finished, and not while the transaction is in progress or failed, and
+// that it is then borrowed by another session.
+func TestProxyTransactionRelease(t *testing.T) {
+	proxy := newTransactionProxy(t, startFakeSQLServer(t, "primary", nil, make(chan string, 10), nil))
+	conn1, session1 := startTransactionSession(t, proxy)
+	conn2, session2 := startTransactionSession(t, proxy)
+
+	client, err := proxy.acquireClient(conn1, session1, QueryMessage("BEGIN"))
+	require.Nil(t, err)
+	session1.finishRequest()
+	for _, txStatus := range []byte{PostgresTxInTransaction, PostgresTxFailed} {
+		proxy.trackResponse(conn1, session1, client, ReadyForQuery(txStatus))
+		assert.Equal(t, client, session1.client)
+		assert.Nil(t, proxy.availableConnections.Get(client.ID))
+	}
+
+	// The other session waits until the transaction is finished.
+	acquired := make(chan *Client)
+	go func() {
+		client, err := proxy.acquireClient(conn2, session2, QueryMessage("SELECT 1"))
+		assert.Nil(t, err)
+		acquired <- client
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("the server connection is borrowed during the transaction")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	proxy.trackResponse(conn1, session1, client, ReadyForQuery(PostgresTxIdle))
+	assert.Nil(t, session1.client)
+	assert.Equal(t, client, <-acquired)
+	assert.Equal(t, client, session2.client)
+}
+
+// TestProxyTransactionWaitTimeout tests that a session waits for at most MaxWaitTime for the
+// server connection of another session's transaction, and that it isn't queued when the
+// queue is full.
+func TestProxyTransactionWaitTimeout(t *testing.T) {
+	proxy := newTransactionProxy(t, startFakeSQLServer(t, "primary", nil, make(chan string, 10), nil))
+	proxy.MaxWaitTime = 100 * time.Millisecond
+	conn1, session1 := startTransactionSession(t, proxy)
+	conn2, session2 := startTransactionSession(t, proxy)
+
+	client, err := proxy.acquireClient(conn1, session1, QueryMessage("BEGIN"))
+	require.Nil(t, err)
+	session1.finishRequest()
+	proxy.trackResponse(conn1, session1, client, ReadyForQuery(PostgresTxInTransaction))
+
+	start := time.Now()
+	_, err = proxy.acquireClient(conn2, session2, QueryMessage("SELECT 1"))
+	assert.ErrorIs(t, err, gerr.ErrPoolWaitTimeout)
+	assert.GreaterOrEqual(t, time.Since(start), proxy.MaxWaitTime)
+	assert.Nil(t, session2.client)
+	assert.Zero(t, proxy.borrowers)
+
+	proxy.MaxQueueLength = 0
+	_, err = proxy.acquireClient(conn2, session2, QueryMessage("SELECT 1"))
+	assert.ErrorIs(t, err, gerr.ErrPoolExhausted)
+}
+
+// replicaStartup is the answer of a replica that starts the session without a password.
+var replicaStartup = append(
+	append(Authentication(0, nil), BackendKeyData(1, 2)...), ReadyForQuery(PostgresTxIdle)...)
+
+// TestProxyReplicaRouting tests that the reads are sent to the replica, unless they are in a
+// transaction, the session has sent a write, or the replica lags behind the primary.
+func TestProxyReplicaRouting(t *testing.T) {
+	queries := make(chan string, 10)
+	var lag atomic.Value
+	lag.Store("0")
+	proxy := newTransactionProxy(t, startFakeSQLServer(t, "primary", nil, queries, &lag))
+	require.Nil(t, proxy.AddReplica(startFakeSQLServer(t, "replica", replicaStartup, queries, &lag)))
+	require.Len(t, proxy.Replicas, 1)
+	replica := proxy.Replicas[0]
+
+	conn, session := startTransactionSession(t, proxy)
+	runTransactionQuery(t, proxy, conn, session, "SELECT 1")
+	assert.Equal(t, "replica: SELECT 1", <-queries)
+
+	// The transaction stays on the primary, but it isn't a write.
+	for _, query := range []string{"BEGIN", "SELECT 1", "COMMIT"} {
+		runTransactionQuery(t, proxy, conn, session, query)
+		assert.Equal(t, "primary: "+query, <-queries)
+	}
+	runTransactionQuery(t, proxy, conn, session, "SELECT 1")
+	assert.Equal(t, "replica: SELECT 1", <-queries)
+
+	// The reads are sent to the primary while the replica lags behind.
+	lag.Store("5")
+	replica.checkReplicationLag(time.Second)
+	assert.True(t, replica.lagging.Load())
+	runTransactionQuery(t, proxy, conn, session, "SELECT 1")
+	assert.Equal(t, "primary: SELECT 1", <-queries)
+	lag.Store("0")
+	replica.checkReplicationLag(time.Second)
+	assert.False(t, replica.lagging.Load())
+	runTransactionQuery(t, proxy, conn, session, "SELECT 1")
+	assert.Equal(t, "replica: SELECT 1", <-queries)
+
+	// The session reads its own writes from the primary.
+	runTransactionQuery(t, proxy, conn, session, "INSERT INTO t VALUES (1)")
+	assert.Equal(t, "primary: INSERT INTO t VALUES (1)", <-queries)
+	assert.True(t, session.IsSticky())
+	runTransactionQuery(t, proxy, conn, session, "SELECT 1")
+	assert.Equal(t, "primary: SELECT 1", <-queries)
+
+	// Another session still reads from the replica.
+	conn, session = startTransactionSession(t, proxy)
+	runTransactionQuery(t, proxy, conn, session, "SELECT 1")
+	assert.Equal(t, "replica: SELECT 1", <-queries)
+	assert.Empty(t, queries)
+}
+
+// TestProxyReplicaFailures tests that the reads are sent to the primary if the replica
+// rejects the session, and that a replica that can't be reached isn't added.
+func TestProxyReplicaFailures(t *testing.T) {
+	queries := make(chan string, 10)
+	var lag atomic.Value
+	lag.Store("0")
+	proxy := newTransactionProxy(t, startFakeSQLServer(t, "primary", nil, queries, &lag))
+	require.Nil(t, proxy.AddReplica(startFakeSQLServer(t, "replica",
+		ErrorResponse(PostgresSeverityFatal, "28P01", "password authentication failed"), queries, &lag)))
+
+	conn, session := startTransactionSession(t, proxy)
+	runTransactionQuery(t, proxy, conn, session, "SELECT 1")
+	assert.Equal(t, "primary: SELECT 1", <-queries)
+	assert.True(t, proxy.Replicas[0].authFailures[session.key])
+	runTransactionQuery(t, proxy, conn, session, "SELECT 1")
+	assert.Equal(t, "primary: SELECT 1", <-queries)
+	assert.Empty(t, queries)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, listener.Close())
+	err = proxy.AddReplica(listener.Addr().String())
+	require.NotNil(t, err)
+	assert.ErrorIs(t, err, gerr.ErrClientConnectionFailed)
+	assert.Len(t, proxy.Replicas, 1)
+}
+
+// TestProxyReplicaPassword tests that the password of the client config is sent to the
+// replicas that request one, and that the reads are sent to the primary without it.
+func TestProxyReplicaPassword(t *testing.T) {
+	queries := make(chan string, 10)
+	replica := startFakeSQLServer(
+		t, "replica", Authentication(PostgresAuthCleartextPassword, nil), queries, nil)
+
+	proxy := newTransactionProxy(t, startFakeSQLServer(t, "primary", nil, queries, nil))
+	proxy.ClientConfig.Password = "secret"
+	require.Nil(t, proxy.AddReplica(replica))
+	conn, session := startTransactionSession(t, proxy)
+	runTransactionQuery(t, proxy, conn, session, "SELECT 1")
+	assert.Equal(t, "replica: password secret", <-queries)
+	assert.Equal(t, "replica: SELECT 1", <-queries)
+
+	proxy = newTransactionProxy(t, startFakeSQLServer(t, "primary", nil, queries, nil))
+	require.Nil(t, proxy.AddReplica(replica))
+	conn, session = startTransactionSession(t, proxy)
+	runTransactionQuery(t, proxy, conn, session, "SELECT 1")
+	assert.Equal(t, "primary: SELECT 1", <-queries)
+	assert.True(t, proxy.Replicas[0].authFailures[session.key])
+	assert.Empty(t, queries)
+}
+
 func BenchmarkNewProxy(b *testing.B) {
 	logger := logging.NewLogger(context.Background(), logging.LoggerConfig{
 		Output:            []config.LogOutput{config.Console},
@@ -147,6 +879,7 @@ func BenchmarkNewProxy(b *testing.B) {
 		proxy := NewProxy(
 			context.Background(),
 			newPool,
+			nil,
 			plugin.NewRegistry(
 				context.Background(),
 				config.Loose,
@@ -156,9 +889,9 @@ func BenchmarkNewProxy(b *testing.B) {
 				logger,
 				false,
 			),
-			false,
-			false,
-			config.DefaultHealthCheckPeriod,
+			&config.Proxy{
+				HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+			},
 			nil,
 			logger,
 			config.DefaultPluginTimeout)
@@ -183,6 +916,7 @@ func BenchmarkNewProxyElastic(b *testing.B) {
 		proxy := NewProxy(
 			context.Background(),
 			newPool,
+			nil,
 			plugin.NewRegistry(
 				context.Background(),
 				config.Loose,
@@ -192,9 +926,10 @@ func BenchmarkNewProxyElastic(b *testing.B) {
 				logger,
 				false,
 			),
-			true,
-			false,
-			config.DefaultHealthCheckPeriod,
+			&config.Proxy{
+				Elastic:           true,
+				HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+			},
 			&config.Client{
 				Network:            "tcp",
 				Address:            "localhost:5432",
@@ -239,6 +974,7 @@ func BenchmarkProxyConnectDisconnect(b *testing.B) {
 	proxy := NewProxy(
 		context.Background(),
 		newPool,
+		nil,
 		plugin.NewRegistry(
 			context.Background(),
 			config.Loose,
@@ -248,9 +984,9 @@ func BenchmarkProxyConnectDisconnect(b *testing.B) {
 			logger,
 			false,
 		),
-		false,
-		false,
-		config.DefaultHealthCheckPeriod,
+		&config.Proxy{
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+		},
 		&clientConfig,
 		logger,
 		config.DefaultPluginTimeout)
@@ -293,6 +1029,7 @@ func BenchmarkProxyPassThrough(b *testing.B) {
 	proxy := NewProxy(
 		context.Background(),
 		newPool,
+		nil,
 		plugin.NewRegistry(
 			context.Background(),
 			config.Loose,
@@ -302,9 +1039,9 @@ func BenchmarkProxyPassThrough(b *testing.B) {
 			logger,
 			false,
 		),
-		false,
-		false,
-		config.DefaultHealthCheckPeriod,
+		&config.Proxy{
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+		},
 		&clientConfig,
 		logger,
 		config.DefaultPluginTimeout)
@@ -314,12 +1051,12 @@ func BenchmarkProxyPassThrough(b *testing.B) {
 	proxy.Connect(conn.ConnWrapper)          //nolint:errcheck
 	defer proxy.Disconnect(conn.ConnWrapper) //nolint:errcheck
 
-	stack := NewStack()
+	correlator := NewCorrelator()
 
 	// Connect to the proxy
 	for i := 0; i < b.N; i++ {
-		proxy.PassThroughToClient(conn.ConnWrapper, stack) //nolint:errcheck
-		proxy.PassThroughToServer(conn.ConnWrapper, stack) //nolint:errcheck
+		proxy.PassThroughToClient(conn.ConnWrapper, correlator) //nolint:errcheck
+		proxy.PassThroughToServer(conn.ConnWrapper, correlator) //nolint:errcheck
 	}
 }
 
@@ -352,6 +1089,7 @@ func BenchmarkProxyIsHealthyAndIsExhausted(b *testing.B) {
 	proxy := NewProxy(
 		context.Background(),
 		newPool,
+		nil,
 		plugin.NewRegistry(
 			context.Background(),
 			config.Loose,
@@ -361,9 +1099,9 @@ func BenchmarkProxyIsHealthyAndIsExhausted(b *testing.B) {
 			logger,
 			false,
 		),
-		false,
-		false,
-		config.DefaultHealthCheckPeriod,
+		&config.Proxy{
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+		},
 		&clientConfig,
 		logger,
 		config.DefaultPluginTimeout)
@@ -409,6 +1147,7 @@ func BenchmarkProxyAvailableAndBusyConnections(b *testing.B) {
 	proxy := NewProxy(
 		context.Background(),
 		newPool,
+		nil,
 		plugin.NewRegistry(
 			context.Background(),
 			config.Loose,
@@ -418,9 +1157,9 @@ func BenchmarkProxyAvailableAndBusyConnections(b *testing.B) {
 			logger,
 			false,
 		),
-		false,
-		false,
-		config.DefaultHealthCheckPeriod,
+		&config.Proxy{
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+		},
 		&clientConfig,
 		logger,
 		config.DefaultPluginTimeout)
@@ -436,3 +1175,90 @@ func BenchmarkProxyAvailableAndBusyConnections(b *testing.B) {
 		proxy.BusyConnections()
 	}
 }
+
+// BenchmarkProxyQuery measures the allocations of a query that is passed through the
+// proxy, from the request of the client to the response of the server with a few rows.
+func BenchmarkProxyQuery(b *testing.B) {
+	response := CreatePostgreSQLPacket('T', []byte("\x00\x01id\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x17\x00\x04\xff\xff\xff\xff\x00\x00"))
+	for i := 0; i < 10; i++ {
+		response = append(response, CreatePostgreSQLPacket(PostgresDataRow, []byte("\x00\x01\x00\x00\x00\x011"))...)
+	}
+	response = append(response, CreatePostgreSQLPacket(PostgresCommandComplete, []byte("SELECT 10\x00"))...)
+	response = append(response, ReadyForQuery(PostgresTxIdle)...)
+
+	server := startFakeServer(b, func(conn net.Conn) {
+		framer := NewFramer(conn, config.DefaultChunkSize, false)
+		for {
+			if _, err := framer.ReadMessages(); err != nil {
+				return
+			}
+			if _, err := conn.Write(response); err != nil {
+				return
+			}
+		}
+	})
+
+	newPool := pool.NewPool(context.Background(), 1)
+	client := newFakeServerClient(b, server)
+	require.Nil(b, newPool.Put(client.ID, client))
+
+	proxy := NewProxy(
+		context.Background(),
+		newPool,
+		nil,
+		plugin.NewRegistry(
+			context.Background(),
+			config.Loose,
+			config.PassDown,
+			config.Accept,
+			config.Stop,
+			zerolog.Nop(),
+			false,
+		),
+		&config.Proxy{
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+			ResetQuery:        config.DefaultResetQuery,
+		},
+		&config.Client{
+			Network:          "tcp",
+			Address:          server,
+			ReceiveChunkSize: config.DefaultChunkSize,
+			DialTimeout:      time.Second,
+		},
+		zerolog.Nop(),
+		config.DefaultPluginTimeout,
+	)
+	defer proxy.Shutdown()
+
+	netConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	conn := NewConnWrapper(netConn, nil, config.DefaultHandshakeTimeout)
+	conn.Framer().startup = false
+	require.Nil(b, proxy.Connect(conn))
+	session, ok := proxy.sessions.Get(conn).(*Session)
+	require.True(b, ok)
+	session.started = true
+
+	correlator := NewCorrelator()
+	go func() {
+		for {
+			if proxy.PassThroughToServer(conn, correlator) != nil ||
+				proxy.PassThroughToClient(conn, correlator) != nil {
+				return
+			}
+		}
+	}()
+
+	query := QueryMessage("SELECT 1")
+	received := make([]byte, len(response))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := clientConn.Write(query); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.ReadFull(clientConn, received); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
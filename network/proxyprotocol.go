@@ -0,0 +1,257 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+)
+
+// PROXY protocol constants.
+// See https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+const (
+	// proxyV1Prefix starts the header of version 1, and proxyV1MaxLength
+	// is the maximum length of the header, including the CRLF at the end.
+	proxyV1Prefix    = "PROXY "
+	proxyV1MaxLength = 107
+	// proxyV2HeaderSize is the size of the fixed part of the header of version 2, which
+	// is followed by the addresses. The command is either LOCAL, for the connections that
+	// the proxy opens on its own, or PROXY, for the connections that it relays.
+	proxyV2HeaderSize   = 16
+	proxyV2Version      = 0x20
+	proxyV2CommandLocal = 0x00
+	proxyV2CommandProxy = 0x01
+	proxyV2FamilyInet   = 0x10
+	proxyV2FamilyInet6  = 0x20
+	proxyV2Stream       = 0x01
+	proxyV2Inet4Length  = 12
+	proxyV2Inet6Length  = 36
+)
+
+// proxyV2Signature starts the header of version 2.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtocolConn is an incoming connection that is relayed by a proxy, like a load
+// balancer. Its addresses are the addresses of the client and of the listener of the
+// proxy that are sent by the proxy in the PROXY header.
+type proxyProtocolConn struct {
+	net.Conn
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+// RemoteAddr returns the address of the client.
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// LocalAddr returns the address that the client connected to on the proxy.
+func (c *proxyProtocolConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+// CloseRead shuts down the reading side of the relayed connection.
+func (c *proxyProtocolConn) CloseRead() error {
+	if closer, ok := c.Conn.(interface{ CloseRead() error }); ok {
+		return closer.CloseRead() //nolint:wrapcheck
+	}
+	return c.Conn.SetReadDeadline(time.Now()) //nolint:wrapcheck
+}
+
+// SyscallConn returns the raw connection of the relayed connection, so that it can still be
+// waited for by the event loop.
+func (c *proxyProtocolConn) SyscallConn() (syscall.RawConn, error) {
+	if sysConn, ok := c.Conn.(syscall.Conn); ok {
+		return sysConn.SyscallConn() //nolint:wrapcheck
+	}
+	return nil, errors.ErrUnsupported
+}
+
+// IsTrustedProxy returns true if the address is in one of the trusted networks.
+// No address is trusted if there are no trusted networks.
+func IsTrustedProxy(addr net.Addr, trustedProxies []*net.IPNet) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadProxyHeader reads the PROXY header of version 1 or 2 at the start of the connection,
+// and returns the connection with the addresses of the header. The header is read without
+// reading past its end, since the client sends its first message right after it. The
+// connection is returned as is if the header has no address, like a LOCAL header.
+func ReadProxyHeader(conn net.Conn) (net.Conn, error) {
+	prefix := make([]byte, len(proxyV1Prefix))
+	if _, err := io.ReadFull(conn, prefix); err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	switch {
+	case string(prefix) == proxyV1Prefix:
+		return readProxyV1Header(conn)
+	case bytes.Equal(prefix, proxyV2Signature[:len(prefix)]):
+		return readProxyV2Header(conn, prefix)
+	default:
+		return nil, errors.New("the connection doesn't start with a PROXY header")
+	}
+}
+
+// readProxyV1Header reads the rest of a header of version 1, like
+// "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n", one byte at a time.
+func readProxyV1Header(conn net.Conn) (net.Conn, error) {
+	line := []byte(proxyV1Prefix)
+	next := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return nil, errors.New("the PROXY header is too long")
+		}
+		if _, err := io.ReadFull(conn, next); err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+		line = append(line, next[0])
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) > 1 && fields[1] == "UNKNOWN" {
+		return conn, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY header %q", strings.TrimSpace(string(line)))
+	}
+
+	source, sourceErr := parseProxyAddress(fields[2], fields[4])
+	destination, destinationErr := parseProxyAddress(fields[3], fields[5])
+	if err := errors.Join(sourceErr, destinationErr); err != nil {
+		return nil, err
+	}
+	return &proxyProtocolConn{Conn: conn, remoteAddr: source, localAddr: destination}, nil
+}
+
+// parseProxyAddress parses an address and a port of a header of version 1.
+func parseProxyAddress(address, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q in the PROXY header", address)
+	}
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q in the PROXY header", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(portNumber)}, nil
+}
+
+// readProxyV2Header reads the rest of a header of version 2, whose first bytes are read.
+// The TLVs that follow the addresses are skipped.
+func readProxyV2Header(conn net.Conn, prefix []byte) (net.Conn, error) {
+	header := make([]byte, proxyV2HeaderSize)
+	copy(header, prefix)
+	if _, err := io.ReadFull(conn, header[len(prefix):]); err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	if !bytes.Equal(header[:len(proxyV2Signature)], proxyV2Signature) ||
+		header[12]&0xf0 != proxyV2Version {
+		return nil, errors.New("invalid signature or version of the PROXY header")
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:proxyV2HeaderSize]))
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	if header[12]&0x0f == proxyV2CommandLocal {
+		return conn, nil
+	}
+
+	var source, destination *net.TCPAddr
+	switch header[13] & 0xf0 {
+	case proxyV2FamilyInet:
+		if len(payload) < proxyV2Inet4Length {
+			return nil, errors.New("the PROXY header is too short for IPv4 addresses")
+		}
+		source = &net.TCPAddr{
+			IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10])),
+		}
+		destination = &net.TCPAddr{
+			IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12])),
+		}
+	case proxyV2FamilyInet6:
+		if len(payload) < proxyV2Inet6Length {
+			return nil, errors.New("the PROXY header is too short for IPv6 addresses")
+		}
+		source = &net.TCPAddr{
+			IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34])),
+		}
+		destination = &net.TCPAddr{
+			IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36])),
+		}
+	default:
+		// The addresses of other families, like UNIX sockets, are not used.
+		return conn, nil
+	}
+	return &proxyProtocolConn{Conn: conn, remoteAddr: source, localAddr: destination}, nil
+}
+
+// ProxyHeader returns the PROXY header of the given version that tells the database server
+// the address of the client and the address that it connected to. The header has no address
+// if either of them isn't a TCP address, like for the server connections that are not opened
+// for a client: the header is UNKNOWN in version 1, and LOCAL in version 2. It returns nil if
+// no header is sent.
+func ProxyHeader(version config.ProxyProtocol, source, destination net.Addr) []byte {
+	sourceAddr, sourceOK := source.(*net.TCPAddr)
+	destinationAddr, destinationOK := destination.(*net.TCPAddr)
+	known := sourceOK && destinationOK && sourceAddr != nil && destinationAddr != nil
+
+	// Both addresses are sent in the same family, so IPv4 addresses
+	// are sent as IPv6 addresses if the other one is an IPv6 address.
+	inet4 := known && sourceAddr.IP.To4() != nil && destinationAddr.IP.To4() != nil
+
+	switch version {
+	case config.ProxyProtocolV1:
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		family, sourceIP, destinationIP := "TCP6", sourceAddr.IP.To16(), destinationAddr.IP.To16()
+		if inet4 {
+			family, sourceIP, destinationIP = "TCP4", sourceAddr.IP.To4(), destinationAddr.IP.To4()
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n",
+			family, sourceIP, destinationIP, sourceAddr.Port, destinationAddr.Port))
+	case config.ProxyProtocolV2:
+		header := append([]byte{}, proxyV2Signature...)
+		if !known {
+			return append(header, proxyV2Version|proxyV2CommandLocal, 0, 0, 0)
+		}
+
+		var addresses []byte
+		family := byte(proxyV2FamilyInet6)
+		if inet4 {
+			family = proxyV2FamilyInet
+			addresses = append(addresses, sourceAddr.IP.To4()...)
+			addresses = append(addresses, destinationAddr.IP.To4()...)
+		} else {
+			addresses = append(addresses, sourceAddr.IP.To16()...)
+			addresses = append(addresses, destinationAddr.IP.To16()...)
+		}
+		addresses = binary.BigEndian.AppendUint16(addresses, uint16(sourceAddr.Port))
+		addresses = binary.BigEndian.AppendUint16(addresses, uint16(destinationAddr.Port))
+
+		header = append(header, proxyV2Version|proxyV2CommandProxy, family|proxyV2Stream)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(addresses)))
+		return append(header, addresses...)
+	case config.ProxyProtocolNone:
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package network
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readProxyHeader writes the data to one end of a pipe, and reads the PROXY header from
+// the other end. It returns the connection and the data that follows the header.
+func readProxyHeader(t *testing.T, data []byte) (net.Conn, []byte, error) {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+	go func() {
+		_, _ = clientConn.Write(data)
+		clientConn.Close()
+	}()
+
+	conn, err := ReadProxyHeader(serverConn)
+	if err != nil {
+		return nil, nil, err
+	}
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return conn, rest, nil
+}
+
+// TestProxyHeader tests that the PROXY headers of both versions are read back with the
+// addresses of the client and the proxy, without reading the message that follows them.
+func TestProxyHeader(t *testing.T) {
+	startupMessage := CreatePgStartupPacket()
+	addresses := []struct {
+		source, destination *net.TCPAddr
+	}{
+		{
+			&net.TCPAddr{IP: net.ParseIP("192.168.0.1").To4(), Port: 56324},
+			&net.TCPAddr{IP: net.ParseIP("10.0.0.1").To4(), Port: 5432},
+		},
+		{
+			&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324},
+			&net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 5432},
+		},
+	}
+
+	for _, version := range []config.ProxyProtocol{config.ProxyProtocolV1, config.ProxyProtocolV2} {
+		for _, address := range addresses {
+			header := ProxyHeader(version, address.source, address.destination)
+			conn, rest, err := readProxyHeader(t, append(header, startupMessage...))
+			require.NoError(t, err, version)
+			assert.Equal(t, address.source.String(), conn.RemoteAddr().String(), version)
+			assert.Equal(t, address.destination.String(), conn.LocalAddr().String(), version)
+			assert.Equal(t, startupMessage, rest, version)
+		}
+
+		// The header without address keeps the addresses of the connection.
+		conn, rest, err := readProxyHeader(t, append(ProxyHeader(version, nil, nil), startupMessage...))
+		require.NoError(t, err, version)
+		assert.Equal(t, "pipe", conn.RemoteAddr().Network(), version)
+		assert.Equal(t, startupMessage, rest, version)
+	}
+	assert.Equal(t, "PROXY TCP4 192.168.0.1 10.0.0.1 56324 5432\r\n",
+		string(ProxyHeader(config.ProxyProtocolV1, addresses[0].source, addresses[0].destination)))
+	assert.Nil(t, ProxyHeader(config.ProxyProtocolNone, addresses[0].source, addresses[0].destination))
+
+	// The connections without a valid header are rejected.
+	_, _, err := readProxyHeader(t, startupMessage)
+	assert.Error(t, err)
+	_, _, err = readProxyHeader(t, []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324\r\n"))
+	assert.Error(t, err)
+	_, _, err = readProxyHeader(t, []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 5432"))
+	assert.Error(t, err)
+}
+
+// TestIsTrustedProxy tests that the PROXY header is only read from the trusted proxies.
+func TestIsTrustedProxy(t *testing.T) {
+	trustedProxies, err := config.ParseCIDRs([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	assert.True(t, IsTrustedProxy(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}, trustedProxies))
+	assert.False(t, IsTrustedProxy(&net.TCPAddr{IP: net.ParseIP("192.168.0.1")}, trustedProxies))
+	assert.False(t, IsTrustedProxy(&net.UnixAddr{Name: "/tmp/.s.PGSQL.5432"}, trustedProxies))
+	assert.False(t, IsTrustedProxy(&net.TCPAddr{IP: net.ParseIP("192.168.0.1")}, nil))
+}
@@ -0,0 +1,337 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/gatewayd-io/gatewayd/pool"
+	"github.com/getsentry/sentry-go"
+	"go.opentelemetry.io/otel"
+)
+
+// ReplicationLagQuery returns the replication lag of a replica in seconds. The lag is zero
+// if the replica has replayed all the WAL it received, since pg_last_xact_replay_timestamp
+// keeps its value while there is no write on the primary. It returns NULL on the primary.
+const ReplicationLagQuery = "SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() " +
+	"THEN 0 ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()) END"
+
+// AddReplica creates a proxy with its own pool of server connections for the read replica
+// at the given address. In transaction pooling mode, the read-only transactions are sent to
+// the replicas, unless the session has sent a write or the replica lags behind the primary.
+// The connections to the replica authenticate with the credentials of the client config, like
+// the ones to the primary. In passthrough mode, the sessions are started on the replica with
+// the StartupMessage that the client sent to the primary, and the password of the client
+// config is sent if the replica requests one.
+func (pr *Proxy) AddReplica(address string) *gerr.GatewayDError {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "AddReplica")
+	defer span.End()
+
+	clientConfig := *pr.ClientConfig
+	clientConfig.Address = address
+	clientConfig.Addresses = nil
+
+	size := config.If[int](
+		pr.availableConnections.Cap() > 0,
+		pr.availableConnections.Cap(),
+		config.DefaultPoolSize,
+	)
+	replicaPool := pool.NewPool(pr.ctx, size)
+	replica := NewProxy(
+		pr.ctx,
+		replicaPool,
+		&config.Pool{
+			Size:        size,
+			MaxLifetime: pr.MaxLifetime,
+			MaxIdleTime: pr.MaxIdleTime,
+			MinIdle:     pr.MinIdle,
+		},
+		pr.pluginRegistry,
+		&config.Proxy{
+			HealthCheckPeriod:  pr.HealthCheckPeriod,
+			HealthCheckType:    string(pr.HealthCheckType),
+			HealthCheckQuery:   pr.HealthCheckQuery,
+			HealthCheckTimeout: pr.HealthCheckTimeout,
+			PoolMode:           string(config.Transaction),
+		},
+		&clientConfig,
+		pr.logger,
+		pr.pluginTimeout,
+	)
+
+	for i := 0; i < size; i++ {
+		client := replica.newClient()
+		if client == nil || client.ID == "" {
+			replica.Shutdown()
+			err := gerr.ErrClientConnectionFailed.Wrap(
+				fmt.Errorf("failed to connect to the replica %s", address))
+			span.RecordError(err)
+			return err
+		}
+		if err := replicaPool.Put(client.ID, client); err != nil {
+			client.Close()
+			replica.Shutdown()
+			span.RecordError(err)
+			return err
+		}
+	}
+
+	if pr.MaxReplicaLag > 0 {
+		lagCheck := config.If[time.Duration](
+			pr.ReplicaLagCheck > 0,
+			pr.ReplicaLagCheck,
+			config.DefaultReplicaLagCheck,
+		)
+		if _, err := replica.scheduler.Every(lagCheck).SingletonMode().Do(
+			func() {
+				replica.checkReplicationLag(pr.MaxReplicaLag)
+			},
+		); err != nil {
+			pr.logger.Error().Err(err).Msg("Failed to schedule the replication lag check")
+			sentry.CaptureException(err)
+			span.RecordError(err)
+		}
+	}
+
+	replica.Name = pr.Name + "/" + address
+	pr.Replicas = append(pr.Replicas, replica)
+
+	pr.logger.Info().Fields(
+		map[string]interface{}{
+			"address":       address,
+			"size":          size,
+			"maxReplicaLag": pr.MaxReplicaLag.String(),
+		},
+	).Msg("Added a read replica to the proxy")
+
+	return nil
+}
+
+// borrowReplicaClient returns a client of the next replica that doesn't lag behind, along
+// with the proxy of the replica, or nil if none of the replicas can take the session. An
+// idle client that is authenticated for the session key is preferred, otherwise a new
+// session is started on the replica with the StartupMessage of the session.
+func (pr *Proxy) borrowReplicaClient(key string, startupMessage []byte) (*Client, *Proxy) {
+	pr.mu.Lock()
+	next := pr.nextReplica
+	pr.nextReplica = (pr.nextReplica + 1) % len(pr.Replicas)
+	pr.mu.Unlock()
+
+	for i := range pr.Replicas {
+		replica := pr.Replicas[(next+i)%len(pr.Replicas)]
+		if replica.lagging.Load() {
+			continue
+		}
+
+		replica.mu.Lock()
+		client := replica.popClient(func(cl *Client) bool {
+			return cl.sessionKey == key || cl.authenticatesItself()
+		})
+		failed := replica.authFailures[key]
+		replica.mu.Unlock()
+
+		if client == nil && !failed {
+			client = replica.startReplicaSession(key, startupMessage)
+		}
+		if client != nil {
+			return client, replica
+		}
+	}
+
+	return nil, nil
+}
+
+// startReplicaSession starts a new session on the replica for the given session key.
+// If the replica rejects the session, the reads of the session key are no longer sent
+// to the replica.
+func (pr *Proxy) startReplicaSession(key string, startupMessage []byte) *Client {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "startReplicaSession")
+	defer span.End()
+
+	client := pr.popUnauthenticatedClient()
+	if client == nil || client.authenticatesItself() {
+		return client
+	}
+
+	err := pr.authenticateReplicaClient(client, startupMessage)
+	if err == nil {
+		return client
+	}
+
+	span.RecordError(err)
+	if errors.Is(err, gerr.ErrReplicaAuthFailed) {
+		pr.mu.Lock()
+		pr.authFailures[key] = true
+		pr.mu.Unlock()
+
+		pr.logger.Warn().Err(err).Fields(
+			map[string]interface{}{
+				"replica": pr.ClientConfig.Address,
+				"session": key,
+			},
+		).Msg("The reads of the session are sent to the primary from now on")
+	} else {
+		pr.logger.Error().Err(err).Str("replica", pr.ClientConfig.Address).Msg(
+			"Failed to start a session on the replica")
+	}
+
+	// The server connection can't be used for another session after a failed startup.
+	if err := client.Reconnect(); err != nil {
+		pr.logger.Error().Err(err).Msg("Failed to reconnect to the client")
+		span.RecordError(err)
+	}
+	pr.releaseClient(client, "")
+
+	return nil
+}
+
+// authenticateReplicaClient sends the StartupMessage of the session to the replica and
+// waits until the session is started. The password of the client is not known, so the
+// password of the client config is sent for the user of the session if the replica
+// requests one.
+func (pr *Proxy) authenticateReplicaClient(client *Client, startupMessage []byte) *gerr.GatewayDError {
+	if _, err := client.Send(startupMessage); err != nil {
+		return err
+	}
+
+	user := ParseStartupMessage(startupMessage)["user"]
+	scram := NewSCRAMClient(client.password)
+
+	for {
+		_, response, err := client.Receive()
+		if err != nil {
+			return err
+		}
+
+		var authErr error
+		ForEachMessage(response, func(msgType byte, body []byte) bool {
+			switch msgType {
+			case PostgresAuthentication:
+				if len(body) >= PostgresLengthSize &&
+					binary.BigEndian.Uint32(body) != PostgresAuthOk && client.password == "" {
+					authErr = fmt.Errorf(
+						"the replica requested the authentication method %d, "+
+							"but the client config has no password",
+						binary.BigEndian.Uint32(body))
+				} else {
+					authErr = client.answerAuthentication(body, user, scram)
+				}
+			case PostgresBackendKeyData:
+				if len(body) == 2*PostgresLengthSize {
+					client.backendKey = &CancelKey{
+						ProcessID: binary.BigEndian.Uint32(body[:PostgresLengthSize]),
+						SecretKey: binary.BigEndian.Uint32(body[PostgresLengthSize:]),
+					}
+				}
+			case PostgresErrorResponse:
+				authErr = errors.New(ErrorResponseMessage(body))
+			}
+			return authErr == nil
+		})
+		if authErr != nil {
+			return gerr.ErrReplicaAuthFailed.Wrap(authErr)
+		}
+
+		if count, _ := ReadyForQueryStatus(response); count > 0 {
+			return nil
+		}
+	}
+}
+
+// checkReplicationLag measures the replication lag of the replica with one of its idle
+// server connections, and stops sending reads to the replica while its lag is greater
+// than the maximum. The lag is not measured until a session is started on the replica.
+func (pr *Proxy) checkReplicationLag(maxLag time.Duration) {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "checkReplicationLag")
+	defer span.End()
+
+	pr.mu.Lock()
+	client := pr.popClient(func(cl *Client) bool { return cl.sessionKey != "" })
+	pr.mu.Unlock()
+	if client == nil {
+		pr.logger.Trace().Str("replica", pr.ClientConfig.Address).Msg(
+			"No idle server connection to measure the replication lag")
+		return
+	}
+
+	lag, err := pr.measureReplicationLag(client)
+	if err != nil {
+		pr.logger.Error().Err(err).Str("replica", pr.ClientConfig.Address).Msg(
+			"Failed to measure the replication lag")
+		span.RecordError(err)
+
+		pr.mu.Lock()
+		pr.untagClient(client)
+		pr.mu.Unlock()
+		if err := client.Reconnect(); err != nil {
+			pr.logger.Error().Err(err).Msg("Failed to reconnect to the client")
+			span.RecordError(err)
+		}
+	}
+	pr.releaseClient(client, "")
+	if err != nil {
+		return
+	}
+
+	lagging := lag > maxLag
+	if pr.lagging.Swap(lagging) == lagging {
+		return
+	}
+
+	fields := map[string]interface{}{
+		"replica":       pr.ClientConfig.Address,
+		"lag":           lag.String(),
+		"maxReplicaLag": maxLag.String(),
+	}
+	if lagging {
+		pr.logger.Warn().Fields(fields).Msg("The replica lags behind, so the reads are sent to the primary")
+	} else {
+		pr.logger.Info().Fields(fields).Msg("The replica caught up, so the reads are sent to it again")
+	}
+}
+
+// measureReplicationLag runs the ReplicationLagQuery with the client.
+func (pr *Proxy) measureReplicationLag(client *Client) (time.Duration, *gerr.GatewayDError) {
+	if _, err := client.Send(QueryMessage(ReplicationLagQuery)); err != nil {
+		return 0, err
+	}
+
+	var lag time.Duration
+	var queryErr error
+	for {
+		_, response, err := client.Receive()
+		if err != nil {
+			return 0, err
+		}
+
+		ForEachMessage(response, func(msgType byte, body []byte) bool {
+			switch msgType {
+			case PostgresDataRow:
+				// The lag is NULL if the server is not a replica.
+				if values := DataRowValues(body); len(values) > 0 && values[0] != nil {
+					seconds, err := strconv.ParseFloat(string(values[0]), 64)
+					if err != nil {
+						queryErr = err
+					}
+					lag = time.Duration(seconds * float64(time.Second))
+				}
+			case PostgresErrorResponse:
+				queryErr = errors.New(ErrorResponseMessage(body))
+			}
+			return true
+		})
+
+		if count, _ := ReadyForQueryStatus(response); count > 0 {
+			break
+		}
+	}
+
+	if queryErr != nil {
+		return 0, gerr.ErrClientReceiveFailed.Wrap(queryErr)
+	}
+	return lag, nil
+}
@@ -0,0 +1,35 @@
+package network
+
+// Route assigns the incoming connections to a proxy based on the parameters of their
+// StartupMessage. An empty parameter matches any value, so a route without parameters
+// matches all the connections.
+type Route struct {
+	Database        string
+	User            string
+	ApplicationName string
+	Proxy           IProxy
+}
+
+// Matches returns true if the parameters of the StartupMessage match the route.
+// As in PostgreSQL, the database defaults to the user name if it is not set.
+func (r Route) Matches(parameters map[string]string) bool {
+	database := parameters["database"]
+	if database == "" {
+		database = parameters["user"]
+	}
+
+	return (r.Database == "" || r.Database == database) &&
+		(r.User == "" || r.User == parameters["user"]) &&
+		(r.ApplicationName == "" || r.ApplicationName == parameters["application_name"])
+}
+
+// FindRoute returns the proxy of the first route that matches the parameters
+// of the StartupMessage, or nil if none of the routes match.
+func FindRoute(routes []Route, parameters map[string]string) IProxy {
+	for _, route := range routes {
+		if route.Matches(parameters) {
+			return route.Proxy
+		}
+	}
+	return nil
+}
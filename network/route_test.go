@@ -0,0 +1,39 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRouteMatches tests the Matches function.
+func TestRouteMatches(t *testing.T) {
+	parameters := map[string]string{
+		"user":             "postgres",
+		"database":         "analytics",
+		"application_name": "psql",
+	}
+
+	assert.True(t, Route{}.Matches(parameters))
+	assert.True(t, Route{Database: "analytics", User: "postgres"}.Matches(parameters))
+	assert.True(t, Route{ApplicationName: "psql"}.Matches(parameters))
+	assert.False(t, Route{Database: "postgres"}.Matches(parameters))
+	assert.False(t, Route{Database: "analytics", User: "admin"}.Matches(parameters))
+
+	// The database defaults to the user name.
+	assert.True(t, Route{Database: "postgres"}.Matches(map[string]string{"user": "postgres"}))
+}
+
+// TestFindRoute tests that the first matching route is used.
+func TestFindRoute(t *testing.T) {
+	analytics := &Proxy{}
+	fallback := &Proxy{}
+	routes := []Route{
+		{Database: "analytics", Proxy: analytics},
+		{Proxy: fallback},
+	}
+
+	assert.Equal(t, analytics, FindRoute(routes, map[string]string{"database": "analytics"}))
+	assert.Equal(t, fallback, FindRoute(routes, map[string]string{"database": "postgres"}))
+	assert.Nil(t, FindRoute(routes[:1], map[string]string{"database": "postgres"}))
+}
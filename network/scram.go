@@ -0,0 +1,225 @@
+package network
+
+import (
+	"crypto/hmac"
+	"crypto/md5" //nolint:gosec
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// SCRAM-SHA-256 constants, which match the ones of PostgreSQL.
+// See https://www.postgresql.org/docs/current/sasl-authentication.html
+const (
+	SCRAMSHA256         = "SCRAM-SHA-256"
+	SCRAMIterations     = 4096
+	scramSaltSize       = 16
+	scramNonceSize      = 18
+	scramGS2Header      = "n,,"
+	scramChannelBinding = "biws" // The base64 of the GS2 header.
+)
+
+// SCRAMSecret is a SCRAM-SHA-256 secret, like the ones of pg_authid, which is stored as
+// SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>. It verifies the proof of a
+// client without knowing its password.
+type SCRAMSecret struct {
+	Iterations int
+	Salt       []byte
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// NewSCRAMSecret creates the secret of the password with a random salt.
+func NewSCRAMSecret(password string) *SCRAMSecret {
+	salt := make([]byte, scramSaltSize)
+	_, _ = rand.Read(salt)
+
+	_, storedKey, serverKey := scramKeys(password, salt, SCRAMIterations)
+	return &SCRAMSecret{
+		Iterations: SCRAMIterations,
+		Salt:       salt,
+		StoredKey:  storedKey,
+		ServerKey:  serverKey,
+	}
+}
+
+// ParseSCRAMSecret parses a stored SCRAM-SHA-256 secret.
+// It returns false if the secret is not a SCRAM-SHA-256 secret.
+func ParseSCRAMSecret(secret string) (*SCRAMSecret, bool) {
+	method, rest, _ := strings.Cut(secret, "$")
+	salt, keys, _ := strings.Cut(rest, "$")
+	iterations, salt, _ := strings.Cut(salt, ":")
+	storedKey, serverKey, _ := strings.Cut(keys, ":")
+	if method != SCRAMSHA256 {
+		return nil, false
+	}
+
+	var err error
+	var scramSecret SCRAMSecret
+	if scramSecret.Iterations, err = strconv.Atoi(iterations); err != nil || scramSecret.Iterations <= 0 {
+		return nil, false
+	}
+	if scramSecret.Salt, err = base64.StdEncoding.DecodeString(salt); err != nil {
+		return nil, false
+	}
+	if scramSecret.StoredKey, err = base64.StdEncoding.DecodeString(storedKey); err != nil ||
+		len(scramSecret.StoredKey) != sha256.Size {
+		return nil, false
+	}
+	if scramSecret.ServerKey, err = base64.StdEncoding.DecodeString(serverKey); err != nil ||
+		len(scramSecret.ServerKey) != sha256.Size {
+		return nil, false
+	}
+	return &scramSecret, true
+}
+
+// String returns the secret in the format of pg_authid.
+func (s *SCRAMSecret) String() string {
+	return fmt.Sprintf("%s$%d:%s$%s:%s",
+		SCRAMSHA256,
+		s.Iterations,
+		base64.StdEncoding.EncodeToString(s.Salt),
+		base64.StdEncoding.EncodeToString(s.StoredKey),
+		base64.StdEncoding.EncodeToString(s.ServerKey))
+}
+
+// VerifyProof returns true if the proof of the client was computed
+// with the password of the secret for the auth message.
+func (s *SCRAMSecret) VerifyProof(authMessage string, proof []byte) bool {
+	if len(proof) != sha256.Size {
+		return false
+	}
+	clientKey := xorBytes(proof, hmacSHA256(s.StoredKey, authMessage))
+	storedKey := sha256.Sum256(clientKey)
+	return subtle.ConstantTimeCompare(storedKey[:], s.StoredKey) == 1
+}
+
+// ServerSignature returns the signature that proves to the client that the server knows the secret.
+func (s *SCRAMSecret) ServerSignature(authMessage string) []byte {
+	return hmacSHA256(s.ServerKey, authMessage)
+}
+
+// SCRAMClient is the client side of a SCRAM-SHA-256 exchange, which is used by the
+// server connections to authenticate with the password of the client config.
+type SCRAMClient struct {
+	password        string
+	nonce           string
+	clientFirstBare string
+	authMessage     string
+	serverKey       []byte
+}
+
+// NewSCRAMClient creates the client side of a SCRAM-SHA-256 exchange with a random nonce.
+func NewSCRAMClient(password string) *SCRAMClient {
+	return &SCRAMClient{password: password, nonce: scramNonce()}
+}
+
+// ClientFirstMessage returns the client-first-message. The user name is sent in the
+// StartupMessage instead, so it is left empty, like PostgreSQL clients do.
+func (c *SCRAMClient) ClientFirstMessage() string {
+	c.clientFirstBare = "n=,r=" + c.nonce
+	return scramGS2Header + c.clientFirstBare
+}
+
+// ClientFinalMessage returns the client-final-message for the server-first-message.
+func (c *SCRAMClient) ClientFinalMessage(serverFirst string) (string, error) {
+	attributes := parseSCRAMAttributes(serverFirst)
+	nonce := attributes['r']
+	if !strings.HasPrefix(nonce, c.nonce) || len(nonce) == len(c.nonce) {
+		return "", errors.New("the server sent an invalid SCRAM nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attributes['s'])
+	if err != nil {
+		return "", fmt.Errorf("the server sent an invalid SCRAM salt: %w", err)
+	}
+	iterations, err := strconv.Atoi(attributes['i'])
+	if err != nil || iterations <= 0 {
+		return "", errors.New("the server sent an invalid SCRAM iteration count")
+	}
+
+	clientKey, storedKey, serverKey := scramKeys(c.password, salt, iterations)
+	clientFinalWithoutProof := "c=" + scramChannelBinding + ",r=" + nonce
+	c.authMessage = c.clientFirstBare + "," + serverFirst + "," + clientFinalWithoutProof
+	c.serverKey = serverKey
+
+	proof := xorBytes(clientKey, hmacSHA256(storedKey, c.authMessage))
+	return clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// VerifyServerFinal returns an error if the server-final-message doesn't prove
+// that the server knows the password.
+func (c *SCRAMClient) VerifyServerFinal(serverFinal string) error {
+	attributes := parseSCRAMAttributes(serverFinal)
+	if message, ok := attributes['e']; ok {
+		return fmt.Errorf("the server rejected the SCRAM exchange: %s", message)
+	}
+	signature, err := base64.StdEncoding.DecodeString(attributes['v'])
+	if err != nil || !hmac.Equal(signature, hmacSHA256(c.serverKey, c.authMessage)) {
+		return errors.New("the server sent an invalid SCRAM signature")
+	}
+	return nil
+}
+
+// MD5Password returns the md5 hash of the password of the user, like the ones of pg_authid.
+func MD5Password(user, password string) string {
+	sum := md5.Sum([]byte(password + user)) //nolint:gosec
+	return "md5" + hex.EncodeToString(sum[:])
+}
+
+// MD5Response returns the response of the md5 password exchange for the md5 hash of the
+// password and the salt sent by the server.
+func MD5Response(md5Password string, salt []byte) string {
+	sum := md5.Sum(append([]byte(strings.TrimPrefix(md5Password, "md5")), salt...)) //nolint:gosec
+	return "md5" + hex.EncodeToString(sum[:])
+}
+
+// scramKeys derives the ClientKey, StoredKey and ServerKey of the password.
+func scramKeys(password string, salt []byte, iterations int) ([]byte, []byte, []byte) {
+	saltedPassword := pbkdf2.Key([]byte(password), salt, iterations, sha256.Size, sha256.New)
+	clientKey := hmacSHA256(saltedPassword, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	return clientKey, storedKey[:], hmacSHA256(saltedPassword, "Server Key")
+}
+
+// scramNonce returns a random printable nonce.
+func scramNonce() string {
+	nonce := make([]byte, scramNonceSize)
+	_, _ = rand.Read(nonce)
+	return base64.StdEncoding.EncodeToString(nonce)
+}
+
+// parseSCRAMAttributes parses the comma-separated attributes of a SCRAM message,
+// like r=nonce,s=salt,i=4096.
+func parseSCRAMAttributes(message string) map[byte]string {
+	attributes := map[byte]string{}
+	for _, attribute := range strings.Split(message, ",") {
+		if len(attribute) >= 2 && attribute[1] == '=' {
+			attributes[attribute[0]] = attribute[2:]
+		}
+	}
+	return attributes
+}
+
+// hmacSHA256 returns the HMAC-SHA-256 of the data with the key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// xorBytes returns the XOR of two slices of the same length.
+func xorBytes(a, b []byte) []byte {
+	result := make([]byte, len(a))
+	for i := range a {
+		result[i] = a[i] ^ b[i]
+	}
+	return result
+}
@@ -5,8 +5,10 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"maps"
 	"net"
 	"os"
+	"slices"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -24,6 +26,31 @@ import (
 
 type Option struct {
 	EnableTicker bool
+	EventLoop    bool
+	// AcceptProxyProtocol reads the PROXY header of the connections from the trusted proxies.
+	AcceptProxyProtocol bool
+	TrustedProxies      []*net.IPNet
+	// ShutdownTimeout is how long the connections are drained on shutdown, before they are
+	// closed. 0 closes them right away.
+	ShutdownTimeout time.Duration
+	// MaxConnections and MaxConnectionsPerClientIP reject the connections over the limit of
+	// the server and of the client IP, and SoftLimit logs a warning for the connections over
+	// it. 0 disables the limit.
+	MaxConnections            int
+	SoftLimit                 int
+	MaxConnectionsPerClientIP int
+	// Allow and Deny are the networks that the clients are allowed and denied from. A client
+	// is rejected if it is in a denied network, or if it isn't in any allowed network while
+	// there are some. They can be replaced at runtime with SetAccessLists.
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+	// ClientIdleTimeout closes the connections whose session is idle for longer, so that their
+	// server connection is released. 0 disables the timeout.
+	ClientIdleTimeout time.Duration
+	// TCPKeepAlive sends keepalive probes on the connections every TCPKeepAlivePeriod,
+	// so that the connections of the dead clients are closed.
+	TCPKeepAlive       bool
+	TCPKeepAlivePeriod time.Duration
 }
 
 type Action int
@@ -34,6 +61,13 @@ const (
 	Shutdown
 )
 
+// drainInterval is how often the idle connections are disconnected while the server drains.
+const drainInterval = 100 * time.Millisecond
+
+// idleCheckInterval is how often the connections are checked against the idle timeout,
+// unless the timeout is shorter.
+const idleCheckInterval = time.Second
+
 type IServer interface {
 	OnBoot() Action
 	OnOpen(conn *ConnWrapper) ([]byte, Action)
@@ -49,6 +83,7 @@ type IServer interface {
 
 type Server struct {
 	proxy          IProxy
+	proxies        map[*ConnWrapper]IProxy
 	logger         zerolog.Logger
 	pluginRegistry *plugin.Registry
 	ctx            context.Context //nolint:containedctx
@@ -60,6 +95,7 @@ type Server struct {
 	Options      Option
 	Status       config.Status
 	TickInterval time.Duration
+	Routes       []Route
 
 	// TLS config
 	EnableTLS        bool
@@ -68,11 +104,19 @@ type Server struct {
 	HandshakeTimeout time.Duration
 
 	listener    net.Listener
+	poller      *poller
 	host        string
 	port        int
 	connections uint32
 	running     *atomic.Bool
 	stopServer  chan struct{}
+	// clientIPs are the number of connections per client IP, and rejected is the number of
+	// connections rejected by the limits. They are guarded by mu, like the connections.
+	clientIPs map[string]int
+	rejected  uint64
+	// allow and deny are the current allow and deny lists, guarded by mu.
+	allow []*net.IPNet
+	deny  []*net.IPNet
 }
 
 var _ IServer = (*Server)(nil)
@@ -125,7 +169,7 @@ func (s *Server) OnBoot() Action {
 
 // OnOpen is called when a new connection is opened. It calls the OnOpening and OnOpened hooks.
 // It also checks if the server is at the soft or hard limit and closes the connection if it is.
-func (s *Server) OnOpen(conn *ConnWrapper) ([]byte, Action) {
+func (s *Server) OnOpen(conn *ConnWrapper) (out []byte, action Action) {
 	_, span := otel.Tracer("gatewayd").Start(s.ctx, "OnOpen")
 	defer span.End()
 
@@ -149,22 +193,88 @@ func (s *Server) OnOpen(conn *ConnWrapper) ([]byte, Action) {
 	}
 	span.AddEvent("Ran the OnOpening hooks")
 
-	// Use the proxy to connect to the backend. Close the connection if the pool is exhausted.
+	// Reject the connection over the limits before reading anything from it, so that the
+	// connections that haven't sent their StartupMessage yet are counted too.
+	if err := s.admitConnection(conn); err != nil {
+		s.logger.Warn().Err(err).Str("from", RemoteAddr(conn.Conn())).Msg(
+			"Rejected the connection over the connection limit")
+		span.RecordError(err)
+		return GatewayDErrorResponse(err), Close
+	}
+	// The connection is no longer counted if it isn't opened.
+	defer func() {
+		if action != None {
+			s.releaseConnection(conn)
+		}
+	}()
+
+	// Answer the encryption requests and read the StartupMessage or the CancelRequest.
+	startupMessage, err := s.readStartupMessage(conn)
+	if err != nil {
+		s.logger.Debug().Err(err).Str("from", RemoteAddr(conn.Conn())).Msg(
+			"Failed to read the startup message")
+		span.RecordError(err)
+		return nil, Close
+	}
+
+	// The client closes the connection after sending a CancelRequest, so it isn't proxied.
+	if IsPostgresCancelRequest(startupMessage) {
+		if err := s.cancelQuery(startupMessage); err != nil {
+			s.logger.Warn().Err(err).Str("from", RemoteAddr(conn.Conn())).Msg(
+				"Failed to cancel the query")
+			span.RecordError(err)
+		}
+		return nil, Close
+	}
+	conn.Framer().UnreadStartupMessage(startupMessage)
+
+	// Find the proxy of the connection from its StartupMessage, if the server has routes.
+	proxy, err := s.routeConnection(conn, startupMessage)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("from", RemoteAddr(conn.Conn())).Msg(
+			"Failed to route the connection")
+		span.RecordError(err)
+
+		// Send the error to the client and close the connection.
+		return GatewayDErrorResponse(err), Close
+	}
+
+	// Use the proxy to connect to the backend. Close the connection if the pool is exhausted
+	// and there is no room in the wait queue, or if the wait times out.
 	// This effectively get a connection from the pool and puts both the incoming and the server
 	// connections in the pool of the busy connections.
-	if err := s.proxy.Connect(conn); err != nil {
-		if errors.Is(err, gerr.ErrPoolExhausted) {
-			span.RecordError(err)
-			return nil, Close
+	if err := proxy.Connect(conn); err != nil {
+		if errors.Is(err, gerr.ErrPoolExhausted) || errors.Is(err, gerr.ErrPoolWaitTimeout) {
+			s.logger.Warn().Err(err).Str("from", RemoteAddr(conn.Conn())).Msg(
+				"No server connection is available for the client")
+		} else {
+			// This should never happen.
+			s.logger.Error().Err(err).Msg("Failed to connect to proxy")
 		}
+		span.RecordError(err)
 
-		// This should never happen.
-		// TODO: Send error to client or retry connection
-		s.logger.Error().Err(err).Msg("Failed to connect to proxy")
+		// Send the error to the client and close the connection.
+		return GatewayDErrorResponse(err), Close
+	}
+
+	// Authenticate the client, if the proxy authenticates the clients instead of the server.
+	// The OnClose hook doesn't run after closing here, so the connection is disconnected.
+	if err := proxy.Authenticate(conn); err != nil {
+		s.logger.Warn().Err(err).Str("from", RemoteAddr(conn.Conn())).Msg(
+			"Failed to authenticate the client")
 		span.RecordError(err)
-		return nil, None
+		if err := proxy.Disconnect(conn); err != nil {
+			s.logger.Error().Err(err).Msg("Failed to disconnect from the proxy")
+		}
+
+		// Send the error to the client and close the connection.
+		return GatewayDErrorResponse(err), Close
 	}
 
+	s.mu.Lock()
+	s.proxies[conn] = proxy
+	s.mu.Unlock()
+
 	// Run the OnOpened hooks.
 	pluginTimeoutCtx, cancel = context.WithTimeout(context.Background(), s.pluginTimeout)
 	defer cancel()
@@ -229,7 +339,11 @@ func (s *Server) OnClose(conn *ConnWrapper, err error) Action {
 	// Disconnect the connection from the proxy. This effectively removes the mapping between
 	// the incoming and the server connections in the pool of the busy connections and either
 	// recycles or disconnects the connections.
-	if err := s.proxy.Disconnect(conn); err != nil {
+	proxy := s.getProxy(conn)
+	s.mu.Lock()
+	delete(s.proxies, conn)
+	s.mu.Unlock()
+	if err := proxy.Disconnect(conn); err != nil {
 		s.logger.Error().Err(err).Msg("Failed to disconnect the server connection")
 		span.RecordError(err)
 		return Close
@@ -280,59 +394,71 @@ func (s *Server) OnTraffic(conn *ConnWrapper, stopConnection chan struct{}) Acti
 	defer span.End()
 
 	// Run the OnTraffic hooks.
-	pluginTimeoutCtx, cancel := context.WithTimeout(context.Background(), s.pluginTimeout)
-	defer cancel()
-
-	onTrafficData := map[string]interface{}{
-		"client": map[string]interface{}{
-			"local":  LocalAddr(conn.Conn()),
-			"remote": RemoteAddr(conn.Conn()),
-		},
-	}
-	_, err := s.pluginRegistry.Run(
-		pluginTimeoutCtx, onTrafficData, v1.HookName_HOOK_NAME_ON_TRAFFIC)
-	if err != nil {
-		s.logger.Error().Err(err).Msg("Failed to run OnTraffic hook")
-		span.RecordError(err)
-	}
+	s.runOnTrafficHooks(conn)
 	span.AddEvent("Ran the OnTraffic hooks")
 
-	stack := NewStack()
+	correlator := NewCorrelator()
+	proxy := s.getProxy(conn)
 
 	// Pass the traffic from the client to server.
 	// If there is an error, log it and close the connection.
-	go func(server *Server, conn *ConnWrapper, stopConnection chan struct{}, stack *Stack) {
+	go func(server *Server, conn *ConnWrapper, stopConnection chan struct{}, correlator *Correlator) {
 		for {
 			server.logger.Trace().Msg("Passing through traffic from client to server")
-			if err := server.proxy.PassThroughToServer(conn, stack); err != nil {
+			if err := proxy.PassThroughToServer(conn, correlator); err != nil {
 				server.logger.Trace().Err(err).Msg("Failed to pass through traffic")
 				span.RecordError(err)
+				proxy.SendError(conn, err)
 				stopConnection <- struct{}{}
 				break
 			}
 		}
-	}(s, conn, stopConnection, stack)
+	}(s, conn, stopConnection, correlator)
 
 	// Pass the traffic from the server to client.
 	// If there is an error, log it and close the connection.
-	go func(server *Server, conn *ConnWrapper, stopConnection chan struct{}, stack *Stack) {
+	go func(server *Server, conn *ConnWrapper, stopConnection chan struct{}, correlator *Correlator) {
 		for {
 			server.logger.Trace().Msg("Passing through traffic from server to client")
-			if err := server.proxy.PassThroughToClient(conn, stack); err != nil {
+			if err := proxy.PassThroughToClient(conn, correlator); err != nil {
 				server.logger.Trace().Err(err).Msg("Failed to pass through traffic")
 				span.RecordError(err)
+				proxy.SendError(conn, err)
 				stopConnection <- struct{}{}
 				break
 			}
 		}
-	}(s, conn, stopConnection, stack)
+	}(s, conn, stopConnection, correlator)
 
 	<-stopConnection
-	stack.Clear()
+	correlator.Clear()
 
 	return Close
 }
 
+// runOnTrafficHooks runs the OnTraffic hooks, before the traffic of the connection is passed
+// through to the proxied connection.
+func (s *Server) runOnTrafficHooks(conn *ConnWrapper) {
+	_, span := otel.Tracer("gatewayd").Start(s.ctx, "runOnTrafficHooks")
+	defer span.End()
+
+	pluginTimeoutCtx, cancel := context.WithTimeout(context.Background(), s.pluginTimeout)
+	defer cancel()
+
+	onTrafficData := map[string]interface{}{
+		"client": map[string]interface{}{
+			"local":  LocalAddr(conn.Conn()),
+			"remote": RemoteAddr(conn.Conn()),
+		},
+	}
+	_, err := s.pluginRegistry.Run(
+		pluginTimeoutCtx, onTrafficData, v1.HookName_HOOK_NAME_ON_TRAFFIC)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to run OnTraffic hook")
+		span.RecordError(err)
+	}
+}
+
 // OnShutdown is called when the server is shutting down. It calls the OnShutdown hooks.
 func (s *Server) OnShutdown() {
 	_, span := otel.Tracer("gatewayd").Start(s.ctx, "OnShutdown")
@@ -352,14 +478,6 @@ func (s *Server) OnShutdown() {
 		span.RecordError(err)
 	}
 	span.AddEvent("Ran the OnShutdown hooks")
-
-	// Shutdown the proxy.
-	s.proxy.Shutdown()
-
-	// Set the server status to stopped. This is used to shutdown the server gracefully in OnClose.
-	s.mu.Lock()
-	s.Status = config.Stopped
-	s.mu.Unlock()
 }
 
 // OnTick is called every TickInterval. It calls the OnTick hooks.
@@ -437,7 +555,8 @@ func (s *Server) Run() *gerr.GatewayDError {
 		return nil
 	}
 
-	listener, origErr := net.Listen(s.Network, addr)
+	// The listener is inherited from the previous process after a restart.
+	listener, origErr := Listen(s.Network, addr)
 	if origErr != nil {
 		s.logger.Error().Err(origErr).Msg("Server failed to start listening")
 		return gerr.ErrServerListenFailed.Wrap(origErr)
@@ -493,8 +612,25 @@ func (s *Server) Run() *gerr.GatewayDError {
 		}
 	}(s)
 
+	if s.Options.EventLoop {
+		poller, origErr := newPoller()
+		if origErr != nil {
+			s.logger.Warn().Err(gerr.ErrEventLoopFailed.Wrap(origErr)).Msg(
+				"Serving the connections with goroutines instead of the event loop")
+		} else {
+			s.mu.Lock()
+			s.poller = poller
+			s.mu.Unlock()
+			s.logger.Info().Msg("The event loop is enabled")
+		}
+	}
+
 	s.running.Store(true)
 
+	if s.Options.ClientIdleTimeout > 0 {
+		go s.closeIdleConnections()
+	}
+
 	var tlsConfig *tls.Config
 	if s.EnableTLS {
 		tlsConfig, origErr = CreateTLSConfig(s.CertFile, s.KeyFile)
@@ -522,63 +658,452 @@ func (s *Server) Run() *gerr.GatewayDError {
 				return gerr.ErrAcceptFailed.Wrap(err)
 			}
 
-			conn := NewConnWrapper(netConn, tlsConfig, s.HandshakeTimeout)
+			// OnOpen might wait for a server connection to become available,
+			// so the connection is handled in its own goroutine.
+			s.setKeepAlive(netConn)
 
-			if out, action := s.OnOpen(conn); action != None {
-				if _, err := conn.Write(out); err != nil {
-					s.logger.Error().Err(err).Msg("Failed to write to connection")
+			go func(netConn net.Conn) {
+				// The address of the client is in the PROXY header of the load balancer.
+				if s.Options.AcceptProxyProtocol {
+					var ok bool
+					if netConn, ok = s.acceptProxyProtocol(netConn); !ok {
+						return
+					}
 				}
-				conn.Close()
-				if action == Shutdown {
-					s.OnShutdown()
-					return nil
+				if !s.acceptClientAddress(netConn) {
+					return
 				}
+				s.handleConnection(NewConnWrapper(netConn, tlsConfig, s.HandshakeTimeout))
+			}(netConn)
+		}
+	}
+}
+
+// setKeepAlive enables or disables the TCP keepalive of the incoming connection.
+func (s *Server) setKeepAlive(netConn net.Conn) {
+	tcpConn, ok := netConn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if err := tcpConn.SetKeepAlive(s.Options.TCPKeepAlive); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to set keep alive")
+	} else if s.Options.TCPKeepAlive {
+		if err := tcpConn.SetKeepAlivePeriod(s.Options.TCPKeepAlivePeriod); err != nil {
+			s.logger.Error().Err(err).Msg("Failed to set keep alive period")
+		}
+	}
+}
+
+// acceptProxyProtocol reads the PROXY header of a connection from a trusted proxy, within the
+// handshake timeout, and returns the connection with the address of the client. It returns
+// false if the header is missing or invalid, in which case the connection is closed.
+func (s *Server) acceptProxyProtocol(netConn net.Conn) (net.Conn, bool) {
+	_, span := otel.Tracer("gatewayd").Start(s.ctx, "acceptProxyProtocol")
+	defer span.End()
+
+	if !IsTrustedProxy(netConn.RemoteAddr(), s.Options.TrustedProxies) {
+		return netConn, true
+	}
+
+	if s.HandshakeTimeout > 0 {
+		if err := netConn.SetReadDeadline(time.Now().Add(s.HandshakeTimeout)); err != nil {
+			s.logger.Error().Err(err).Msg("Failed to set the deadline of the PROXY header")
+		}
+		defer func() {
+			if err := netConn.SetReadDeadline(time.Time{}); err != nil {
+				s.logger.Error().Err(err).Msg("Failed to reset the deadline of the PROXY header")
 			}
-			s.mu.Lock()
-			s.connections++
-			s.mu.Unlock()
+		}()
+	}
 
-			// For every new connection, a new unbuffered channel is created to help
-			// stop the proxy, recycle the server connection and close stale connections.
-			stopConnection := make(chan struct{})
-			go func(server *Server, conn *ConnWrapper, stopConnection chan struct{}) {
-				if action := server.OnTraffic(conn, stopConnection); action == Close {
-					stopConnection <- struct{}{}
-				}
-			}(s, conn, stopConnection)
-
-			go func(server *Server, conn *ConnWrapper, stopConnection chan struct{}) {
-				for {
-					select {
-					case <-stopConnection:
-						server.mu.Lock()
-						server.connections--
-						server.mu.Unlock()
-						server.OnClose(conn, err)
-						return
-					case <-server.stopServer:
-						return
-					}
-				}
-			}(s, conn, stopConnection)
+	conn, origErr := ReadProxyHeader(netConn)
+	if origErr != nil {
+		err := gerr.ErrProxyProtocolFailed.Wrap(origErr)
+		s.logger.Warn().Err(err).Str("from", RemoteAddr(netConn)).Msg(
+			"Closing the connection without a valid PROXY header")
+		span.RecordError(err)
+		netConn.Close()
+		return nil, false
+	}
+
+	s.logger.Debug().Fields(
+		map[string]interface{}{
+			"proxy":  RemoteAddr(netConn),
+			"client": RemoteAddr(conn),
+		},
+	).Msg("Read the PROXY header of the connection")
+	return conn, true
+}
+
+// checkAccess returns the list that rejects the client address, or an empty string if the
+// address is allowed. The addresses that aren't TCP addresses, like UNIX sockets, are always
+// allowed, since the lists only have IP networks.
+func (s *Server) checkAccess(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if slices.ContainsFunc(s.deny, func(network *net.IPNet) bool {
+		return network.Contains(tcpAddr.IP)
+	}) {
+		return "deny"
+	}
+	if len(s.allow) > 0 && !slices.ContainsFunc(s.allow, func(network *net.IPNet) bool {
+		return network.Contains(tcpAddr.IP)
+	}) {
+		return "allow"
+	}
+	return ""
+}
+
+// acceptClientAddress checks the client address of the connection against the allow and deny
+// lists. It returns false if the address is rejected, in which case the client is sent an
+// error and the connection is closed.
+func (s *Server) acceptClientAddress(netConn net.Conn) bool {
+	_, span := otel.Tracer("gatewayd").Start(s.ctx, "acceptClientAddress")
+	defer span.End()
+
+	list := s.checkAccess(netConn.RemoteAddr())
+	if list == "" {
+		return true
+	}
+
+	reason := "the client address is in the deny list"
+	if list == "allow" {
+		reason = "the client address is not in the allow list"
+	}
+	err := gerr.ErrClientAddressDenied.Wrap(errors.New(reason))
+	metrics.ServerDeniedConnections.WithLabelValues(list).Inc()
+	s.logger.Warn().Err(err).Fields(
+		map[string]interface{}{
+			"from": RemoteAddr(netConn),
+			"list": list,
+		},
+	).Msg("Rejected the connection from a denied address")
+	span.RecordError(err)
+
+	if _, err := netConn.Write(GatewayDErrorResponse(err)); err != nil {
+		s.logger.Trace().Err(err).Msg("Failed to send the error to the rejected client")
+	}
+	netConn.Close()
+	return false
+}
+
+// SetAccessLists replaces the allow and deny lists of the server. The new lists apply to the
+// connections accepted from then on, and the open connections are left open.
+func (s *Server) SetAccessLists(allow, deny []*net.IPNet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allow = slices.Clone(allow)
+	s.deny = slices.Clone(deny)
+}
+
+// AccessLists returns the current allow and deny lists of the server.
+func (s *Server) AccessLists() ([]*net.IPNet, []*net.IPNet) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.allow), slices.Clone(s.deny)
+}
+
+// handleConnection opens the incoming connection and passes its traffic through the proxy.
+func (s *Server) handleConnection(conn *ConnWrapper) {
+	if out, action := s.OnOpen(conn); action != None {
+		if _, err := conn.Write(out); err != nil {
+			s.logger.Error().Err(err).Msg("Failed to write to connection")
+		}
+		conn.Close()
+		if action == Shutdown {
+			s.Shutdown()
+		}
+		return
+	}
+
+	// With the event loop, the connection doesn't need any goroutine while it is idle.
+	if s.serveEventLoop(conn) {
+		return
+	}
+
+	// For every new connection, a new unbuffered channel is created to help
+	// stop the proxy, recycle the server connection and close stale connections.
+	stopConnection := make(chan struct{})
+	go func(server *Server, conn *ConnWrapper, stopConnection chan struct{}) {
+		if action := server.OnTraffic(conn, stopConnection); action == Close {
+			stopConnection <- struct{}{}
+		}
+	}(s, conn, stopConnection)
+
+	for {
+		select {
+		case <-stopConnection:
+			s.releaseConnection(conn)
+			s.OnClose(conn, nil)
+			return
+		case <-s.stopServer:
+			return
 		}
 	}
 }
 
-// Shutdown stops the server.
-func (s *Server) Shutdown() {
-	_, span := otel.Tracer("gatewayd").Start(s.ctx, "Shutdown")
+// clientIP returns the IP address of the client of the connection,
+// or an empty string if it isn't a TCP connection.
+func clientIP(conn net.Conn) string {
+	if conn == nil {
+		return ""
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+// admitConnection counts the connection, unless the server or its client IP is at its maximum
+// number of connections. Over the soft limit, the connection is counted with a warning.
+func (s *Server) admitConnection(conn *ConnWrapper) *gerr.GatewayDError {
+	_, span := otel.Tracer("gatewayd").Start(s.ctx, "admitConnection")
 	defer span.End()
 
-	// Shutdown the proxy.
-	s.proxy.Shutdown()
+	ip := clientIP(conn.Conn())
 
-	// Set the server status to stopped. This is used to shutdown the server gracefully in OnClose.
 	s.mu.Lock()
-	s.Status = config.Stopped
+	if s.Options.MaxConnections > 0 && int(s.connections) >= s.Options.MaxConnections {
+		s.rejected++
+		s.mu.Unlock()
+		metrics.ServerRejectedConnections.WithLabelValues("maxConnections").Inc()
+		return gerr.ErrTooManyConnections.Wrap(
+			fmt.Errorf("the limit is %d connections", s.Options.MaxConnections))
+	}
+	if s.Options.MaxConnectionsPerClientIP > 0 && ip != "" &&
+		s.clientIPs[ip] >= s.Options.MaxConnectionsPerClientIP {
+		s.rejected++
+		s.mu.Unlock()
+		metrics.ServerRejectedConnections.WithLabelValues("maxConnectionsPerClientIP").Inc()
+		return gerr.ErrTooManyClientConnections.Wrap(
+			fmt.Errorf("the limit is %d connections from %s",
+				s.Options.MaxConnectionsPerClientIP, ip))
+	}
+	s.connections++
+	if ip != "" {
+		s.clientIPs[ip]++
+	}
+	connections := int(s.connections)
 	s.mu.Unlock()
 
-	// Shutdown the server.
+	if s.Options.SoftLimit > 0 && connections > s.Options.SoftLimit {
+		metrics.ServerSoftLimitExceeded.Inc()
+		s.logger.Warn().Fields(
+			map[string]interface{}{
+				"connections": connections,
+				"softLimit":   s.Options.SoftLimit,
+				"from":        RemoteAddr(conn.Conn()),
+			},
+		).Msg("The server is over the soft limit of connections")
+		span.AddEvent("The server is over the soft limit of connections")
+	}
+	return nil
+}
+
+// releaseConnection stops counting the connection once it is closed.
+func (s *Server) releaseConnection(conn *ConnWrapper) {
+	ip := clientIP(conn.Conn())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connections--
+	if ip != "" {
+		if s.clientIPs[ip]--; s.clientIPs[ip] <= 0 {
+			delete(s.clientIPs, ip)
+		}
+	}
+}
+
+// readStartupMessage reads the messages of the startup phase of the incoming connection and
+// answers its encryption requests, until it sends a StartupMessage or a CancelRequest. The
+// client must send them within the handshake timeout, so that it can't hold a connection
+// without ever starting a session.
+func (s *Server) readStartupMessage(conn *ConnWrapper) ([]byte, *gerr.GatewayDError) {
+	_, span := otel.Tracer("gatewayd").Start(s.ctx, "readStartupMessage")
+	defer span.End()
+
+	if s.HandshakeTimeout > 0 {
+		// The deadline of the TCP connection also applies to the TLS connection.
+		if err := conn.netConn.SetReadDeadline(time.Now().Add(s.HandshakeTimeout)); err != nil {
+			s.logger.Error().Err(err).Msg("Failed to set the deadline of the startup message")
+		}
+		defer func() {
+			if err := conn.netConn.SetReadDeadline(time.Time{}); err != nil {
+				s.logger.Error().Err(err).Msg("Failed to reset the deadline of the startup message")
+			}
+		}()
+	}
+
+	for {
+		msg, err := conn.Framer().ReadMessage()
+		if err != nil {
+			span.RecordError(err)
+			return nil, gerr.ErrReadFailed.Wrap(err)
+		}
+
+		if !negotiateEncryption(s.ctx, conn, msg, s.logger) {
+			return msg, nil
+		}
+	}
+}
+
+// routeConnection returns the proxy of the incoming connection. If the server has routes,
+// it matches the parameters of the StartupMessage against the routes. The StartupMessage
+// is put back before, so that the proxy passes it through to the server as usual.
+func (s *Server) routeConnection(conn *ConnWrapper, startupMessage []byte) (IProxy, *gerr.GatewayDError) {
+	_, span := otel.Tracer("gatewayd").Start(s.ctx, "routeConnection")
+	defer span.End()
+
+	if len(s.Routes) == 0 {
+		return s.proxy, nil
+	}
+
+	// A StartupMessage of another protocol version has no parameters,
+	// so it is passed to the proxy of the server, which lets the server answer it.
+	parameters := ParseStartupMessage(startupMessage)
+	if parameters == nil && s.proxy != nil {
+		return s.proxy, nil
+	}
+
+	if proxy := FindRoute(s.Routes, parameters); proxy != nil {
+		s.logger.Debug().Fields(
+			map[string]interface{}{
+				"database":        parameters["database"],
+				"user":            parameters["user"],
+				"applicationName": parameters["application_name"],
+				"remote":          RemoteAddr(conn.Conn()),
+			},
+		).Msg("Routed the connection")
+		span.AddEvent("Routed the connection")
+		return proxy, nil
+	}
+
+	err := fmt.Errorf("database=%q user=%q application_name=%q",
+		parameters["database"], parameters["user"], parameters["application_name"])
+	span.RecordError(err)
+	return nil, gerr.ErrNoRouteFound.Wrap(err)
+}
+
+// getProxy returns the proxy that the connection is assigned to.
+func (s *Server) getProxy(conn *ConnWrapper) IProxy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if proxy, ok := s.proxies[conn]; ok {
+		return proxy
+	}
+	return s.proxy
+}
+
+// allProxies returns the proxy of the server and the proxies of its routes.
+func (s *Server) allProxies() []IProxy {
+	proxies := []IProxy{}
+	if s.proxy != nil {
+		proxies = append(proxies, s.proxy)
+	}
+	for _, route := range s.Routes {
+		if !slices.Contains(proxies, route.Proxy) {
+			proxies = append(proxies, route.Proxy)
+		}
+	}
+	return proxies
+}
+
+// shutdownProxies shuts down the proxy of the server and the proxies of its routes.
+func (s *Server) shutdownProxies() {
+	for _, proxy := range s.allProxies() {
+		proxy.Shutdown()
+	}
+}
+
+// closeIdleConnections closes the connections whose session is idle for the client idle
+// timeout, until the server stops.
+func (s *Server) closeIdleConnections() {
+	ticker := time.NewTicker(min(idleCheckInterval, s.Options.ClientIdleTimeout))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopServer:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.RLock()
+		proxies := maps.Clone(s.proxies)
+		s.mu.RUnlock()
+
+		for conn, proxy := range proxies {
+			if proxy.CloseIdle(conn, s.Options.ClientIdleTimeout) {
+				metrics.ServerIdleConnectionsClosed.Inc()
+				s.logger.Debug().Fields(
+					map[string]interface{}{
+						"from":    RemoteAddr(conn.Conn()),
+						"timeout": s.Options.ClientIdleTimeout.String(),
+					},
+				).Msg("Closed the idle connection")
+			}
+		}
+	}
+}
+
+// drain disconnects the connections once they finish their current transaction, until they
+// are all closed or the shutdown timeout expires.
+func (s *Server) drain() {
+	_, span := otel.Tracer("gatewayd").Start(s.ctx, "drain")
+	defer span.End()
+
+	s.logger.Info().Fields(
+		map[string]interface{}{
+			"connections": s.CountConnections(),
+			"timeout":     s.Options.ShutdownTimeout.String(),
+		},
+	).Msg("Draining the connections")
+
+	timeout := time.NewTimer(s.Options.ShutdownTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	for {
+		busy := 0
+		for _, proxy := range s.allProxies() {
+			busy += proxy.Drain()
+		}
+		if s.CountConnections() == 0 {
+			s.logger.Info().Msg("Drained all the connections")
+			span.AddEvent("Drained all the connections")
+			return
+		}
+
+		select {
+		case <-timeout.C:
+			s.logger.Warn().Fields(
+				map[string]interface{}{
+					"connections": s.CountConnections(),
+					"busy":        busy,
+				},
+			).Msg("Closing the connections that are still open after the shutdown timeout")
+			span.AddEvent("Timed out draining the connections")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Shutdown stops the server. If the shutdown timeout is set, the server stops accepting new
+// connections and drains the current ones before closing them.
+func (s *Server) Shutdown() {
+	_, span := otel.Tracer("gatewayd").Start(s.ctx, "Shutdown")
+	defer span.End()
+
+	// Stop accepting new connections.
 	var err error
 	s.running.Store(false)
 	if s.listener != nil {
@@ -589,6 +1114,23 @@ func (s *Server) Shutdown() {
 		s.logger.Error().Msg("Listener is not initialized")
 	}
 
+	if s.Options.ShutdownTimeout > 0 {
+		s.drain()
+	}
+
+	// Shutdown the proxies.
+	s.shutdownProxies()
+
+	// Set the server status to stopped. This is used to shutdown the server gracefully in OnClose.
+	// The event loop is stopped once the connections are closed, since it serves them while
+	// they are drained.
+	s.mu.Lock()
+	s.Status = config.Stopped
+	if s.poller != nil {
+		s.poller.close()
+	}
+	s.mu.Unlock()
+
 	select {
 	case <-s.stopServer:
 		s.logger.Info().Msg("Server stopped")
@@ -621,6 +1163,7 @@ func NewServer(
 	tickInterval time.Duration,
 	options Option,
 	proxy IProxy,
+	routes []Route,
 	logger zerolog.Logger,
 	pluginRegistry *plugin.Registry,
 	pluginTimeout time.Duration,
@@ -638,12 +1181,17 @@ func NewServer(
 		Address:          address,
 		Options:          options,
 		TickInterval:     tickInterval,
+		Routes:           routes,
 		Status:           config.Stopped,
 		EnableTLS:        enableTLS,
 		CertFile:         certFile,
 		KeyFile:          keyFile,
 		HandshakeTimeout: handshakeTimeout,
 		proxy:            proxy,
+		proxies:          map[*ConnWrapper]IProxy{},
+		clientIPs:        map[string]int{},
+		allow:            options.Allow,
+		deny:             options.Deny,
 		logger:           logger,
 		pluginRegistry:   pluginRegistry,
 		pluginTimeout:    pluginTimeout,
@@ -653,6 +1201,14 @@ func NewServer(
 		stopServer:       make(chan struct{}),
 	}
 
+	// The drain and the idle timeout need the transaction status and the last request of the
+	// sessions, and send them errors, so their traffic can't be spliced.
+	if options.ShutdownTimeout > 0 || options.ClientIdleTimeout > 0 {
+		for _, proxy := range server.allProxies() {
+			proxy.TrackActivity()
+		}
+	}
+
 	// Try to resolve the address and log an error if it can't be resolved.
 	addr, err := Resolve(server.Network, server.Address, logger)
 	if err != nil {
@@ -679,3 +1235,17 @@ func (s *Server) CountConnections() int {
 	defer s.mu.RUnlock()
 	return int(s.connections)
 }
+
+// CountClientIPConnections returns the current number of connections per client IP.
+func (s *Server) CountClientIPConnections() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.clientIPs)
+}
+
+// CountRejectedConnections returns the number of connections rejected by the connection limits.
+func (s *Server) CountRejectedConnections() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rejected
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"net"
 	"os"
 	"sync"
 	"testing"
@@ -12,6 +13,7 @@ import (
 
 	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
 	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
 	"github.com/gatewayd-io/gatewayd/logging"
 	"github.com/gatewayd-io/gatewayd/plugin"
 	"github.com/gatewayd-io/gatewayd/pool"
@@ -79,10 +81,11 @@ func TestRunServer(t *testing.T) {
 	proxy := NewProxy(
 		context.Background(),
 		newPool,
+		nil,
 		pluginRegistry,
-		false,
-		false,
-		config.DefaultHealthCheckPeriod,
+		&config.Proxy{
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+		},
 		&clientConfig,
 		logger,
 		config.DefaultPluginTimeout)
@@ -97,6 +100,7 @@ func TestRunServer(t *testing.T) {
 			EnableTicker: true,
 		},
 		proxy,
+		nil,
 		logger,
 		pluginRegistry,
 		config.DefaultPluginTimeout,
@@ -269,3 +273,234 @@ func onOutgoingTraffic(
 
 	return params, nil
 }
+
+// TestRouteConnection tests that the server assigns the connections to the proxy
+// of the route that matches their StartupMessage.
+func TestRouteConnection(t *testing.T) {
+	proxy := &Proxy{}
+	server := &Server{
+		ctx:    context.Background(),
+		logger: zerolog.Nop(),
+		Routes: []Route{{Database: "postgres", User: "postgres", Proxy: proxy}},
+	}
+
+	// The SSLRequest is declined, since TLS is disabled.
+	sslRequest := []byte{0x00, 0x00, 0x00, 0x8, 0x04, 0xd2, 0x16, 0x2f}
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	conn := NewConnWrapper(serverConn, nil, config.DefaultHandshakeTimeout)
+	go func() {
+		_, _ = clientConn.Write(sslRequest)
+		reply := make([]byte, 1)
+		_, _ = io.ReadFull(clientConn, reply)
+		_, _ = clientConn.Write(CreatePgStartupPacket())
+	}()
+
+	startupMessage, err := server.readStartupMessage(conn)
+	require.Nil(t, err)
+	assert.Equal(t, CreatePgStartupPacket(), startupMessage)
+
+	routed, err := server.routeConnection(conn, startupMessage)
+	require.Nil(t, err)
+	assert.Equal(t, proxy, routed)
+
+	// The connection is rejected if no route matches.
+	server.Routes[0].User = "admin"
+	routed, err = server.routeConnection(conn, startupMessage)
+	assert.Nil(t, routed)
+	assert.ErrorIs(t, err, gerr.ErrNoRouteFound)
+}
+
+// clientAddrConn is a connection from the client address.
+type clientAddrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c *clientAddrConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// TestAdmitConnection tests that the connections over the limits of the server
+// and of the client IP are rejected, and that they are counted until they are released.
+func TestAdmitConnection(t *testing.T) {
+	server := &Server{
+		ctx:    context.Background(),
+		logger: zerolog.Nop(),
+		mu:     &sync.RWMutex{},
+		Options: Option{
+			MaxConnections:            2,
+			SoftLimit:                 1,
+			MaxConnectionsPerClientIP: 1,
+		},
+		clientIPs: map[string]int{},
+	}
+	connect := func(ip string) *ConnWrapper {
+		netConn, _ := net.Pipe()
+		t.Cleanup(func() { netConn.Close() })
+		return NewConnWrapper(
+			&clientAddrConn{Conn: netConn, addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 54321}},
+			nil, config.DefaultHandshakeTimeout)
+	}
+
+	first := connect("10.0.0.1")
+	require.Nil(t, server.admitConnection(first))
+	assert.ErrorIs(t, server.admitConnection(connect("10.0.0.1")), gerr.ErrTooManyClientConnections)
+	// The second connection is over the soft limit, but still admitted.
+	require.Nil(t, server.admitConnection(connect("10.0.0.2")))
+	err := server.admitConnection(connect("10.0.0.3"))
+	assert.ErrorIs(t, err, gerr.ErrTooManyConnections)
+	assert.Contains(t, string(GatewayDErrorResponse(err)), "C53300")
+
+	assert.Equal(t, 2, server.CountConnections())
+	assert.Equal(t, map[string]int{"10.0.0.1": 1, "10.0.0.2": 1}, server.CountClientIPConnections())
+	assert.Equal(t, uint64(2), server.CountRejectedConnections())
+
+	// The connections are admitted again once the others are released.
+	server.releaseConnection(first)
+	assert.Equal(t, map[string]int{"10.0.0.2": 1}, server.CountClientIPConnections())
+	require.Nil(t, server.admitConnection(connect("10.0.0.1")))
+	assert.Equal(t, 2, server.CountConnections())
+}
+
+// TestOnOpenCountsStartupLessConnections tests that the connections that don't send their
+// StartupMessage are counted against the limits until the handshake timeout closes them.
+func TestOnOpenCountsStartupLessConnections(t *testing.T) {
+	server := &Server{
+		ctx:    context.Background(),
+		logger: zerolog.Nop(),
+		mu:     &sync.RWMutex{},
+		Options: Option{
+			MaxConnections:            2,
+			MaxConnectionsPerClientIP: 1,
+		},
+		HandshakeTimeout: 500 * time.Millisecond,
+		pluginTimeout:    config.DefaultPluginTimeout,
+		pluginRegistry: plugin.NewRegistry(
+			context.Background(),
+			config.Loose,
+			config.PassDown,
+			config.Accept,
+			config.Stop,
+			zerolog.Nop(),
+			false,
+		),
+		clientIPs: map[string]int{},
+	}
+	connect := func(ip string) *ConnWrapper {
+		netConn, client := net.Pipe()
+		t.Cleanup(func() {
+			netConn.Close()
+			client.Close()
+		})
+		return NewConnWrapper(
+			&clientAddrConn{Conn: netConn, addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 54321}},
+			nil, config.DefaultHandshakeTimeout)
+	}
+
+	// The idle connections never send anything.
+	var wg sync.WaitGroup
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		conn := connect(ip)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, action := server.OnOpen(conn)
+			assert.Empty(t, out)
+			assert.Equal(t, Close, action)
+		}()
+	}
+	require.Eventually(t, func() bool {
+		return server.CountConnections() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	out, action := server.OnOpen(connect("10.0.0.1"))
+	assert.Equal(t, Close, action)
+	assert.Contains(t, string(out), "C53300")
+	out, action = server.OnOpen(connect("10.0.0.3"))
+	assert.Equal(t, Close, action)
+	assert.Contains(t, string(out), "C53300")
+	assert.Equal(t, uint64(2), server.CountRejectedConnections())
+
+	// The handshake timeout closes the idle connections, which are no longer counted.
+	wg.Wait()
+	assert.Equal(t, 0, server.CountConnections())
+	assert.Empty(t, server.CountClientIPConnections())
+}
+
+// TestReadStartupMessageErrors tests that the connections that fail to send their
+// StartupMessage at the same time each get the error of their own connection.
+func TestReadStartupMessageErrors(t *testing.T) {
+	server := &Server{
+		ctx:              context.Background(),
+		logger:           zerolog.Nop(),
+		HandshakeTimeout: 200 * time.Millisecond,
+	}
+
+	closedConn, client := net.Pipe()
+	defer closedConn.Close()
+	require.NoError(t, client.Close())
+	idleConn, client := net.Pipe()
+	defer idleConn.Close()
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	errs := make([]*gerr.GatewayDError, 2)
+	for i, netConn := range []net.Conn{closedConn, idleConn} {
+		wg.Add(1)
+		go func(i int, conn *ConnWrapper) {
+			defer wg.Done()
+			_, errs[i] = server.readStartupMessage(conn)
+		}(i, NewConnWrapper(netConn, nil, config.DefaultHandshakeTimeout))
+	}
+	wg.Wait()
+
+	assert.ErrorIs(t, errs[0], gerr.ErrReadFailed)
+	assert.ErrorIs(t, errs[0], io.EOF)
+	assert.NotErrorIs(t, errs[0], os.ErrDeadlineExceeded)
+	assert.ErrorIs(t, errs[1], gerr.ErrReadFailed)
+	assert.ErrorIs(t, errs[1], os.ErrDeadlineExceeded)
+}
+
+// TestAcceptClientAddress tests that the clients in a denied network, or outside of the allowed
+// networks, are sent an error and closed, and that the lists can be replaced.
+func TestAcceptClientAddress(t *testing.T) {
+	allow, err := config.ParseCIDRs([]string{"10.0.0.0/8", "::1"})
+	require.NoError(t, err)
+	deny, err := config.ParseCIDRs([]string{"10.1.0.0/16"})
+	require.NoError(t, err)
+	server := &Server{
+		ctx:    context.Background(),
+		logger: zerolog.Nop(),
+		mu:     &sync.RWMutex{},
+		allow:  allow,
+		deny:   deny,
+	}
+	tcpAddr := func(ip string) net.Addr {
+		return &net.TCPAddr{IP: net.ParseIP(ip), Port: 54321}
+	}
+
+	assert.Empty(t, server.checkAccess(tcpAddr("10.0.0.1")))
+	assert.Empty(t, server.checkAccess(tcpAddr("::1")))
+	assert.Equal(t, "deny", server.checkAccess(tcpAddr("10.1.2.3")))
+	assert.Equal(t, "allow", server.checkAccess(tcpAddr("192.168.0.1")))
+	assert.Empty(t, server.checkAccess(&net.UnixAddr{Name: "/tmp/.s.PGSQL.5432", Net: "unix"}))
+
+	netConn, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		assert.False(t, server.acceptClientAddress(
+			&clientAddrConn{Conn: netConn, addr: tcpAddr("10.1.2.3")}))
+	}()
+	response, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Contains(t, string(response), "C28000")
+
+	// Without an allow list, only the denied networks are rejected.
+	server.SetAccessLists(nil, deny)
+	assert.Empty(t, server.checkAccess(tcpAddr("192.168.0.1")))
+	assert.Equal(t, "deny", server.checkAccess(tcpAddr("10.1.2.3")))
+	allow, deny = server.AccessLists()
+	assert.Empty(t, allow)
+	assert.Len(t, deny, 1)
+}
@@ -0,0 +1,207 @@
+package network
+
+import (
+	"sync"
+	"time"
+)
+
+// Session is the state of an incoming connection, which is tracked from the
+// messages exchanged between the client and the server. In transaction pooling
+// mode, it also holds the server connection that is assigned to the incoming
+// connection for the duration of the current transaction.
+type Session struct {
+	mu             sync.Mutex
+	client         *Client
+	parameters     map[string]string
+	startupMessage []byte
+	key            string
+	pending        int
+	sending        bool
+	started        bool
+	terminated     bool
+	errorSent      bool
+	txStatus       byte
+	attached       chan struct{}
+	closed         chan struct{}
+	closeOnce      sync.Once
+	// owner is the replica proxy that owns the attached client,
+	// or nil if it belongs to the proxy of the session.
+	owner *Proxy
+	// sticky is set after the first write, so that the session reads its own
+	// writes from the primary instead of the replicas.
+	sticky bool
+	// cancelKey is the key that is sent to the client in the BackendKeyData message.
+	cancelKey *CancelKey
+	// statements are the named statements prepared by the client in transaction pooling
+	// mode. They are only used by the goroutine that receives the requests of the session.
+	statements map[string]*preparedStatement
+	// copyDirection is the direction of the COPY in progress, or empty if there is none.
+	// copyBytes is the size of its data, and copyBatches is the number of its batches.
+	copyDirection string
+	copyBytes     int
+	copyBatches   int
+	// lastRequest is when the client last sent a request, which tells how long it is idle.
+	lastRequest time.Time
+}
+
+// NewSession creates a new session.
+func NewSession() *Session {
+	return &Session{
+		txStatus:    PostgresTxIdle,
+		attached:    make(chan struct{}, 1),
+		statements:  map[string]*preparedStatement{},
+		closed:      make(chan struct{}),
+		lastRequest: time.Now(),
+	}
+}
+
+// Parameters returns the parameters of the StartupMessage sent by the client.
+func (s *Session) Parameters() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.parameters
+}
+
+// TxStatus returns the transaction status of the last ReadyForQuery message.
+func (s *Session) TxStatus() byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.txStatus
+}
+
+// IsIdle returns true if the session is not in a transaction and
+// all the requests sent to the server are answered.
+func (s *Session) IsIdle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isIdle()
+}
+
+// IsSticky returns true if the session sent a write, so it is no longer sent to the replicas.
+func (s *Session) IsSticky() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sticky
+}
+
+// Close marks the session as closed and wakes up the goroutines that
+// are waiting for a server connection.
+func (s *Session) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.cancelKey != nil {
+			cancelKeys.Delete(*s.cancelKey)
+		}
+	})
+}
+
+// isClosed returns true if the session is closed.
+func (s *Session) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// isIdle is the lock-free version of IsIdle.
+func (s *Session) isIdle() bool {
+	return s.pending == 0 && !s.sending && s.txStatus == PostgresTxIdle
+}
+
+// setStartupParameters stores the StartupMessage and its parameters. The user and
+// database identify the server connections that can be shared with the session.
+func (s *Session) setStartupParameters(parameters map[string]string, startupMessage []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.parameters = parameters
+	s.startupMessage = startupMessage
+	s.key = "user=" + parameters["user"] + " database=" + parameters["database"]
+	return s.key
+}
+
+// start starts the session of a client that is authenticated by the proxy, as if the server
+// had answered its StartupMessage, and returns the key that is sent to the client in the
+// BackendKeyData message.
+func (s *Session) start(parameters map[string]string, key string) CancelKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.parameters = parameters
+	s.key = key
+	s.started = true
+	s.txStatus = PostgresTxIdle
+	if s.cancelKey == nil {
+		cancelKey := newCancelKey(s)
+		s.cancelKey = &cancelKey
+	}
+	return *s.cancelKey
+}
+
+// trackRequest counts the messages in the request that are answered with ReadyForQuery.
+func (s *Session) trackRequest(request []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastRequest = time.Now()
+	s.pending += CountReadyForQueryRequests(request)
+	if IsPostgresStartupMessage(request) {
+		// The server sends a ReadyForQuery message once the session starts.
+		s.pending++
+	}
+}
+
+// isIdleFor returns true if the session has started, and the client hasn't sent any request
+// for the timeout while waiting for no response. Unlike isIdle, the session might be in a
+// transaction. It must be called with the lock held.
+func (s *Session) isIdleFor(timeout time.Duration) bool {
+	return s.started && s.pending == 0 && !s.sending && time.Since(s.lastRequest) >= timeout
+}
+
+// terminate marks the session as terminated by the client, after it sent a Terminate message.
+func (s *Session) terminate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.terminated = true
+}
+
+// finishRequest marks the end of sending a request. While a request is being sent,
+// the session is not idle, even if the server has answered all the previous requests.
+func (s *Session) finishRequest() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sending = false
+}
+
+// trackResponse updates the transaction status from the ReadyForQuery messages in the
+// response and returns true if the response contained at least one of them.
+// It must be called with the lock held.
+func (s *Session) trackResponse(response []byte) bool {
+	count, status := ReadyForQueryStatus(response)
+	if count == 0 {
+		return false
+	}
+
+	s.started = true
+	s.txStatus = status
+	s.pending = max(s.pending-count, 0)
+	return true
+}
+
+// attach assigns the server connection to the session and wakes up the goroutine that
+// waits for it to receive the responses. It must be called with the lock held.
+func (s *Session) attach(client *Client) {
+	s.client = client
+	if client == nil {
+		return
+	}
+	select {
+	case s.attached <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,42 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSessionTracking tests tracking the transaction status of a session.
+func TestSessionTracking(t *testing.T) {
+	session := NewSession()
+	assert.True(t, session.IsIdle())
+	assert.Equal(t, PostgresTxIdle, session.TxStatus())
+
+	// The session starts once the server sends the first ReadyForQuery.
+	session.trackRequest(CreatePgStartupPacket())
+	assert.False(t, session.IsIdle())
+	assert.True(t, session.trackResponse(
+		CreatePostgreSQLPacket(PostgresReadyForQuery, []byte{PostgresTxIdle})))
+	assert.True(t, session.IsIdle())
+
+	// The session is not idle while it is in a transaction.
+	session.trackRequest(CreatePostgreSQLPacket(PostgresQuery, []byte("begin;\x00")))
+	assert.False(t, session.IsIdle())
+	assert.True(t, session.trackResponse(
+		CreatePostgreSQLPacket(PostgresReadyForQuery, []byte{PostgresTxInTransaction})))
+	assert.Equal(t, PostgresTxInTransaction, session.TxStatus())
+	assert.False(t, session.IsIdle())
+
+	// A response without ReadyForQuery doesn't change the status.
+	assert.False(t, session.trackResponse(
+		CreatePostgreSQLPacket('C', []byte("COMMIT\x00"))))
+
+	session.trackRequest(CreatePostgreSQLPacket(PostgresQuery, []byte("commit;\x00")))
+	assert.True(t, session.trackResponse(
+		CreatePostgreSQLPacket(PostgresReadyForQuery, []byte{PostgresTxIdle})))
+	assert.True(t, session.IsIdle())
+
+	session.Close()
+	session.Close()
+	assert.True(t, session.isClosed())
+}
@@ -0,0 +1,150 @@
+package network
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/gatewayd-io/gatewayd/metrics"
+	"github.com/gatewayd-io/gatewayd/plugin"
+	"go.opentelemetry.io/otel"
+)
+
+// trafficHooks are the hooks that inspect the traffic of the sessions, which
+// prevent the traffic from being copied between the connections by the kernel.
+var trafficHooks = []v1.HookName{
+	v1.HookName_HOOK_NAME_ON_TRAFFIC_FROM_CLIENT,
+	v1.HookName_HOOK_NAME_ON_TRAFFIC_TO_SERVER,
+	v1.HookName_HOOK_NAME_ON_TRAFFIC_FROM_SERVER,
+	v1.HookName_HOOK_NAME_ON_TRAFFIC_TO_CLIENT,
+	plugin.OnCopyStart,
+	plugin.OnCopyEnd,
+}
+
+// canSplice returns true if the traffic of the session can be copied between the client and
+// the server connection as is, because nothing needs to read it: the session has started in
+// session pooling mode, no plugin attached to the traffic hooks, and the client is not reset,
+// which needs the Terminate message of the client and the ReadyForQuery messages of the server.
+// The activity of the sessions must not be tracked either, see TrackActivity, and the splice
+// must not be disabled.
+func (pr *Proxy) canSplice(session *Session, client *Client) bool {
+	if pr.DisableSplice || pr.PoolMode != config.Session || pr.resetsClient(client) ||
+		pr.trackActivity.Load() {
+		return false
+	}
+
+	session.mu.Lock()
+	started := session.started
+	session.mu.Unlock()
+	if !started {
+		return false
+	}
+
+	hooks := pr.pluginRegistry.Hooks()
+	for _, hookName := range trafficHooks {
+		if len(hooks[hookName]) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// spliceToServer copies the traffic from the client to the server until either connection
+// is closed. Since the data is not read by GatewayD, io.CopyN lets the kernel copy it with
+// splice on Linux, and the byte counters are updated for every ReceiveChunkSize bytes.
+func (pr *Proxy) spliceToServer(conn *ConnWrapper, client *Client) *gerr.GatewayDError {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "spliceToServer")
+	defer span.End()
+
+	pr.logger.Debug().Fields(
+		map[string]interface{}{
+			"function": "proxy.splice",
+			"client":   RemoteAddr(conn.Conn()),
+			"server":   client.RemoteAddr(),
+		},
+	).Msg("Copying the traffic from the client to the server without inspecting it")
+
+	err := splice(client.conn, conn.Conn(), conn.Framer().Drain(), client.ReceiveChunkSize,
+		func(copied int64) {
+			metrics.BytesReceivedFromClient.Observe(float64(copied))
+			metrics.BytesSentToServer.Observe(float64(copied))
+			metrics.TotalTrafficBytes.Observe(float64(copied))
+			metrics.TotalTrafficBytes.Observe(float64(copied))
+			metrics.ProxyPassThroughsToServer.Inc()
+		})
+	span.RecordError(err)
+	return spliceError(err)
+}
+
+// spliceToClient copies the traffic from the server to the client until either connection is
+// closed, like spliceToServer. It holds the receiving lock of the client, like Receive, so
+// that the copy can be interrupted like Receive.
+func (pr *Proxy) spliceToClient(conn *ConnWrapper, client *Client) *gerr.GatewayDError {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "spliceToClient")
+	defer span.End()
+
+	client.receiving.Lock()
+	defer client.receiving.Unlock()
+
+	pr.logger.Debug().Fields(
+		map[string]interface{}{
+			"function": "proxy.splice",
+			"client":   RemoteAddr(conn.Conn()),
+			"server":   client.RemoteAddr(),
+		},
+	).Msg("Copying the traffic from the server to the client without inspecting it")
+
+	err := splice(conn.Conn(), client.conn, client.framer.Drain(), client.ReceiveChunkSize,
+		func(copied int64) {
+			metrics.BytesReceivedFromServer.Observe(float64(copied))
+			metrics.BytesSentToClient.Observe(float64(copied))
+			metrics.TotalTrafficBytes.Observe(float64(copied))
+			metrics.TotalTrafficBytes.Observe(float64(copied))
+			metrics.ProxyPassThroughsToClient.Inc()
+		})
+	span.RecordError(err)
+	return spliceError(err)
+}
+
+// spliceError returns the error that ended the copy. The copy ends normally once either
+// connection is closed or interrupted, which is reported like the client closing the
+// connection, so that no error is sent to the client.
+func spliceError(err error) *gerr.GatewayDError {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, os.ErrDeadlineExceeded) {
+		return gerr.ErrClientNotConnected.Wrap(err)
+	}
+	return gerr.ErrSpliceFailed.Wrap(err)
+}
+
+// splice writes the data that was buffered from src to dst, and then copies the rest of
+// src to dst in chunks of chunkSize bytes, until an error occurs. The copied function is
+// called with the size of each chunk. io.CopyN uses splice on Linux if both src and dst are
+// TCP connections, and the copy is interrupted by closing them or by setting their deadlines.
+func splice(dst io.Writer, src io.Reader, buffered []byte, chunkSize int, copied func(int64)) error {
+	if chunkSize <= 0 {
+		chunkSize = config.DefaultChunkSize
+	}
+
+	if len(buffered) > 0 {
+		written, err := dst.Write(buffered)
+		copied(int64(written))
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+
+	for {
+		written, err := io.CopyN(dst, src, int64(chunkSize))
+		if written > 0 {
+			copied(written)
+		}
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/gatewayd-io/gatewayd/plugin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSplice tests that the buffered data is written before the rest of the data is copied
+// between the TCP connections, and that every chunk is counted.
+func TestSplice(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	dst, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer dst.Close()
+	receiver := <-accepted
+	defer receiver.Close()
+
+	data := bytes.Repeat([]byte("gatewayd"), 1000)
+	src := bytes.NewReader(data)
+	var counted int64
+	var chunks int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := splice(dst, src, []byte("buffered"), 1024, func(copied int64) {
+			counted += copied
+			chunks++
+		})
+		assert.ErrorIs(t, err, io.EOF)
+		dst.Close()
+	}()
+
+	received, err := io.ReadAll(receiver)
+	require.NoError(t, err)
+	<-done
+	assert.Equal(t, append([]byte("buffered"), data...), received)
+	assert.Equal(t, int64(len(received)), counted)
+	assert.Equal(t, 1+(len(data)+1023)/1024, chunks)
+}
+
+// TestCanSplice tests that the traffic of the sessions is not spliced if the splice is
+// disabled, or once the server tracks their activity for the drain or the idle timeout.
+func TestCanSplice(t *testing.T) {
+	proxy := &Proxy{
+		PoolMode: config.Session,
+		pluginRegistry: plugin.NewRegistry(
+			context.Background(),
+			config.Loose,
+			config.PassDown,
+			config.Accept,
+			config.Stop,
+			zerolog.Nop(),
+			true,
+		),
+	}
+	session := NewSession()
+	session.started = true
+	assert.True(t, proxy.canSplice(session, &Client{}))
+	proxy.DisableSplice = true
+	assert.False(t, proxy.canSplice(session, &Client{}))
+	proxy.DisableSplice = false
+
+	NewServer(
+		context.Background(),
+		"tcp",
+		"127.0.0.1:0",
+		config.DefaultTickInterval,
+		Option{ClientIdleTimeout: time.Minute},
+		proxy,
+		nil,
+		zerolog.Nop(),
+		nil,
+		config.DefaultPluginTimeout,
+		false,
+		"",
+		"",
+		config.DefaultHandshakeTimeout,
+	)
+	assert.False(t, proxy.canSplice(session, &Client{}))
+}
+
+// TestSpliceError tests that the copies that end with the connections are not reported as
+// failures, so that the clients are not sent an error.
+func TestSpliceError(t *testing.T) {
+	for _, err := range []error{io.EOF, net.ErrClosed, os.ErrDeadlineExceeded} {
+		spliceErr := spliceError(fmt.Errorf("read: %w", err))
+		assert.ErrorIs(t, spliceErr, gerr.ErrClientNotConnected)
+		assert.Nil(t, GatewayDErrorResponse(spliceErr))
+	}
+	assert.ErrorIs(t, spliceError(syscall.ECONNRESET), gerr.ErrSpliceFailed)
+}
@@ -0,0 +1,251 @@
+package network
+
+import (
+	"bytes"
+	"container/list"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// statementPrefix is the prefix of the names of the statements that are renamed by GatewayD.
+const statementPrefix = "gatewayd_"
+
+// statementCounter makes the names of the renamed statements unique.
+var statementCounter atomic.Uint64
+
+// preparedStatement is a named statement that is prepared by a client, and renamed to a
+// unique name, so that it can be prepared on any server connection in transaction pooling mode.
+type preparedStatement struct {
+	name  string
+	parse []byte
+}
+
+// expectedResponse is a response of the server that the statement cache expects, in order.
+// ParseComplete and CloseComplete messages answer the Parse and Close messages, and the
+// ReadyForQuery messages answer the Sync, Query and FunctionCall messages.
+type expectedResponse struct {
+	msgType byte
+	name    string
+	// swallow is set for the responses to the messages sent by GatewayD instead of the client.
+	swallow bool
+}
+
+// statementCache tracks the renamed statements that are prepared on a server connection,
+// and evicts the least recently used ones. It also tracks the responses to the messages that
+// GatewayD sends along with the requests of the clients, so that they are removed from the
+// responses before they reach the clients.
+type statementCache struct {
+	mu    sync.Mutex
+	order *list.List
+	names map[string]*list.Element
+	// pinned are the statements that are used by the current request, until its Sync,
+	// which are not evicted, since closing them would close the portals bound to them.
+	pinned   map[string]struct{}
+	expected []expectedResponse
+}
+
+// newStatementCache creates an empty statement cache.
+func newStatementCache() *statementCache {
+	return &statementCache{
+		order:  list.New(),
+		names:  map[string]*list.Element{},
+		pinned: map[string]struct{}{},
+	}
+}
+
+// reset forgets all the statements, after the server connection is reconnected or reset.
+func (sc *statementCache) reset() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.order.Init()
+	clear(sc.names)
+	clear(sc.pinned)
+	sc.expected = nil
+}
+
+// rewriteRequest renames the statements of the session in the request, and prepares them
+// before they are used if the server connection hasn't seen them yet. Once there are more
+// than maxStatements statements, the least recently used ones are closed, except the ones
+// that are used before the next Sync, which may exceed maxStatements. The statements
+// that are not prepared by the session are passed through, so the server answers them.
+func (sc *statementCache) rewriteRequest(
+	statements map[string]*preparedStatement, request []byte, maxStatements int,
+) []byte {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	rewritten := make([]byte, 0, len(request))
+	ForEachMessage(request, func(msgType byte, body []byte) bool {
+		msg := request[:PostgresMessageHeaderSize+len(body)]
+		request = request[len(msg):]
+
+		switch msgType {
+		case PostgresParse:
+			name, rest, _ := bytes.Cut(body, []byte{0})
+			if len(name) == 0 {
+				sc.expect(PostgresParseComplete, "", false)
+				break
+			}
+			statement := &preparedStatement{
+				name: statementPrefix + strconv.FormatUint(statementCounter.Add(1), 10),
+			}
+			statement.parse = newMessage(PostgresParse, cString(statement.name, rest))
+			statements[string(name)] = statement
+
+			rewritten = sc.add(rewritten, statement.name, maxStatements)
+			sc.expect(PostgresParseComplete, statement.name, false)
+			msg = statement.parse
+		case PostgresBind:
+			portal, rest, _ := bytes.Cut(body, []byte{0})
+			name, rest, _ := bytes.Cut(rest, []byte{0})
+			if statement, ok := statements[string(name)]; ok && len(name) > 0 {
+				rewritten = sc.prepare(rewritten, statement, maxStatements)
+				msg = newMessage(
+					PostgresBind, cString(string(portal), cString(statement.name, rest)))
+			}
+		case PostgresDescribe, PostgresClose:
+			if len(body) == 0 || body[0] != 'S' {
+				break
+			}
+			name, _, _ := bytes.Cut(body[1:], []byte{0})
+			statement, ok := statements[string(name)]
+			if !ok || len(name) == 0 {
+				break
+			}
+			if msgType == PostgresDescribe {
+				rewritten = sc.prepare(rewritten, statement, maxStatements)
+			} else {
+				delete(statements, string(name))
+				sc.remove(statement.name)
+			}
+			msg = newMessage(msgType, append([]byte{'S'}, cString(statement.name, nil)...))
+		case PostgresQuery, PostgresSync, PostgresFunctionCall:
+			sc.expect(PostgresReadyForQuery, "", false)
+			clear(sc.pinned)
+		}
+
+		if msgType == PostgresClose {
+			sc.expect(PostgresCloseComplete, "", false)
+		}
+		rewritten = append(rewritten, msg...)
+		return true
+	})
+
+	// Keep the rest of the request, if it is not a whole message.
+	return append(rewritten, request...)
+}
+
+// filterResponse removes the responses to the messages that GatewayD sent from the response.
+// An ErrorResponse makes the server skip the rest of the messages until the next Sync, so the
+// statements that they prepared are forgotten.
+func (sc *statementCache) filterResponse(response []byte) []byte {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if len(sc.expected) == 0 {
+		return response
+	}
+
+	filtered := response[:0:0]
+	rest := response
+	ForEachMessage(response, func(msgType byte, body []byte) bool {
+		msg := rest[:PostgresMessageHeaderSize+len(body)]
+		rest = rest[len(msg):]
+
+		switch msgType {
+		case PostgresParseComplete, PostgresCloseComplete:
+			if len(sc.expected) > 0 && sc.expected[0].msgType == msgType {
+				swallow := sc.expected[0].swallow
+				sc.expected = sc.expected[1:]
+				if swallow {
+					return true
+				}
+			}
+		case PostgresErrorResponse:
+			sc.skipUntil(false)
+		case PostgresReadyForQuery:
+			sc.skipUntil(true)
+		}
+		filtered = append(filtered, msg...)
+		return true
+	})
+
+	return append(filtered, rest...)
+}
+
+// skipUntil forgets the expected responses until the next ReadyForQuery, which the server
+// doesn't send after an error, and the ReadyForQuery itself if readyForQuery is set.
+// It must be called with the lock held.
+func (sc *statementCache) skipUntil(readyForQuery bool) {
+	for len(sc.expected) > 0 && sc.expected[0].msgType != PostgresReadyForQuery {
+		if sc.expected[0].msgType == PostgresParseComplete {
+			sc.remove(sc.expected[0].name)
+		}
+		sc.expected = sc.expected[1:]
+	}
+	if readyForQuery && len(sc.expected) > 0 {
+		sc.expected = sc.expected[1:]
+	}
+}
+
+// prepare adds a Parse message for the statement before the message that uses it, if the
+// server connection hasn't seen it yet. It must be called with the lock held.
+func (sc *statementCache) prepare(
+	rewritten []byte, statement *preparedStatement, maxStatements int,
+) []byte {
+	if element, ok := sc.names[statement.name]; ok {
+		sc.order.MoveToFront(element)
+		sc.pinned[statement.name] = struct{}{}
+		return rewritten
+	}
+
+	rewritten = sc.add(rewritten, statement.name, maxStatements)
+	sc.expect(PostgresParseComplete, statement.name, true)
+	return append(rewritten, statement.parse...)
+}
+
+// add marks the statement as prepared on the server connection, and adds Close messages for
+// the least recently used statements beyond maxStatements that are not pinned. It must be
+// called with the lock held.
+func (sc *statementCache) add(rewritten []byte, name string, maxStatements int) []byte {
+	element := sc.order.Back()
+	for sc.order.Len() >= maxStatements && element != nil {
+		evicted, _ := element.Value.(string)
+		element = element.Prev()
+		if _, ok := sc.pinned[evicted]; ok {
+			continue
+		}
+		sc.remove(evicted)
+		rewritten = append(rewritten,
+			newMessage(PostgresClose, append([]byte{'S'}, cString(evicted, nil)...))...)
+		sc.expect(PostgresCloseComplete, "", true)
+	}
+	sc.names[name] = sc.order.PushFront(name)
+	sc.pinned[name] = struct{}{}
+	return rewritten
+}
+
+// remove forgets the statement. It must be called with the lock held.
+func (sc *statementCache) remove(name string) {
+	if element, ok := sc.names[name]; ok {
+		sc.order.Remove(element)
+		delete(sc.names, name)
+		delete(sc.pinned, name)
+	}
+}
+
+// expect adds a response that the server sends after the messages that are already expected.
+// It must be called with the lock held.
+func (sc *statementCache) expect(msgType byte, name string, swallow bool) {
+	sc.expected = append(sc.expected, expectedResponse{msgType: msgType, name: name, swallow: swallow})
+}
+
+// cString returns the null-terminated string followed by the rest of a message body.
+func cString(value string, rest []byte) []byte {
+	data := make([]byte, 0, len(value)+1+len(rest))
+	data = append(data, value...)
+	data = append(data, 0)
+	return append(data, rest...)
+}
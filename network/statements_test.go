@@ -0,0 +1,181 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parseMessage creates a Parse message for the named statement without parameter types.
+func parseMessage(name, query string) []byte {
+	return CreatePostgreSQLPacket(PostgresParse, []byte(name+"\x00"+query+"\x00\x00\x00"))
+}
+
+// bindMessage creates a Bind message of the unnamed portal for the named statement.
+func bindMessage(name string) []byte {
+	return CreatePostgreSQLPacket(PostgresBind, []byte("\x00"+name+"\x00\x00\x00\x00\x00\x00\x00"))
+}
+
+// messageTypes returns the types of the messages in data.
+func messageTypes(data []byte) string {
+	var types []byte
+	ForEachMessage(data, func(msgType byte, _ []byte) bool {
+		types = append(types, msgType)
+		return true
+	})
+	return string(types)
+}
+
+// statementNames returns the statement names of the Parse, Bind and Close messages in data.
+func statementNames(data []byte) []string {
+	var names []string
+	ForEachMessage(data, func(msgType byte, body []byte) bool {
+		switch msgType {
+		case PostgresParse:
+			names = append(names, string(body[:indexOfNull(body)]))
+		case PostgresBind:
+			rest := body[indexOfNull(body)+1:]
+			names = append(names, string(rest[:indexOfNull(rest)]))
+		case PostgresClose:
+			names = append(names, string(body[1:len(body)-1]))
+		}
+		return true
+	})
+	return names
+}
+
+// indexOfNull returns the index of the first null byte in data.
+func indexOfNull(data []byte) int {
+	for i, b := range data {
+		if b == 0 {
+			return i
+		}
+	}
+	return len(data)
+}
+
+// TestStatementCache tests that the statements of a session are renamed, and prepared again
+// on the server connections that haven't seen them, without the client seeing the answers.
+func TestStatementCache(t *testing.T) {
+	sync := CreatePostgreSQLPacket(PostgresSync, nil)
+	parseComplete := CreatePostgreSQLPacket(PostgresParseComplete, nil)
+	bindComplete := CreatePostgreSQLPacket('2', nil)
+	readyForQuery := ReadyForQuery(PostgresTxIdle)
+
+	session := map[string]*preparedStatement{}
+	first := newStatementCache()
+
+	// The statement is renamed on the server connection where it is prepared.
+	request := first.rewriteRequest(
+		session, concat(parseMessage("s1", "SELECT 1"), bindMessage("s1"), sync), 10)
+	assert.Equal(t, "PBS", messageTypes(request))
+	names := statementNames(request)
+	require.Len(t, names, 2)
+	assert.Contains(t, names[0], statementPrefix)
+	assert.Equal(t, names[0], names[1])
+	response := concat(parseComplete, bindComplete, readyForQuery)
+	assert.Equal(t, response, first.filterResponse(response))
+
+	// Another server connection prepares it before it is bound, and the client
+	// doesn't see the ParseComplete of the statement it didn't send.
+	second := newStatementCache()
+	request = second.rewriteRequest(session, concat(bindMessage("s1"), sync), 10)
+	assert.Equal(t, "PBS", messageTypes(request))
+	assert.Equal(t, names, statementNames(request))
+	assert.Equal(t,
+		concat(bindComplete, readyForQuery),
+		second.filterResponse(concat(parseComplete, bindComplete, readyForQuery)))
+
+	// The server connection that has seen it only binds it.
+	request = second.rewriteRequest(session, concat(bindMessage("s1"), sync), 10)
+	assert.Equal(t, "BS", messageTypes(request))
+
+	// The statements that are not prepared by the session are passed through.
+	request = second.rewriteRequest(session, concat(bindMessage("unknown"), sync), 10)
+	assert.Equal(t, concat(bindMessage("unknown"), sync), request)
+}
+
+// TestStatementCacheEviction tests that the least recently used statements are closed once the
+// server connection has the maximum number of statements.
+func TestStatementCacheEviction(t *testing.T) {
+	sync := CreatePostgreSQLPacket(PostgresSync, nil)
+	parseComplete := CreatePostgreSQLPacket(PostgresParseComplete, nil)
+	closeComplete := CreatePostgreSQLPacket(PostgresCloseComplete, nil)
+	readyForQuery := ReadyForQuery(PostgresTxIdle)
+
+	session := map[string]*preparedStatement{}
+	cache := newStatementCache()
+	request := cache.rewriteRequest(session, concat(parseMessage("s1", "SELECT 1"), sync), 1)
+	assert.Equal(t, "PS", messageTypes(request))
+	assert.Equal(t,
+		concat(parseComplete, readyForQuery),
+		cache.filterResponse(concat(parseComplete, readyForQuery)))
+
+	request = cache.rewriteRequest(session, concat(parseMessage("s2", "SELECT 2"), sync), 1)
+	assert.Equal(t, "CPS", messageTypes(request))
+	assert.Equal(t, session["s1"].name, statementNames(request)[0])
+	assert.Equal(t,
+		concat(parseComplete, readyForQuery),
+		cache.filterResponse(concat(closeComplete, parseComplete, readyForQuery)))
+	assert.Equal(t, 1, cache.order.Len())
+}
+
+// TestStatementCachePipelineEviction tests that the statements bound earlier in a pipelined
+// request are not closed by the statements that the same request prepares after them, and that
+// they can be evicted once the request is synced.
+func TestStatementCachePipelineEviction(t *testing.T) {
+	sync := CreatePostgreSQLPacket(PostgresSync, nil)
+
+	session := map[string]*preparedStatement{}
+	cache := newStatementCache()
+	request := cache.rewriteRequest(session, concat(
+		parseMessage("s1", "SELECT 1"), bindMessage("s1"),
+		parseMessage("s2", "SELECT 2"), bindMessage("s2"),
+		parseMessage("s3", "SELECT 3"), bindMessage("s3"),
+		sync,
+	), 2)
+	assert.Equal(t, "PBPBPBS", messageTypes(request))
+	assert.Equal(t, 3, cache.order.Len())
+
+	// The next statement closes the least recently used ones down to maxStatements.
+	request = cache.rewriteRequest(session, concat(parseMessage("s4", "SELECT 4"), sync), 2)
+	assert.Equal(t, "CCPS", messageTypes(request))
+	assert.Equal(t,
+		[]string{session["s1"].name, session["s2"].name, session["s4"].name},
+		statementNames(request))
+	assert.Equal(t, 2, cache.order.Len())
+}
+
+// TestStatementCacheError tests that the statements are forgotten if the server skips their
+// Parse messages after an error, so they are prepared again with the next request.
+func TestStatementCacheError(t *testing.T) {
+	sync := CreatePostgreSQLPacket(PostgresSync, nil)
+	errorResponse := ErrorResponse(PostgresSeverityError, "42601", "syntax error")
+	readyForQuery := ReadyForQuery(PostgresTxIdle)
+
+	session := map[string]*preparedStatement{}
+	cache := newStatementCache()
+	cache.rewriteRequest(session, concat(parseMessage("s1", "SELEC 1"), sync), 10)
+	session["s2"] = &preparedStatement{name: "gatewayd_s2", parse: parseMessage("gatewayd_s2", "SELECT 2")}
+	request := cache.rewriteRequest(session, concat(bindMessage("s2"), sync), 10)
+	assert.Equal(t, "PBS", messageTypes(request))
+
+	// The first Parse fails, and the server answers the first Sync only.
+	response := concat(errorResponse, readyForQuery)
+	assert.Equal(t, response, cache.filterResponse(response))
+	assert.Equal(t, 1, cache.order.Len())
+
+	// The statement of the second request is still prepared.
+	request = cache.rewriteRequest(session, concat(bindMessage("s2"), sync), 10)
+	assert.Equal(t, "BS", messageTypes(request))
+}
+
+// concat concatenates the messages.
+func concat(messages ...[]byte) []byte {
+	var data []byte
+	for _, msg := range messages {
+		data = append(data, msg...)
+	}
+	return data
+}
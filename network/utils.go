@@ -160,3 +160,29 @@ func IsPostgresSSLRequest(data []byte) bool {
 
 	return true
 }
+
+// IsPostgresGSSEncRequest returns true if the message is a GSSENC request.
+func IsPostgresGSSEncRequest(data []byte) bool {
+	if len(data) < 2*PostgresLengthSize {
+		return false
+	}
+
+	if binary.BigEndian.Uint32(data[0:4]) != 2*PostgresLengthSize {
+		return false
+	}
+
+	return binary.BigEndian.Uint32(data[4:8]) == PostgresGSSENCRequest
+}
+
+// IsPostgresCancelRequest returns true if the message is a CancelRequest.
+func IsPostgresCancelRequest(data []byte) bool {
+	if len(data) != 4*PostgresLengthSize {
+		return false
+	}
+
+	if binary.BigEndian.Uint32(data[0:4]) != 4*PostgresLengthSize {
+		return false
+	}
+
+	return binary.BigEndian.Uint32(data[4:8]) == PostgresCancelRequest
+}
@@ -0,0 +1,19 @@
+package plugin
+
+import (
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+)
+
+// Hooks of GatewayD that are not in the SDK. The plugins attach to them by listing their
+// numbers in the hooks of their metadata, and receive them in their OnHook function, with
+// the name of the hook in the "hook" field of the arguments.
+const (
+	OnCopyStart v1.HookName = 1000
+	OnCopyEnd   v1.HookName = 1001
+)
+
+// HookNames are the names of the hooks of GatewayD that are not in the SDK.
+var HookNames = map[v1.HookName]string{
+	OnCopyStart: "HOOK_NAME_ON_COPY_START",
+	OnCopyEnd:   "HOOK_NAME_ON_COPY_END",
+}
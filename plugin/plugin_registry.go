@@ -26,12 +26,22 @@ import (
 type IHook interface {
 	AddHook(hookName v1.HookName, priority sdkPlugin.Priority, hookMethod sdkPlugin.Method)
 	Hooks() map[v1.HookName]map[sdkPlugin.Priority]sdkPlugin.Method
+	HasHooks(hookName v1.HookName) bool
+	SetResponseMode(hookName v1.HookName, priority sdkPlugin.Priority, mode config.ResponseMode)
+	ResponseMode(hookName v1.HookName, priority sdkPlugin.Priority) config.ResponseMode
 	Run(
 		ctx context.Context,
 		args map[string]interface{},
 		hookName v1.HookName,
 		opts ...grpc.CallOption,
 	) (map[string]interface{}, *gerr.GatewayDError)
+	RunWithResponseMode(
+		ctx context.Context,
+		args map[string]interface{},
+		hookName v1.HookName,
+		mode config.ResponseMode,
+		opts ...grpc.CallOption,
+	) (map[string]interface{}, *gerr.GatewayDError)
 }
 
 //nolint:interfacebloat
@@ -55,8 +65,10 @@ type IRegistry interface {
 type Registry struct {
 	plugins pool.IPool
 	hooks   map[v1.HookName]map[sdkPlugin.Priority]sdkPlugin.Method
-	ctx     context.Context //nolint:containedctx
-	devMode bool
+	// responseModes is the response mode of the hooks that don't use the default mode.
+	responseModes map[v1.HookName]map[sdkPlugin.Priority]config.ResponseMode
+	ctx           context.Context //nolint:containedctx
+	devMode       bool
 
 	Logger        zerolog.Logger
 	Compatibility config.CompatibilityPolicy
@@ -84,6 +96,7 @@ func NewRegistry(
 	return &Registry{
 		plugins:       pool.NewPool(regCtx, config.EmptyPoolCapacity),
 		hooks:         map[v1.HookName]map[sdkPlugin.Priority]sdkPlugin.Method{},
+		responseModes: map[v1.HookName]map[sdkPlugin.Priority]config.ResponseMode{},
 		ctx:           regCtx,
 		devMode:       devMode,
 		Logger:        logger,
@@ -232,6 +245,15 @@ func (reg *Registry) Hooks() map[v1.HookName]map[sdkPlugin.Priority]sdkPlugin.Me
 	return reg.hooks
 }
 
+// HasHooks returns true if any hook is registered for the hook name. It lets the callers
+// skip building the arguments of the hooks when nothing would receive them.
+func (reg *Registry) HasHooks(hookName v1.HookName) bool {
+	_, span := otel.Tracer(config.TracerName).Start(reg.ctx, "HasHooks")
+	defer span.End()
+
+	return len(reg.hooks[hookName]) > 0
+}
+
 // Add adds a hook with a priority to the hooks map.
 func (reg *Registry) AddHook(hookName v1.HookName, priority sdkPlugin.Priority, hookMethod sdkPlugin.Method) {
 	_, span := otel.Tracer(config.TracerName).Start(reg.ctx, "AddHook")
@@ -252,6 +274,31 @@ func (reg *Registry) AddHook(hookName v1.HookName, priority sdkPlugin.Priority,
 	}
 }
 
+// SetResponseMode sets how a hook receives the responses of the server.
+func (reg *Registry) SetResponseMode(
+	hookName v1.HookName, priority sdkPlugin.Priority, mode config.ResponseMode,
+) {
+	_, span := otel.Tracer(config.TracerName).Start(reg.ctx, "SetResponseMode")
+	defer span.End()
+
+	if len(reg.responseModes[hookName]) == 0 {
+		reg.responseModes[hookName] = map[sdkPlugin.Priority]config.ResponseMode{priority: mode}
+	} else {
+		reg.responseModes[hookName][priority] = mode
+	}
+}
+
+// ResponseMode returns how a hook receives the responses of the server.
+func (reg *Registry) ResponseMode(hookName v1.HookName, priority sdkPlugin.Priority) config.ResponseMode {
+	_, span := otel.Tracer(config.TracerName).Start(reg.ctx, "ResponseMode")
+	defer span.End()
+
+	if mode, ok := reg.responseModes[hookName][priority]; ok {
+		return mode
+	}
+	return config.DefaultResponseMode
+}
+
 // Run runs the hooks of a specific type. The result of the previous hook is passed
 // to the next hook as the argument, aka. chained. The context is passed to the
 // hooks as well to allow them to cancel the execution. The args are passed to the
@@ -273,12 +320,59 @@ func (reg *Registry) Run(
 	_, span := otel.Tracer(config.TracerName).Start(reg.ctx, "Run")
 	defer span.End()
 
+	return reg.runHooks(ctx, args, hookName, func(sdkPlugin.Priority) bool { return true }, opts...)
+}
+
+// RunWithResponseMode runs the hooks of a specific type that receive the responses of
+// the server in the given response mode, like Run. The hooks that don't set a response
+// mode are in the default mode, which is the stream mode.
+func (reg *Registry) RunWithResponseMode(
+	ctx context.Context,
+	args map[string]interface{},
+	hookName v1.HookName,
+	mode config.ResponseMode,
+	opts ...grpc.CallOption,
+) (map[string]interface{}, *gerr.GatewayDError) {
+	_, span := otel.Tracer(config.TracerName).Start(reg.ctx, "RunWithResponseMode")
+	defer span.End()
+
+	return reg.runHooks(ctx, args, hookName, func(priority sdkPlugin.Priority) bool {
+		return reg.ResponseMode(hookName, priority) == mode
+	}, opts...)
+}
+
+// runHooks runs the hooks of a specific type whose priority is accepted by the filter.
+// If no hook is accepted, the args are not converted and an empty result is returned.
+func (reg *Registry) runHooks(
+	ctx context.Context,
+	args map[string]interface{},
+	hookName v1.HookName,
+	filter func(priority sdkPlugin.Priority) bool,
+	opts ...grpc.CallOption,
+) (map[string]interface{}, *gerr.GatewayDError) {
+	_, span := otel.Tracer(config.TracerName).Start(reg.ctx, "runHooks")
+	defer span.End()
+
 	metrics.PluginHooksExecuted.Inc()
 
 	if ctx == nil {
 		return nil, gerr.ErrNilContext
 	}
 
+	// Sort hooks by priority.
+	priorities := make([]sdkPlugin.Priority, 0, len(reg.hooks[hookName]))
+	for priority := range reg.hooks[hookName] {
+		if filter(priority) {
+			priorities = append(priorities, priority)
+		}
+	}
+	if len(priorities) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	sort.SliceStable(priorities, func(i, j int) bool {
+		return priorities[i] < priorities[j]
+	})
+
 	// Inherit context.
 	inheritedCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -297,15 +391,6 @@ func (reg *Registry) Run(
 		return nil, gerr.ErrCastFailed.Wrap(err)
 	}
 
-	// Sort hooks by priority.
-	priorities := make([]sdkPlugin.Priority, 0, len(reg.hooks[hookName]))
-	for priority := range reg.hooks[hookName] {
-		priorities = append(priorities, priority)
-	}
-	sort.SliceStable(priorities, func(i, j int) bool {
-		return priorities[i] < priorities[j]
-	})
-
 	// Run hooks, passing the result of the previous hook to the next one.
 	returnVal := &v1.Struct{}
 	var removeList []sdkPlugin.Priority
@@ -379,6 +464,7 @@ func (reg *Registry) Run(
 	// Remove hooks that failed verification.
 	for _, priority := range removeList {
 		delete(reg.hooks[hookName], priority)
+		delete(reg.responseModes[hookName], priority)
 	}
 
 	return returnVal.AsMap(), nil
@@ -599,6 +685,24 @@ func (reg *Registry) LoadPlugins(
 				"Plugin doesn't attach to any hooks")
 		}
 
+		// Retrieve the response modes of the hooks, keyed by the name of the hook.
+		responseModes := map[v1.HookName]config.ResponseMode{}
+		if metadata.GetFields()["responseModes"] != nil &&
+			metadata.GetFields()["responseModes"].GetStructValue() != nil {
+			for key, value := range metadata.GetFields()["responseModes"].GetStructValue().AsMap() {
+				hookName, ok := v1.HookName_value[key]
+				mode, _ := value.(string)
+				if !ok || (mode != string(config.StreamResponse) && mode != string(config.SummaryResponse)) {
+					reg.Logger.Debug().Fields(map[string]interface{}{
+						"hook": key,
+						"mode": value,
+					}).Msg("Failed to decode plugin response mode")
+					continue
+				}
+				responseModes[v1.HookName(hookName)] = config.ResponseMode(mode)
+			}
+		}
+
 		// Retrieve plugin config.
 		plugin.Config = make(map[string]string)
 		if metadata.GetFields()["config"] != nil && metadata.GetFields()["config"].GetStructValue() != nil {
@@ -625,6 +729,9 @@ func (reg *Registry) LoadPlugins(
 		span.AddEvent("Plugin metadata loaded")
 
 		reg.RegisterHooks(pluginCtx, plugin.ID)
+		for hookName, mode := range responseModes {
+			reg.SetResponseMode(hookName, plugin.Priority, mode)
+		}
 		reg.Logger.Debug().Str("name", plugin.ID.Name).Msg("Plugin hooks registered")
 
 		span.AddEvent("Registered plugin hooks")
@@ -710,6 +817,8 @@ func (reg *Registry) RegisterHooks(ctx context.Context, pluginID sdkPlugin.Ident
 			hookMethod = pluginV1.OnShutdown
 		case v1.HookName_HOOK_NAME_ON_TICK:
 			hookMethod = pluginV1.OnTick
+		case OnCopyStart, OnCopyEnd:
+			hookMethod = pluginV1.OnHook
 		case v1.HookName_HOOK_NAME_ON_HOOK: // fallthrough
 		default:
 			switch reg.Acceptance {
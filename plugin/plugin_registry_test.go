@@ -336,3 +336,41 @@ func BenchmarkHookRun(b *testing.B) {
 		)
 	}
 }
+
+// Test_PluginRegistry_RunWithResponseMode tests that the hooks only run in their response mode.
+func Test_PluginRegistry_RunWithResponseMode(t *testing.T) {
+	reg := NewPluginRegistry(t)
+	hookName := v1.HookName_HOOK_NAME_ON_TRAFFIC_FROM_SERVER
+	ran := map[sdkPlugin.Priority]int{}
+	for _, priority := range []sdkPlugin.Priority{0, 1} {
+		priority := priority
+		reg.AddHook(hookName, priority, func(
+			ctx context.Context,
+			args *v1.Struct,
+			opts ...grpc.CallOption,
+		) (*v1.Struct, error) {
+			ran[priority]++
+			return args, nil
+		})
+	}
+	reg.SetResponseMode(hookName, 1, config.SummaryResponse)
+	assert.Equal(t, config.StreamResponse, reg.ResponseMode(hookName, 0))
+	assert.Equal(t, config.SummaryResponse, reg.ResponseMode(hookName, 1))
+
+	args := map[string]interface{}{"response": "summary"}
+	result, err := reg.RunWithResponseMode(context.Background(), args, hookName, config.SummaryResponse)
+	assert.Nil(t, err)
+	assert.Equal(t, args, result)
+	assert.Equal(t, map[sdkPlugin.Priority]int{1: 1}, ran)
+
+	// Both hooks run in the Run function, regardless of their response mode.
+	_, err = reg.Run(context.Background(), args, hookName)
+	assert.Nil(t, err)
+	assert.Equal(t, map[sdkPlugin.Priority]int{0: 1, 1: 2}, ran)
+
+	// No hook runs in a mode without hooks, and the result is empty.
+	result, err = reg.RunWithResponseMode(
+		context.Background(), args, v1.HookName_HOOK_NAME_ON_TRAFFIC_TO_CLIENT, config.StreamResponse)
+	assert.Nil(t, err)
+	assert.Empty(t, result)
+}
@@ -3,6 +3,7 @@ package pool
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/gatewayd-io/gatewayd/config"
 	gerr "github.com/gatewayd-io/gatewayd/errors"
@@ -22,12 +23,15 @@ type IPool interface {
 	Size() int
 	Clear()
 	Cap() int
+	IdleSince(key interface{}) time.Time
 }
 
 type Pool struct {
 	pool sync.Map
-	cap  int
-	ctx  context.Context //nolint:containedctx
+	// idleSince is the time at which each key/value pair was put in the pool.
+	idleSince sync.Map
+	cap       int
+	ctx       context.Context //nolint:containedctx
 }
 
 var _ IPool = (*Pool)(nil)
@@ -64,6 +68,7 @@ func (p *Pool) Put(key, value interface{}) *gerr.GatewayDError {
 	}
 
 	p.pool.Store(key, value)
+	p.idleSince.Store(key, time.Now())
 	return nil
 }
 
@@ -93,6 +98,9 @@ func (p *Pool) GetOrPut(key, value interface{}) (interface{}, bool, *gerr.Gatewa
 	}
 
 	val, loaded := p.pool.LoadOrStore(key, value)
+	if !loaded {
+		p.idleSince.Store(key, time.Now())
+	}
 	return val, loaded, nil
 }
 
@@ -104,6 +112,7 @@ func (p *Pool) Pop(key interface{}) interface{} {
 		return nil
 	}
 	if value, ok := p.pool.LoadAndDelete(key); ok {
+		p.idleSince.Delete(key)
 		return value
 	}
 	return nil
@@ -118,6 +127,7 @@ func (p *Pool) Remove(key interface{}) {
 	}
 	if _, ok := p.pool.Load(key); ok {
 		p.pool.Delete(key)
+		p.idleSince.Delete(key)
 	}
 }
 
@@ -139,6 +149,7 @@ func (p *Pool) Clear() {
 	_, span := otel.Tracer(config.TracerName).Start(p.ctx, "Clear")
 	defer span.End()
 	p.pool = sync.Map{}
+	p.idleSince = sync.Map{}
 }
 
 // Cap returns the capacity of the pool.
@@ -148,6 +159,19 @@ func (p *Pool) Cap() int {
 	return p.cap
 }
 
+// IdleSince returns the time at which the value of the given key was put in the pool,
+// or the zero time if the key is not in the pool.
+func (p *Pool) IdleSince(key interface{}) time.Time {
+	_, span := otel.Tracer(config.TracerName).Start(p.ctx, "IdleSince")
+	defer span.End()
+	if since, ok := p.idleSince.Load(key); ok {
+		if since, ok := since.(time.Time); ok {
+			return since
+		}
+	}
+	return time.Time{}
+}
+
 // NewPool creates a new pool with the given capacity.
 //
 //nolint:predeclared
@@ -156,8 +180,9 @@ func NewPool(ctx context.Context, cap int) *Pool {
 	defer span.End()
 
 	return &Pool{
-		pool: sync.Map{},
-		cap:  cap,
-		ctx:  poolCtx,
+		pool:      sync.Map{},
+		idleSince: sync.Map{},
+		cap:       cap,
+		ctx:       poolCtx,
 	}
 }
@@ -3,6 +3,7 @@ package pool
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/gatewayd-io/gatewayd/config"
 	"github.com/stretchr/testify/assert"
@@ -225,6 +226,35 @@ func TestPool_Cap(t *testing.T) {
 	assert.Equal(t, 1, pool.Cap())
 }
 
+// TestPool_IdleSince tests that the pool tracks when the values are put in it.
+func TestPool_IdleSince(t *testing.T) {
+	pool := NewPool(context.Background(), config.EmptyPoolCapacity)
+	defer pool.Clear()
+	assert.True(t, pool.IdleSince("client1.ID").IsZero())
+
+	before := time.Now()
+	err := pool.Put("client1.ID", "client1")
+	assert.Nil(t, err)
+	since := pool.IdleSince("client1.ID")
+	assert.False(t, since.Before(before))
+
+	// Putting the value back resets the idle time.
+	time.Sleep(10 * time.Millisecond)
+	err = pool.Put("client1.ID", "client1")
+	assert.Nil(t, err)
+	assert.True(t, pool.IdleSince("client1.ID").After(since))
+
+	_, loaded, err := pool.GetOrPut("client2.ID", "client2")
+	assert.Nil(t, err)
+	assert.False(t, loaded)
+	assert.False(t, pool.IdleSince("client2.ID").IsZero())
+
+	pool.Pop("client1.ID")
+	assert.True(t, pool.IdleSince("client1.ID").IsZero())
+	pool.Remove("client2.ID")
+	assert.True(t, pool.IdleSince("client2.ID").IsZero())
+}
+
 func BenchmarkNewPool(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		NewPool(context.Background(), config.EmptyPoolCapacity)